@@ -3,11 +3,16 @@ package nostr
 import (
 	"bytes"
 	"encoding/hex"
+	stdjson "encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/mailru/easyjson"
+	jlexer "github.com/mailru/easyjson/jlexer"
 	jwriter "github.com/mailru/easyjson/jwriter"
 	"github.com/tidwall/gjson"
 )
@@ -28,11 +33,23 @@ var (
 
 // ParseMessage parses a message into an Envelope.
 func ParseMessage(message []byte) Envelope {
-	firstComma := bytes.Index(message, []byte{','})
-	if firstComma == -1 {
+	return parseMessage(message, false)
+}
+
+// ParseMessageOrCustom is like ParseMessage, but returns a *CustomEnvelope for messages with labels it
+// doesn't know instead of nil.
+func ParseMessageOrCustom(message []byte) Envelope {
+	return parseMessage(message, true)
+}
+
+func parseMessage(message []byte, custom bool) Envelope {
+	label := message
+	if firstComma := bytes.Index(message, []byte{','}); firstComma != -1 {
+		label = message[0:firstComma]
+	} else if !custom {
+		// all the standard messages have something after the label
 		return nil
 	}
-	label := message[0:firstComma]
 
 	var v Envelope
 	switch {
@@ -58,7 +75,10 @@ func ParseMessage(message []byte) Envelope {
 		x := CloseEnvelope("")
 		v = &x
 	default:
-		return nil
+		if !custom {
+			return nil
+		}
+		v = &CustomEnvelope{}
 	}
 
 	if err := v.UnmarshalJSON(message); err != nil {
@@ -84,6 +104,7 @@ var (
 	_ Envelope = (*CloseEnvelope)(nil)
 	_ Envelope = (*OKEnvelope)(nil)
 	_ Envelope = (*AuthEnvelope)(nil)
+	_ Envelope = (*CustomEnvelope)(nil)
 )
 
 // EventEnvelope represents an EVENT message.
@@ -101,7 +122,9 @@ func (v *EventEnvelope) UnmarshalJSON(data []byte) error {
 	case 2:
 		return easyjson.Unmarshal([]byte(arr[1].Raw), &v.Event)
 	case 3:
-		v.SubscriptionID = &arr[1].Str
+		// copy it so we don't keep a reference into the parsed results
+		subID := arr[1].Str
+		v.SubscriptionID = &subID
 		return easyjson.Unmarshal([]byte(arr[2].Raw), &v.Event)
 	default:
 		return fmt.Errorf("failed to decode EVENT envelope")
@@ -109,18 +132,42 @@ func (v *EventEnvelope) UnmarshalJSON(data []byte) error {
 }
 
 func (v EventEnvelope) MarshalJSON() ([]byte, error) {
+	return v.marshalJSON(v.SubscriptionID)
+}
+
+// MarshalJSONWithSubID is like MarshalJSON, but it always emits the given subscription id instead of
+// v.SubscriptionID, without modifying the envelope, so the same envelope can be safely serialized for
+// many subscriptions at the same time.
+func (v EventEnvelope) MarshalJSONWithSubID(subID string) ([]byte, error) {
+	return v.marshalJSON(&subID)
+}
+
+func (v EventEnvelope) marshalJSON(subID *string) ([]byte, error) {
 	w := jwriter.Writer{NoEscapeHTML: true}
 	w.RawString(`["EVENT",`)
-	if v.SubscriptionID != nil {
-		w.RawString(`"`)
-		w.RawString(*v.SubscriptionID)
-		w.RawString(`",`)
+	if subID != nil {
+		w.String(*subID)
+		w.RawString(`,`)
 	}
 	v.Event.MarshalEasyJSON(&w)
 	w.RawString(`]`)
 	return w.BuildBytes()
 }
 
+// EnvelopeEvent returns the event carried by an EVENT envelope or by an AUTH envelope sent by a client, so
+// code that routes messages through the Envelope interface can look at its kind, author and so on.
+func EnvelopeEvent(env Envelope) (*Event, bool) {
+	switch v := env.(type) {
+	case *EventEnvelope:
+		return &v.Event, true
+	case *AuthEnvelope:
+		if v.Challenge == nil {
+			return &v.Event, true
+		}
+	}
+	return nil, false
+}
+
 // ReqEnvelope represents a REQ message.
 type ReqEnvelope struct {
 	SubscriptionID string
@@ -130,19 +177,34 @@ type ReqEnvelope struct {
 func (_ ReqEnvelope) Label() string { return "REQ" }
 
 func (v *ReqEnvelope) UnmarshalJSON(data []byte) error {
-	r := gjson.ParseBytes(data)
-	arr := r.Array()
-	if len(arr) < 3 {
-		return fmt.Errorf("failed to decode REQ envelope: missing filters")
+	// REQs are parsed in a single pass since relays get a lot of them
+	l := jlexer.Lexer{Data: data}
+	l.Delim('[')
+	l.Skip() // the label
+	l.WantComma()
+	v.SubscriptionID = l.String()
+	l.WantComma()
+	if err := l.Error(); err != nil {
+		return fmt.Errorf("failed to decode REQ envelope: %w", err)
 	}
-	v.SubscriptionID = arr[1].Str
-	v.Filters = make(Filters, len(arr)-2)
-	f := 0
-	for i := 2; i < len(arr); i++ {
-		if err := easyjson.Unmarshal([]byte(arr[i].Raw), &v.Filters[f]); err != nil {
-			return fmt.Errorf("%w -- on filter %d", err, f)
+
+	v.Filters = make(Filters, 0, 1)
+	for !l.IsDelim(']') {
+		var filter Filter
+		filter.UnmarshalEasyJSON(&l)
+		if err := l.Error(); err != nil {
+			return fmt.Errorf("%w -- on filter %d", err, len(v.Filters))
 		}
-		f++
+		v.Filters = append(v.Filters, filter)
+		l.WantComma()
+	}
+	l.Delim(']')
+	if err := l.Error(); err != nil {
+		return fmt.Errorf("failed to decode REQ envelope: %w", err)
+	}
+
+	if len(v.Filters) == 0 {
+		return fmt.Errorf("failed to decode REQ envelope: missing filters")
 	}
 
 	return nil
@@ -150,9 +212,8 @@ func (v *ReqEnvelope) UnmarshalJSON(data []byte) error {
 
 func (v ReqEnvelope) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{NoEscapeHTML: true}
-	w.RawString(`["REQ","`)
-	w.RawString(v.SubscriptionID)
-	w.RawString(`"`)
+	w.RawString(`["REQ",`)
+	w.String(v.SubscriptionID)
 	for _, filter := range v.Filters {
 		w.RawString(`,`)
 		filter.MarshalEasyJSON(&w)
@@ -161,12 +222,36 @@ func (v ReqEnvelope) MarshalJSON() ([]byte, error) {
 	return w.BuildBytes()
 }
 
+// CountDirection tells if a COUNT message is a request sent by a client or a response sent by a relay.
+type CountDirection int
+
+const (
+	// CountDirectionUnknown makes the direction be guessed from the message contents.
+	CountDirectionUnknown CountDirection = iota
+	CountRequest
+	CountResponse
+)
+
 // CountEnvelope represents a COUNT message.
 type CountEnvelope struct {
 	SubscriptionID string
 	Filters
 	Count       *int64
 	HyperLogLog []byte
+
+	// Direction, when set before unmarshaling, makes the message be decoded as that kind of COUNT
+	// instead of guessing it. See ParseCountEnvelope.
+	Direction CountDirection
+}
+
+// ParseCountEnvelope decodes a COUNT message that is known to be going in the given direction, so a relay
+// can use CountRequest to never take a client's filters for a count result.
+func ParseCountEnvelope(message []byte, direction CountDirection) (*CountEnvelope, error) {
+	v := &CountEnvelope{Direction: direction}
+	if err := v.UnmarshalJSON(message); err != nil {
+		return nil, err
+	}
+	return v, nil
 }
 
 func (_ CountEnvelope) Label() string { return "COUNT" }
@@ -175,6 +260,31 @@ func (c CountEnvelope) String() string {
 	return string(v)
 }
 
+// IsRequest tells if this is a COUNT sent by a client, i.e. one that has filters and no count, unless
+// Direction says otherwise.
+func (c CountEnvelope) IsRequest() bool {
+	if c.Direction != CountDirectionUnknown {
+		return c.Direction == CountRequest
+	}
+	return c.Count == nil && len(c.Filters) > 0
+}
+
+// IsResponse tells if this is a COUNT sent by a relay, i.e. one that has a count, unless Direction says
+// otherwise.
+//
+// An envelope with neither filters nor count nor Direction is neither a request nor a response.
+func (c CountEnvelope) IsResponse() bool {
+	if c.Direction != CountDirectionUnknown {
+		return c.Direction == CountResponse
+	}
+	return c.Count != nil
+}
+
+// UnmarshalJSON decodes a COUNT message as a request or as a response according to v.Direction. When that
+// is CountDirectionUnknown it is guessed: the message is a response if it has a single object after the
+// subscription id and that object has a numeric "count" and no keys other than "count", "hll" and
+// "approximate". Anything else is decoded as filters, so {"count":1,"kinds":[1]} is a filter, but {"count":1}
+// alone can only be told apart by setting Direction.
 func (v *CountEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
 	arr := r.Array()
@@ -183,17 +293,27 @@ func (v *CountEnvelope) UnmarshalJSON(data []byte) error {
 	}
 	v.SubscriptionID = arr[1].Str
 
-	var countResult struct {
-		Count *int64 `json:"count"`
-		HLL   string `json:"hll"`
-	}
-	if err := json.Unmarshal([]byte(arr[2].Raw), &countResult); err == nil && countResult.Count != nil {
+	if v.Direction == CountResponse ||
+		(v.Direction == CountDirectionUnknown && len(arr) == 3 && looksLikeCountResult(arr[2])) {
+		if len(arr) != 3 {
+			return fmt.Errorf("failed to decode COUNT envelope: expected a single count result")
+		}
+		var countResult struct {
+			Count *int64 `json:"count"`
+			HLL   string `json:"hll"`
+		}
+		if err := json.Unmarshal([]byte(arr[2].Raw), &countResult); err != nil {
+			return fmt.Errorf("failed to decode COUNT envelope: %w", err)
+		} else if countResult.Count == nil {
+			return fmt.Errorf("failed to decode COUNT envelope: missing count")
+		}
 		v.Count = countResult.Count
 		if len(countResult.HLL) == 512 {
-			v.HyperLogLog, err = hex.DecodeString(countResult.HLL)
+			hll, err := hex.DecodeString(countResult.HLL)
 			if err != nil {
 				return fmt.Errorf("invalid \"hll\" value in COUNT message: %w", err)
 			}
+			v.HyperLogLog = hll
 		}
 		return nil
 	}
@@ -213,15 +333,33 @@ func (v *CountEnvelope) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func looksLikeCountResult(r gjson.Result) bool {
+	if !r.IsObject() || r.Get("count").Type != gjson.Number {
+		return false
+	}
+	ok := true
+	r.ForEach(func(key, _ gjson.Result) bool {
+		switch key.Str {
+		case "count", "hll", "approximate":
+		default:
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
 func (v CountEnvelope) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{NoEscapeHTML: true}
-	w.RawString(`["COUNT","`)
-	w.RawString(v.SubscriptionID)
-	w.RawString(`"`)
+	w.RawString(`["COUNT",`)
+	w.String(v.SubscriptionID)
 	if v.Count != nil {
 		w.RawString(`,{"count":`)
 		w.RawString(strconv.FormatInt(*v.Count, 10))
 		if v.HyperLogLog != nil {
+			if len(v.HyperLogLog) != 256 {
+				return nil, fmt.Errorf("failed to encode COUNT envelope: hll must have 256 bytes, not %d", len(v.HyperLogLog))
+			}
 			w.RawString(`,"hll":"`)
 			hllHex := make([]byte, 512)
 			hex.Encode(hllHex, v.HyperLogLog)
@@ -239,14 +377,41 @@ func (v CountEnvelope) MarshalJSON() ([]byte, error) {
 	return w.BuildBytes()
 }
 
+// stringEnvelopeMaxPooled is the capacity above which buffers are not put back in stringEnvelopeBuffers,
+// so one huge NOTICE doesn't keep a huge buffer around.
+const stringEnvelopeMaxPooled = 4096
+
+var stringEnvelopeBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 128)
+		return &b
+	},
+}
+
+// stringEnvelopeString renders envelopes that are just a label and a string, like ["EOSE","<subid>"],
+// on a pooled buffer, since some relays call String() on every message they log.
+func stringEnvelopeString(label string, value string) string {
+	bp := stringEnvelopeBuffers.Get().(*[]byte)
+	b := append((*bp)[:0], `["`...)
+	b = append(b, label...)
+	b = append(b, `",`...)
+	// escapeString copies bytes as they are, so invalid UTF-8 must be replaced first (like jwriter.String does)
+	b = escapeString(b, strings.ToValidUTF8(value, "\ufffd"))
+	b = append(b, ']')
+	str := string(b)
+
+	if cap(b) <= stringEnvelopeMaxPooled {
+		*bp = b
+		stringEnvelopeBuffers.Put(bp)
+	}
+	return str
+}
+
 // NoticeEnvelope represents a NOTICE message.
 type NoticeEnvelope string
 
-func (_ NoticeEnvelope) Label() string { return "NOTICE" }
-func (n NoticeEnvelope) String() string {
-	v, _ := json.Marshal(n)
-	return string(v)
-}
+func (_ NoticeEnvelope) Label() string  { return "NOTICE" }
+func (n NoticeEnvelope) String() string { return stringEnvelopeString("NOTICE", string(n)) }
 
 func (v *NoticeEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
@@ -269,11 +434,8 @@ func (v NoticeEnvelope) MarshalJSON() ([]byte, error) {
 // EOSEEnvelope represents an EOSE (End of Stored Events) message.
 type EOSEEnvelope string
 
-func (_ EOSEEnvelope) Label() string { return "EOSE" }
-func (e EOSEEnvelope) String() string {
-	v, _ := json.Marshal(e)
-	return string(v)
-}
+func (_ EOSEEnvelope) Label() string  { return "EOSE" }
+func (e EOSEEnvelope) String() string { return stringEnvelopeString("EOSE", string(e)) }
 
 func (v *EOSEEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
@@ -296,11 +458,8 @@ func (v EOSEEnvelope) MarshalJSON() ([]byte, error) {
 // CloseEnvelope represents a CLOSE message.
 type CloseEnvelope string
 
-func (_ CloseEnvelope) Label() string { return "CLOSE" }
-func (c CloseEnvelope) String() string {
-	v, _ := json.Marshal(c)
-	return string(v)
-}
+func (_ CloseEnvelope) Label() string  { return "CLOSE" }
+func (c CloseEnvelope) String() string { return stringEnvelopeString("CLOSE", string(c)) }
 
 func (v *CloseEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
@@ -369,6 +528,64 @@ func (o OKEnvelope) String() string {
 	return string(v)
 }
 
+// MatchesEvent checks if this OK refers to the given event, by comparing its id with the
+// actual id computed from the event.
+func (o OKEnvelope) MatchesEvent(evt *Event) bool {
+	return len(o.EventID) == 64 && strings.EqualFold(o.EventID, evt.GetID())
+}
+
+// machine-readable prefixes relays use on OK and CLOSED messages, as in NIP-01 and NIP-42.
+var okReasonPrefixes = []string{
+	"duplicate",
+	"pow",
+	"blocked",
+	"rate-limited",
+	"invalid",
+	"restricted",
+	"mute",
+	"error",
+	"auth-required",
+}
+
+// NewOKAccept returns an OK message for an event that was accepted.
+func NewOKAccept(eventID string) OKEnvelope {
+	return OKEnvelope{EventID: eventID, OK: true}
+}
+
+// NewOKReject returns an OK message for an event that was rejected, with a reason in the form
+// "<prefix>: <message>". prefix must be one of the standard machine-readable prefixes, like
+// "invalid" or "rate-limited", otherwise "error" is used.
+func NewOKReject(eventID string, prefix string, message string) OKEnvelope {
+	if !slices.Contains(okReasonPrefixes, prefix) {
+		prefix = "error"
+	}
+	return OKEnvelope{EventID: eventID, OK: false, Reason: prefix + ": " + message}
+}
+
+// Prefix returns the machine-readable prefix of the reason, like "duplicate" or "blocked", or an
+// empty string if it doesn't have one of the standard prefixes.
+func (o OKEnvelope) Prefix() string {
+	prefix, _, found := strings.Cut(o.Reason, ":")
+	if !found || !slices.Contains(okReasonPrefixes, prefix) {
+		return ""
+	}
+	return prefix
+}
+
+// IsAuthOK tells if env is the OK a relay sends in response to the AUTH event with the given id (matched)
+// and, if it is, whether the authentication was accepted (ok).
+func IsAuthOK(env Envelope, authEventID string) (ok bool, matched bool) {
+	oke, isOK := env.(*OKEnvelope)
+	if !isOK {
+		return false, false
+	}
+
+	if len(oke.EventID) != 64 || !strings.EqualFold(oke.EventID, authEventID) {
+		return false, false
+	}
+	return oke.OK, true
+}
+
 func (v *OKEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
 	arr := r.Array()
@@ -384,9 +601,9 @@ func (v *OKEnvelope) UnmarshalJSON(data []byte) error {
 
 func (v OKEnvelope) MarshalJSON() ([]byte, error) {
 	w := jwriter.Writer{NoEscapeHTML: true}
-	w.RawString(`["OK","`)
-	w.RawString(v.EventID)
-	w.RawString(`",`)
+	w.RawString(`["OK",`)
+	w.String(v.EventID)
+	w.RawString(`,`)
 	ok := "false"
 	if v.OK {
 		ok = "true"
@@ -410,6 +627,58 @@ func (a AuthEnvelope) String() string {
 	return string(v)
 }
 
+// AuthEventMaxSkew is how far from the current time the created_at of an AUTH event can be for
+// AuthEnvelope.VerifyAuthEvent to accept it.
+const AuthEventMaxSkew Timestamp = 10 * 60
+
+// VerifyAuthEvent is the relay-side check for an AUTH message sent by a client as in NIP-42: it must carry
+// a kind 22242 event with a valid id and signature, created at most AuthEventMaxSkew seconds away from now,
+// a "relay" tag pointing to expectedRelay (compared after normalization) and a "challenge" tag equal to
+// expectedChallenge.
+func (a AuthEnvelope) VerifyAuthEvent(expectedRelay, expectedChallenge string) error {
+	if a.Challenge != nil {
+		return fmt.Errorf("got a challenge instead of an auth event")
+	}
+
+	evt := a.Event
+	if evt.Kind != KindClientAuthentication {
+		return fmt.Errorf("auth event has kind %d instead of %d", evt.Kind, KindClientAuthentication)
+	}
+
+	// otherwise an old auth event could be replayed whenever the relay reuses a challenge
+	if now := Now(); evt.CreatedAt < now-AuthEventMaxSkew || evt.CreatedAt > now+AuthEventMaxSkew {
+		return fmt.Errorf("auth event created_at %d is too far from the current time", evt.CreatedAt)
+	}
+
+	relayTag := evt.Tags.GetFirst([]string{"relay", ""})
+	if relayTag == nil {
+		return fmt.Errorf("auth event is missing the 'relay' tag")
+	}
+	if relay := NormalizeURL((*relayTag)[1]); relay == "" || relay != NormalizeURL(expectedRelay) {
+		return fmt.Errorf("auth event is for relay '%s', not '%s'", (*relayTag)[1], expectedRelay)
+	}
+
+	challengeTag := evt.Tags.GetFirst([]string{"challenge", ""})
+	if challengeTag == nil {
+		return fmt.Errorf("auth event is missing the 'challenge' tag")
+	}
+	if expectedChallenge == "" || (*challengeTag)[1] != expectedChallenge {
+		return fmt.Errorf("auth event has the wrong challenge")
+	}
+
+	// save these for last as they're the most expensive
+	if len(evt.ID) != 64 || !evt.CheckID() {
+		return fmt.Errorf("auth event id doesn't match its contents")
+	}
+	if ok, err := evt.CheckSignature(); err != nil {
+		return fmt.Errorf("auth event has an invalid signature: %w", err)
+	} else if !ok {
+		return fmt.Errorf("auth event has an invalid signature")
+	}
+
+	return nil
+}
+
 func (v *AuthEnvelope) UnmarshalJSON(data []byte) error {
 	r := gjson.ParseBytes(data)
 	arr := r.Array()
@@ -419,7 +688,9 @@ func (v *AuthEnvelope) UnmarshalJSON(data []byte) error {
 	if arr[1].IsObject() {
 		return easyjson.Unmarshal([]byte(arr[1].Raw), &v.Event)
 	} else {
-		v.Challenge = &arr[1].Str
+		// copy it so we don't keep a reference into the parsed results
+		challenge := arr[1].Str
+		v.Challenge = &challenge
 	}
 	return nil
 }
@@ -435,3 +706,59 @@ func (v AuthEnvelope) MarshalJSON() ([]byte, error) {
 	w.RawString(`]`)
 	return w.BuildBytes()
 }
+
+// CustomEnvelope represents a message with a label that isn't part of the standard, like relay-specific
+// extensions. Raw holds the JSON array of all the items that follow the label.
+type CustomEnvelope struct {
+	LabelName string
+	Raw       stdjson.RawMessage
+}
+
+func (v CustomEnvelope) Label() string { return v.LabelName }
+func (v CustomEnvelope) String() string {
+	j, _ := v.MarshalJSON()
+	return string(j)
+}
+
+func (v *CustomEnvelope) UnmarshalJSON(data []byte) error {
+	r := gjson.ParseBytes(data)
+	if !r.IsArray() {
+		return fmt.Errorf("failed to decode custom envelope: not an array")
+	}
+	arr := r.Array()
+	if len(arr) < 1 || arr[0].Type != gjson.String {
+		return fmt.Errorf("failed to decode custom envelope: missing label")
+	}
+	v.LabelName = arr[0].Str
+
+	w := jwriter.Writer{NoEscapeHTML: true}
+	w.RawByte('[')
+	for i, item := range arr[1:] {
+		if i > 0 {
+			w.RawByte(',')
+		}
+		w.RawString(item.Raw)
+	}
+	w.RawByte(']')
+	raw, err := w.BuildBytes()
+	v.Raw = raw
+	return err
+}
+
+func (v CustomEnvelope) MarshalJSON() ([]byte, error) {
+	w := jwriter.Writer{NoEscapeHTML: true}
+	w.RawByte('[')
+	w.Raw(json.Marshal(v.LabelName))
+	if raw := bytes.TrimSpace(v.Raw); len(raw) > 0 {
+		if len(raw) < 2 || raw[0] != '[' || raw[len(raw)-1] != ']' {
+			return nil, fmt.Errorf("failed to encode %s envelope: raw items must be a JSON array", v.LabelName)
+		}
+		// skip the brackets from the raw array and put the items directly after the label
+		if items := bytes.TrimSpace(raw[1 : len(raw)-1]); len(items) > 0 {
+			w.RawByte(',')
+			w.Raw(items, nil)
+		}
+	}
+	w.RawByte(']')
+	return w.BuildBytes()
+}
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mailru/easyjson"
 	"github.com/minio/simdjson-go"
+	"github.com/tidwall/gjson"
 )
 
 func BenchmarkParseMessage(b *testing.B) {
@@ -261,3 +263,53 @@ func generateRandomHex(length int) string {
 
 	return string(result)
 }
+
+func BenchmarkStringEnvelopeString(b *testing.B) {
+	notice := NoticeEnvelope("rate-limited: slow down, you are sending too many \"REQ\"s")
+	eose := EOSEEnvelope("sub-1234567890")
+
+	b.Run("notice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = notice.String()
+		}
+	})
+
+	b.Run("eose", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = eose.String()
+		}
+	})
+
+	b.Run("notice-json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			v, _ := json.Marshal(notice)
+			_ = string(v)
+		}
+	})
+}
+
+func BenchmarkReqEnvelopeUnmarshal(b *testing.B) {
+	req := []byte(`["REQ","sub-1",{"kinds":[1,6,7],"authors":["3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d","e8b487c079b0f67c695ae6c4c2552a47f38adfa2533cc5926bd2c102942fdcb7"],"since":1700000000,"limit":100},{"#e":["dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962"],"kinds":[1,7,9735]},{"ids":["9894b4b5cb5166d23ee8899a4151cf0c66aec00bde101982a13b8e8ceb972df9"]},{"kinds":[0,3,10002],"authors":["3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"]}]`)
+
+	b.Run("gjson+easyjson", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			arr := gjson.ParseBytes(req).Array()
+			env := ReqEnvelope{SubscriptionID: arr[1].Str, Filters: make(Filters, len(arr)-2)}
+			for f := 2; f < len(arr); f++ {
+				easyjson.Unmarshal([]byte(arr[f].Raw), &env.Filters[f-2])
+			}
+		}
+	})
+
+	b.Run("single-pass", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var env ReqEnvelope
+			env.UnmarshalJSON(req)
+		}
+	})
+}
@@ -15,6 +15,10 @@ type SIMDMessageParser struct {
 	TargetInternalArray *simdjson.Array  // used for tags array inside the event or each of the values in a filter
 	AuxArray            *simdjson.Array  // used either for each of the tags inside the event or for each of the multiple filters that may code
 	AuxIter             *simdjson.Iter
+
+	// ParseUnknown makes ParseMessage return a *CustomEnvelope for messages with labels it doesn't know
+	// instead of failing with UnknownLabel.
+	ParseUnknown bool
 }
 
 func (smp *SIMDMessageParser) ParseMessage(message []byte) (Envelope, error) {
@@ -114,7 +118,8 @@ func (smp *SIMDMessageParser) ParseMessage(message []byte) (Envelope, error) {
 			return nil, fmt.Errorf("missing json object")
 		}
 
-		if el, err := iter.FindElement(nil, "count"); err == nil {
+		// this is the same guess CountEnvelope.UnmarshalJSON does
+		if el, err := iter.FindElement(nil, "count"); err == nil && smp.looksLikeCountResult(&iter, el) {
 			c, _ := el.Iter.Uint()
 			count := int64(c)
 			v.Count = &count
@@ -205,6 +210,33 @@ func (smp *SIMDMessageParser) ParseMessage(message []byte) (Envelope, error) {
 		}
 		return v, nil
 	default:
-		return nil, UnknownLabel
+		if !smp.ParseUnknown {
+			return nil, UnknownLabel
+		}
+		v := &CustomEnvelope{}
+		if err := v.UnmarshalJSON(message); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+}
+
+func (smp *SIMDMessageParser) looksLikeCountResult(iter *simdjson.Iter, count *simdjson.Element) bool {
+	if t := count.Type; t != simdjson.TypeInt && t != simdjson.TypeUint && t != simdjson.TypeFloat {
+		return false
+	}
+	obj, err := iter.Object(smp.TargetObject)
+	if err != nil {
+		return false
 	}
+	smp.TargetObject = obj
+	ok := true
+	obj.ForEach(func(key []byte, _ simdjson.Iter) {
+		switch string(key) {
+		case "count", "hll", "approximate":
+		default:
+			ok = false
+		}
+	}, nil)
+	return ok
 }
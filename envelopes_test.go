@@ -1,7 +1,12 @@
 package nostr
 
 import (
+	stdjson "encoding/json"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/minio/simdjson-go"
 	"github.com/stretchr/testify/assert"
@@ -291,6 +296,11 @@ func TestParseMessageSIMD(t *testing.T) {
 			Message:          []byte(`["COUNT","sub1",{"count":42, "hll": "0100000101000000000000040000000001020000000002000000000200000003000002040000000101020001010000000000000007000004010000000200040000020400000000000102000002000004010000010000000301000102030002000301000300010000070000000001000004000102010000000400010002000000000103000100010001000001040100020001000000000000010000020000000000030100000001000400010000000000000901010100000000040000000b030000010100010000010000010000000003000000000000010003000100020000000000010000010100000100000104000200030001000300000001000101000102"}]`),
 			ExpectedEnvelope: &CountEnvelope{SubscriptionID: "sub1", Count: ptr(int64(42)), HyperLogLog: []byte{1, 0, 0, 1, 1, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 1, 2, 0, 0, 0, 0, 2, 0, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 2, 4, 0, 0, 0, 1, 1, 2, 0, 1, 1, 0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 4, 1, 0, 0, 0, 2, 0, 4, 0, 0, 2, 4, 0, 0, 0, 0, 0, 1, 2, 0, 0, 2, 0, 0, 4, 1, 0, 0, 1, 0, 0, 0, 3, 1, 0, 1, 2, 3, 0, 2, 0, 3, 1, 0, 3, 0, 1, 0, 0, 7, 0, 0, 0, 0, 1, 0, 0, 4, 0, 1, 2, 1, 0, 0, 0, 4, 0, 1, 0, 2, 0, 0, 0, 0, 1, 3, 0, 1, 0, 1, 0, 1, 0, 0, 1, 4, 1, 0, 2, 0, 1, 0, 0, 0, 0, 0, 0, 1, 0, 0, 2, 0, 0, 0, 0, 0, 3, 1, 0, 0, 0, 1, 0, 4, 0, 1, 0, 0, 0, 0, 0, 0, 9, 1, 1, 1, 0, 0, 0, 0, 4, 0, 0, 0, 11, 3, 0, 0, 1, 1, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 1, 0, 3, 0, 1, 0, 2, 0, 0, 0, 0, 0, 1, 0, 0, 1, 1, 0, 0, 1, 0, 0, 1, 4, 0, 2, 0, 3, 0, 1, 0, 3, 0, 0, 0, 1, 0, 1, 1, 0, 1, 2}},
 		},
+		{
+			Name:                   "COUNT envelope with a filter that has a count field",
+			Message:                []byte(`["COUNT","sub1",{"count":5,"kinds":[1]}]`),
+			ExpectedErrorSubstring: "unexpected filter field 'count'",
+		},
 		{
 			Name:             "REQ envelope",
 			Message:          []byte(`["REQ","sub1",   {"until": 999999, "kinds":[1]}]`),
@@ -328,3 +338,422 @@ func TestParseMessageSIMD(t *testing.T) {
 }
 
 func ptr[S any](s S) *S { return &s }
+
+func TestCustomEnvelope(t *testing.T) {
+	raw := `["RESTRICTED","auth-required",{"kinds":[4,1059],"pubkeys":["79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"]}]`
+
+	require.Nil(t, ParseMessage([]byte(raw)), "unknown labels should be ignored by default")
+
+	env := ParseMessageOrCustom([]byte(raw))
+	require.NotNil(t, env)
+	custom, ok := env.(*CustomEnvelope)
+	require.True(t, ok, "expected a custom envelope, got %T", env)
+	require.Equal(t, "RESTRICTED", custom.Label())
+	require.JSONEq(t, `["auth-required",{"kinds":[4,1059],"pubkeys":["79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"]}]`, string(custom.Raw))
+
+	res, err := custom.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, raw, string(res))
+
+	// standard envelopes are still parsed normally
+	require.IsType(t, new(EOSEEnvelope), ParseMessageOrCustom([]byte(`["EOSE","x"]`)))
+
+	// messages can be just a label
+	labelOnly := &CustomEnvelope{LabelName: "PING", Raw: []byte(`[]`)}
+	require.Equal(t, labelOnly, ParseMessageOrCustom([]byte(`["PING"]`)))
+	require.Equal(t, labelOnly, ParseMessageOrCustom([]byte(` [ "PING" ] `)))
+	require.Nil(t, ParseMessage([]byte(`["PING"]`)))
+	require.Nil(t, ParseMessageOrCustom([]byte(`["EOSE"]`)))
+
+	// the simdjson parser does the same when asked to
+	smp := SIMDMessageParser{AuxIter: &simdjson.Iter{}}
+	_, err = smp.ParseMessage([]byte(raw))
+	require.ErrorIs(t, err, UnknownLabel)
+	smp.ParseUnknown = true
+	env, err = smp.ParseMessage([]byte(raw))
+	require.NoError(t, err)
+	require.Equal(t, custom, env)
+	env, err = smp.ParseMessage([]byte(`["EOSE","x"]`))
+	require.NoError(t, err)
+	require.IsType(t, new(EOSEEnvelope), env)
+	env, err = smp.ParseMessage([]byte(`["PING"]`))
+	require.NoError(t, err)
+	require.Equal(t, labelOnly, env)
+
+	// and we can build custom envelopes ourselves
+	res, err = CustomEnvelope{LabelName: "PING", Raw: []byte(`[]`)}.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, `["PING"]`, string(res))
+}
+
+func TestEventEnvelopeMarshalWithSubID(t *testing.T) {
+	raw := `["EVENT",{"kind":1,"id":"dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962","pubkey":"3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d","created_at":1644271588,"tags":[],"content":"now that https://blueskyweb.org/blog/2-7-2022-overview was announced we can stop working on nostr?","sig":"230e9d8f0ddaf7eb70b5f7741ccfa37e87a455c9a469282e3464e2052d3192cd63a167e196e381ef9d7e69e9ea43af2443b839974dc85d8aaab9efe1d9296524"}]`
+	var env EventEnvelope
+	require.NoError(t, json.Unmarshal([]byte(raw), &env))
+
+	wg := sync.WaitGroup{}
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			subID := "sub" + strconv.Itoa(i)
+			res, err := env.MarshalJSONWithSubID(subID)
+			require.NoError(t, err)
+
+			var parsed EventEnvelope
+			require.NoError(t, json.Unmarshal(res, &parsed))
+			require.NotNil(t, parsed.SubscriptionID)
+			require.Equal(t, subID, *parsed.SubscriptionID)
+			require.Equal(t, env.ID, parsed.ID)
+		}()
+	}
+	wg.Wait()
+
+	require.Nil(t, env.SubscriptionID, "original envelope was modified")
+	res, err := env.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, raw, string(res))
+}
+
+func TestOKEnvelopeMatchesEvent(t *testing.T) {
+	evt := Event{Kind: 1, CreatedAt: 1672068534, Content: "hello", Tags: Tags{}}
+	evt.ID = evt.GetID()
+
+	require.True(t, OKEnvelope{EventID: evt.ID, OK: true}.MatchesEvent(&evt))
+	require.True(t, OKEnvelope{EventID: strings.ToUpper(evt.ID), OK: true}.MatchesEvent(&evt))
+	require.False(t, OKEnvelope{EventID: evt.ID[0:63], OK: true}.MatchesEvent(&evt))
+	require.False(t, OKEnvelope{EventID: "", OK: true}.MatchesEvent(&evt))
+	require.False(t, OKEnvelope{EventID: "dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962", OK: true}.MatchesEvent(&evt))
+
+	// the id is computed from the event contents, not taken from the .ID field
+	tampered := evt
+	tampered.Content = "bye"
+	require.False(t, OKEnvelope{EventID: evt.ID, OK: true}.MatchesEvent(&tampered))
+}
+
+func TestStringEnvelopeString(t *testing.T) {
+	for _, env := range []Envelope{
+		ptr(NoticeEnvelope("something \"bad\"\nhappened")),
+		ptr(EOSEEnvelope("sub1")),
+		ptr(CloseEnvelope("sub2")),
+	} {
+		expected, err := env.MarshalJSON()
+		require.NoError(t, err)
+		require.Equal(t, string(expected), env.String())
+
+		parsed := ParseMessage([]byte(env.String()))
+		require.Equal(t, env, parsed)
+	}
+
+	// invalid UTF-8 is replaced instead of making invalid json
+	bad := NoticeEnvelope("bad \xff\xfe bytes").String()
+	require.True(t, utf8.ValidString(bad))
+	require.True(t, stdjson.Valid([]byte(bad)))
+	require.Equal(t, ptr(NoticeEnvelope("bad \ufffd bytes")), ParseMessage([]byte(bad)))
+
+	// huge messages work too (but their buffers are not kept)
+	long := NoticeEnvelope(strings.Repeat("long ", 2000))
+	expected, err := long.MarshalJSON()
+	require.NoError(t, err)
+	require.Equal(t, string(expected), long.String())
+	require.Equal(t, string(expected), long.String())
+}
+
+func TestCountEnvelopeDirection(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		message    string
+		isRequest  bool
+		isResponse bool
+	}{
+		{"request", `["COUNT","sub",{"kinds":[1]},{"authors":["aa"]}]`, true, false},
+		{"response", `["COUNT","sub",{"count":12}]`, false, true},
+		{"response with hll", `["COUNT","sub",{"count":0,"hll":"` + strings.Repeat("00", 256) + `"}]`, false, true},
+		{"filter with a count field", `["COUNT","sub",{"count":5,"kinds":[1]}]`, true, false},
+		{"count with more filters", `["COUNT","sub",{"count":5},{"kinds":[1]}]`, true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, ok := ParseMessage([]byte(tc.message)).(*CountEnvelope)
+			require.True(t, ok)
+			require.Equal(t, tc.isRequest, env.IsRequest())
+			require.Equal(t, tc.isResponse, env.IsResponse())
+		})
+	}
+
+	// an envelope that has neither filters nor count is ambiguous and is neither
+	empty := CountEnvelope{SubscriptionID: "sub"}
+	require.False(t, empty.IsRequest())
+	require.False(t, empty.IsResponse())
+
+	// a count takes precedence even if there are filters
+	count := int64(1)
+	both := CountEnvelope{SubscriptionID: "sub", Filters: Filters{{Kinds: []int{1}}}, Count: &count}
+	require.False(t, both.IsRequest())
+	require.True(t, both.IsResponse())
+
+	// {"count":5} alone can't be told apart from a count result unless the direction is given
+	guessed, ok := ParseMessage([]byte(`["COUNT","sub",{"count":5}]`)).(*CountEnvelope)
+	require.True(t, ok)
+	require.True(t, guessed.IsResponse())
+
+	req, err := ParseCountEnvelope([]byte(`["COUNT","sub",{"count":5}]`), CountRequest)
+	require.NoError(t, err)
+	require.True(t, req.IsRequest())
+	require.False(t, req.IsResponse())
+	require.Nil(t, req.Count)
+	require.Len(t, req.Filters, 1)
+
+	req, err = ParseCountEnvelope([]byte(`["COUNT","sub",{"count":5,"kinds":[1]}]`), CountRequest)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, req.Filters[0].Kinds)
+
+	res, err := ParseCountEnvelope([]byte(`["COUNT","sub",{"count":5}]`), CountResponse)
+	require.NoError(t, err)
+	require.True(t, res.IsResponse())
+	require.Equal(t, int64(5), *res.Count)
+
+	_, err = ParseCountEnvelope([]byte(`["COUNT","sub",{"kinds":[1]}]`), CountResponse)
+	require.Error(t, err)
+}
+
+func TestEventEnvelopeSubscriptionIDSurvivesBufferReuse(t *testing.T) {
+	buf := []byte(`["EVENT","_",{"kind":1,"id":"dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962","pubkey":"3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d","created_at":1644271588,"tags":[],"content":"now that https://blueskyweb.org/blog/2-7-2022-overview was announced we can stop working on nostr?","sig":"230e9d8f0ddaf7eb70b5f7741ccfa37e87a455c9a469282e3464e2052d3192cd63a167e196e381ef9d7e69e9ea43af2443b839974dc85d8aaab9efe1d9296524"}]`)
+
+	env, ok := ParseMessage(buf).(*EventEnvelope)
+	require.True(t, ok)
+	require.NotNil(t, env.SubscriptionID)
+
+	// a read loop would reuse the same buffer for the next message
+	for i := range buf {
+		buf[i] = 'X'
+	}
+
+	require.Equal(t, "_", *env.SubscriptionID)
+}
+
+func TestAuthEnvelopeChallengeSurvivesBufferReuse(t *testing.T) {
+	buf := []byte(`["AUTH","challenge-string"]`)
+
+	env, ok := ParseMessage(buf).(*AuthEnvelope)
+	require.True(t, ok)
+	require.NotNil(t, env.Challenge)
+
+	// a read loop would reuse the same buffer for the next message
+	for i := range buf {
+		buf[i] = 'X'
+	}
+
+	require.Equal(t, "challenge-string", *env.Challenge)
+}
+
+func TestIsAuthOK(t *testing.T) {
+	sk := GeneratePrivateKey()
+	authEvent := Event{
+		Kind:      KindClientAuthentication,
+		CreatedAt: Now(),
+		Tags:      Tags{{"relay", "wss://relay.example.com"}, {"challenge", "abc"}},
+	}
+	require.NoError(t, authEvent.Sign(sk))
+
+	accepted := ParseMessage([]byte(`["OK","` + authEvent.ID + `",true,""]`))
+	ok, matched := IsAuthOK(accepted, authEvent.ID)
+	require.True(t, matched)
+	require.True(t, ok)
+
+	rejected := ParseMessage([]byte(`["OK","` + authEvent.ID + `",false,"restricted: not on the whitelist"]`))
+	ok, matched = IsAuthOK(rejected, authEvent.ID)
+	require.True(t, matched)
+	require.False(t, ok)
+
+	// ids are compared case-insensitively
+	ok, matched = IsAuthOK(&OKEnvelope{EventID: strings.ToUpper(authEvent.ID), OK: true}, authEvent.ID)
+	require.True(t, matched)
+	require.True(t, ok)
+
+	// OK for some other event
+	other := ParseMessage([]byte(`["OK","` + strings.Repeat("a", 64) + `",true,""]`))
+	ok, matched = IsAuthOK(other, authEvent.ID)
+	require.False(t, matched)
+	require.False(t, ok)
+
+	// not an OK at all
+	ok, matched = IsAuthOK(ParseMessage([]byte(`["NOTICE","`+authEvent.ID+`"]`)), authEvent.ID)
+	require.False(t, matched)
+	require.False(t, ok)
+}
+
+func TestOKEnvelopeConstructors(t *testing.T) {
+	id := "dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962"
+
+	accept := NewOKAccept(id)
+	require.True(t, accept.OK)
+	require.Equal(t, id, accept.EventID)
+	require.Empty(t, accept.Reason)
+	require.Empty(t, accept.Prefix())
+	j, _ := accept.MarshalJSON()
+	require.Equal(t, `["OK","`+id+`",true,""]`, string(j))
+
+	for _, prefix := range []string{
+		"duplicate", "pow", "blocked", "rate-limited", "invalid", "restricted", "mute", "error", "auth-required",
+	} {
+		t.Run(prefix, func(t *testing.T) {
+			reject := NewOKReject(id, prefix, "something happened")
+			require.False(t, reject.OK)
+			require.Equal(t, id, reject.EventID)
+			require.Equal(t, prefix+": something happened", reject.Reason)
+			require.Equal(t, prefix, reject.Prefix())
+
+			j, _ := reject.MarshalJSON()
+			parsed := ParseMessage(j).(*OKEnvelope)
+			require.Equal(t, reject, *parsed)
+		})
+	}
+
+	unknown := NewOKReject(id, "whatever", "something happened")
+	require.Equal(t, "error: something happened", unknown.Reason)
+	require.Equal(t, "error", unknown.Prefix())
+
+	require.Empty(t, OKEnvelope{Reason: "no prefix here"}.Prefix())
+	require.Empty(t, OKEnvelope{Reason: "weird: prefix"}.Prefix())
+}
+
+func TestReqEnvelopeUnmarshal(t *testing.T) {
+	var env ReqEnvelope
+	require.NoError(t, env.UnmarshalJSON([]byte(` [ "REQ" , "sub\"1" , {"kinds":[1],"limit":0} ,{"#t":["a"]}] `)))
+	require.Equal(t, `sub"1`, env.SubscriptionID)
+	require.Len(t, env.Filters, 2)
+	require.Equal(t, []int{1}, env.Filters[0].Kinds)
+	require.True(t, env.Filters[0].LimitZero)
+	require.Equal(t, TagMap{"t": {"a"}}, env.Filters[1].Tags)
+
+	for _, bad := range []string{
+		`["REQ"]`,
+		`["REQ","sub"]`,
+		`["REQ","sub",]`,
+		`["REQ",1,{}]`,
+		`["REQ","sub",{"kinds":"x"}]`,
+		`["REQ","sub",{}`,
+		`{"REQ":"sub"}`,
+	} {
+		var env ReqEnvelope
+		require.Error(t, env.UnmarshalJSON([]byte(bad)), bad)
+	}
+}
+
+func TestAuthEnvelopeVerifyAuthEvent(t *testing.T) {
+	sk := GeneratePrivateKey()
+	build := func(modify func(evt *Event)) AuthEnvelope {
+		evt := Event{
+			Kind:      KindClientAuthentication,
+			CreatedAt: Now(),
+			Tags:      Tags{{"relay", "wss://relay.example.com/"}, {"challenge", "abc"}},
+		}
+		modify(&evt)
+		require.NoError(t, evt.Sign(sk))
+		return AuthEnvelope{Event: evt}
+	}
+
+	valid := build(func(evt *Event) {})
+	require.NoError(t, valid.VerifyAuthEvent("wss://relay.example.com", "abc"))
+	require.NoError(t, valid.VerifyAuthEvent("relay.example.com", "abc"), "urls are normalized")
+	slightlyOld := build(func(evt *Event) { evt.CreatedAt -= 60 })
+	require.NoError(t, slightlyOld.VerifyAuthEvent("wss://relay.example.com", "abc"))
+
+	for name, tc := range map[string]struct {
+		env       AuthEnvelope
+		relay     string
+		challenge string
+	}{
+		"challenge envelope": {AuthEnvelope{Challenge: &[]string{"abc"}[0]}, "wss://relay.example.com", "abc"},
+		"wrong relay":        {valid, "wss://other.example.com", "abc"},
+		"wrong challenge":    {valid, "wss://relay.example.com", "xyz"},
+		"empty challenge":    {build(func(evt *Event) { evt.Tags[1][1] = "" }), "wss://relay.example.com", ""},
+		"wrong kind":         {build(func(evt *Event) { evt.Kind = 1 }), "wss://relay.example.com", "abc"},
+		"missing relay":      {build(func(evt *Event) { evt.Tags = evt.Tags[1:] }), "wss://relay.example.com", "abc"},
+		"missing challenge":  {build(func(evt *Event) { evt.Tags = evt.Tags[0:1] }), "wss://relay.example.com", "abc"},
+		"stale":              {build(func(evt *Event) { evt.CreatedAt -= AuthEventMaxSkew + 60 }), "wss://relay.example.com", "abc"},
+		"in the future":      {build(func(evt *Event) { evt.CreatedAt += AuthEventMaxSkew + 60 }), "wss://relay.example.com", "abc"},
+		"tampered": {func() AuthEnvelope {
+			env := build(func(evt *Event) {})
+			env.Event.Tags = Tags{{"relay", "wss://relay.example.com"}, {"challenge", "other"}}
+			return env
+		}(), "wss://relay.example.com", "other"},
+		"bad signature": {func() AuthEnvelope {
+			env := build(func(evt *Event) {})
+			env.Event.Sig = build(func(evt *Event) { evt.CreatedAt-- }).Event.Sig
+			return env
+		}(), "wss://relay.example.com", "abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Error(t, tc.env.VerifyAuthEvent(tc.relay, tc.challenge))
+		})
+	}
+}
+
+func TestEnvelopeMarshalErrors(t *testing.T) {
+	count := int64(1)
+
+	// these can't be encoded, so they must return an error instead of broken json
+	for _, env := range []Envelope{
+		&CountEnvelope{SubscriptionID: "x", Count: &count, HyperLogLog: make([]byte, 10)},
+		&CountEnvelope{SubscriptionID: "x", Count: &count, HyperLogLog: make([]byte, 300)},
+		&CustomEnvelope{LabelName: "WHATEVER", Raw: stdjson.RawMessage(`{"not":"an array"}`)},
+		&CustomEnvelope{LabelName: "WHATEVER", Raw: stdjson.RawMessage(`[1,2`)},
+	} {
+		b, err := env.MarshalJSON()
+		require.Error(t, err, "%T", env)
+		require.Nil(t, b)
+
+		// also when called through the json packages
+		_, err = json.Marshal(env)
+		require.Error(t, err)
+	}
+
+	// weird subscription and event ids must still produce valid json
+	subID := `weird"sub\id`
+	evt := Event{Kind: 1, Content: "hello", Tags: Tags{}}
+	for _, env := range []Envelope{
+		&EventEnvelope{SubscriptionID: &subID, Event: evt},
+		&ReqEnvelope{SubscriptionID: subID, Filters: Filters{{Kinds: []int{1}, Tags: TagMap{}}}},
+		&CountEnvelope{SubscriptionID: subID, Count: &count},
+		&OKEnvelope{EventID: `weird"event\id`, OK: false, Reason: "invalid: bad id"},
+	} {
+		b, err := env.MarshalJSON()
+		require.NoError(t, err)
+		require.True(t, stdjson.Valid(b), "%s", b)
+
+		parsed := ParseMessage(b)
+		require.NotNil(t, parsed, "%s", b)
+		require.Equal(t, env, parsed)
+	}
+}
+
+func TestEnvelopeEvent(t *testing.T) {
+	evt, ok := EnvelopeEvent(ParseMessage([]byte(`["EVENT","_",{"kind":7,"id":"dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962","pubkey":"3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d","created_at":1644271588,"tags":[],"content":"+","sig":"230e9d8f0ddaf7eb70b5f7741ccfa37e87a455c9a469282e3464e2052d3192cd63a167e196e381ef9d7e69e9ea43af2443b839974dc85d8aaab9efe1d9296524"}]`)))
+	require.True(t, ok)
+	require.Equal(t, 7, evt.Kind)
+	require.Equal(t, "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d", evt.PubKey)
+	require.Equal(t, Timestamp(1644271588), evt.CreatedAt)
+
+	auth := &AuthEnvelope{Event: Event{Kind: KindClientAuthentication, PubKey: "abc"}}
+	evt, ok = EnvelopeEvent(auth)
+	require.True(t, ok)
+	require.Equal(t, KindClientAuthentication, evt.Kind)
+
+	// it points to the event inside the envelope
+	evt.Content = "changed"
+	require.Equal(t, "changed", auth.Event.Content)
+
+	challenge := "challenge"
+	for _, env := range []Envelope{
+		&AuthEnvelope{Challenge: &challenge},
+		&ReqEnvelope{SubscriptionID: "x", Filters: Filters{{}}},
+		&OKEnvelope{EventID: "x", OK: true},
+		nil,
+	} {
+		evt, ok := EnvelopeEvent(env)
+		require.False(t, ok)
+		require.Nil(t, evt)
+	}
+}
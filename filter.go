@@ -1,7 +1,11 @@
 package nostr
 
 import (
+	"cmp"
+	"fmt"
+	"maps"
 	"slices"
+	"strings"
 
 	"github.com/mailru/easyjson"
 )
@@ -29,6 +33,8 @@ func (eff Filters) String() string {
 	return string(j)
 }
 
+// Match tells if any of the filters matches event. It can also be used to check if a subscription with
+// these filters would already get an event, so there is no need to open another one for it.
 func (eff Filters) Match(event *Event) bool {
 	for _, filter := range eff {
 		if filter.Matches(event) {
@@ -94,6 +100,40 @@ func (ef Filter) MatchesIgnoringTimestampConstraints(event *Event) bool {
 	return true
 }
 
+// MatchesWithIDPrefixes is like Matches, but each entry in IDs is taken as a hex prefix that matches any
+// event whose id starts with it (full ids still match themselves). Empty entries match nothing.
+//
+// This is for looking up events from truncated ids locally: relays don't do prefix matching, so a filter with
+// short ids must not be sent to them. A short prefix may be ambiguous and match more than one event, callers
+// that expect a single result must check for that themselves.
+func (ef Filter) MatchesWithIDPrefixes(event *Event) bool {
+	if event == nil {
+		return false
+	}
+
+	if ef.IDs != nil && !slices.ContainsFunc(ef.IDs, func(prefix string) bool {
+		return prefix != "" && strings.HasPrefix(event.ID, prefix)
+	}) {
+		return false
+	}
+
+	ef.IDs = nil
+	return ef.Matches(event)
+}
+
+// FilterForLatest returns the filter that fetches the latest version of evt, which must be replaceable or
+// addressable: its author and kind and, for addressable events, its "d" tag.
+func FilterForLatest(evt *Event) (Filter, error) {
+	switch {
+	case IsReplaceableKind(evt.Kind):
+		return Filter{Kinds: []int{evt.Kind}, Authors: []string{evt.PubKey}}, nil
+	case IsAddressableKind(evt.Kind):
+		return Filter{Kinds: []int{evt.Kind}, Authors: []string{evt.PubKey}, Tags: TagMap{"d": []string{evt.Tags.GetD()}}}, nil
+	default:
+		return Filter{}, fmt.Errorf("kind %d is neither replaceable nor addressable", evt.Kind)
+	}
+}
+
 func FilterEqual(a Filter, b Filter) bool {
 	if !similar(a.Kinds, b.Kinds) {
 		return false
@@ -170,6 +210,111 @@ func (ef Filter) Clone() Filter {
 	return clone
 }
 
+// Normalize returns a copy of the filter with ids, authors, kinds and tag values sorted and deduplicated,
+// such that filters that are logically the same become equal and serialize to the same JSON.
+func (ef Filter) Normalize() Filter {
+	norm := ef.Clone()
+	norm.IDs = sortedUnique(norm.IDs)
+	norm.Authors = sortedUnique(norm.Authors)
+	norm.Kinds = sortedUnique(norm.Kinds)
+	for k, v := range norm.Tags {
+		norm.Tags[k] = sortedUnique(v)
+	}
+	return norm
+}
+
+func sortedUnique[V cmp.Ordered](s []V) []V {
+	slices.Sort(s)
+	return slices.Compact(s)
+}
+
+// RelayLimits describes how many items of each kind a relay accepts in a single filter (0 means no limit).
+type RelayLimits struct {
+	MaxIDs       int
+	MaxAuthors   int
+	MaxKinds     int
+	MaxTagValues int
+
+	// SingleKindWithTags is for relays that reject filters combining tag conditions with more than one kind.
+	SingleKindWithTags bool
+
+	// MaxFilters caps how many filters a single filter can be split into (0 means no cap).
+	MaxFilters int
+}
+
+// SplitForRelay splits a filter with more items than what a relay accepts into many smaller filters that,
+// taken together (i.e. sent in the same REQ), match the same set of events as the original.
+//
+// Every piece keeps the original Limit, so together they may return more events than that (up to Limit
+// for each piece) -- callers that care must sort and trim the results themselves.
+//
+// Splitting many fields multiplies the number of filters. When MaxFilters is set, fields that would take
+// the count over it are left whole, so the relay may still reject the result.
+func (ef Filter) SplitForRelay(limits RelayLimits) []Filter {
+	maxKinds := limits.MaxKinds
+	if limits.SingleKindWithTags && len(ef.Tags) > 0 {
+		maxKinds = 1
+	}
+
+	filters := []Filter{ef}
+	filters = splitFiltersBy(filters, limits.MaxIDs, limits.MaxFilters,
+		func(f Filter) []string { return f.IDs },
+		func(f *Filter, v []string) { f.IDs = v })
+	filters = splitFiltersBy(filters, limits.MaxAuthors, limits.MaxFilters,
+		func(f Filter) []string { return f.Authors },
+		func(f *Filter, v []string) { f.Authors = v })
+	filters = splitFiltersBy(filters, maxKinds, limits.MaxFilters,
+		func(f Filter) []int { return f.Kinds },
+		func(f *Filter, v []int) { f.Kinds = v })
+	for _, key := range slices.Sorted(maps.Keys(ef.Tags)) {
+		filters = splitFiltersBy(filters, limits.MaxTagValues, limits.MaxFilters,
+			func(f Filter) []string { return f.Tags[key] },
+			func(f *Filter, v []string) { f.Tags[key] = v })
+	}
+	return filters
+}
+
+// SplitFilterRewriter returns a FilterRewriter that splits filters with SplitForRelay.
+func SplitFilterRewriter(limits RelayLimits) FilterRewriter {
+	return func(filters Filters, _ string) Filters {
+		split := make(Filters, 0, len(filters))
+		for _, filter := range filters {
+			split = append(split, filter.SplitForRelay(limits)...)
+		}
+		return split
+	}
+}
+
+func splitFiltersBy[V any](filters []Filter, limit int, maxFilters int, get func(Filter) []V, set func(*Filter, []V)) []Filter {
+	if limit <= 0 {
+		return filters
+	}
+
+	total := 0
+	for _, filter := range filters {
+		total += max(1, (len(get(filter))+limit-1)/limit)
+	}
+	if total == len(filters) || (maxFilters > 0 && total > maxFilters) {
+		return filters
+	}
+
+	result := make([]Filter, 0, total)
+	for _, filter := range filters {
+		values := get(filter)
+		if len(values) <= limit {
+			result = append(result, filter)
+			continue
+		}
+
+		for chunk := range slices.Chunk(values, limit) {
+			part := filter.Clone()
+			set(&part, slices.Clone(chunk))
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
 // GetTheoreticalLimit gets the maximum number of events that a normal filter would ever return, for example, if
 // there is a number of "ids" in the filter, the theoretical limit will be that number of ids.
 //
@@ -1,6 +1,9 @@
 package nostr
 
 import (
+	"maps"
+	"slices"
+
 	easyjson "github.com/mailru/easyjson"
 	jlexer "github.com/mailru/easyjson/jlexer"
 	jwriter "github.com/mailru/easyjson/jwriter"
@@ -261,8 +264,8 @@ func easyjson4d398eaaEncodeGithubComNbdWtfGoNostr(out *jwriter.Writer, in Filter
 		}
 		out.String(string(in.Search))
 	}
-	for tag, values := range in.Tags {
-		const prefix string = ",\"authors\":"
+	for _, tag := range slices.Sorted(maps.Keys(in.Tags)) {
+		values := in.Tags[tag]
 		if first {
 			first = false
 			out.RawString("\"#" + tag + "\":")
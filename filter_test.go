@@ -1,7 +1,9 @@
 package nostr
 
 import (
+	"fmt"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -142,6 +144,41 @@ func TestFilterClone(t *testing.T) {
 	assert.False(t, FilterEqual(flt, clone4), "modifying the clone since should cause it to not be equal anymore")
 }
 
+func TestFilterNormalize(t *testing.T) {
+	ts := Timestamp(1700000000)
+	a := Filter{
+		IDs:     []string{"b", "a", "b"},
+		Authors: []string{"dd", "cc"},
+		Kinds:   []int{7, 1, 1, 0},
+		Tags:    TagMap{"t": {"nostr", "bitcoin", "nostr"}, "e": {"y", "x"}},
+		Since:   &ts,
+		Limit:   20,
+	}
+	b := Filter{
+		IDs:     []string{"a", "b"},
+		Authors: []string{"cc", "dd", "cc"},
+		Kinds:   []int{0, 1, 7},
+		Tags:    TagMap{"e": {"x", "y", "x"}, "t": {"bitcoin", "nostr"}},
+		Since:   &ts,
+		Limit:   20,
+	}
+
+	na := a.Normalize()
+	nb := b.Normalize()
+	assert.True(t, FilterEqual(na, nb))
+
+	ja, err := json.Marshal(na)
+	assert.NoError(t, err)
+	jb, err := json.Marshal(nb)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ja), string(jb))
+	assert.Equal(t, `{"ids":["a","b"],"kinds":[0,1,7],"authors":["cc","dd"],"since":1700000000,"limit":20,"#e":["x","y"],"#t":["bitcoin","nostr"]}`, string(ja))
+
+	// the original is untouched
+	assert.Equal(t, []int{7, 1, 1, 0}, a.Kinds)
+	assert.Equal(t, []string{"nostr", "bitcoin", "nostr"}, a.Tags["t"])
+}
+
 func TestTheoreticalLimit(t *testing.T) {
 	require.Equal(t, 6, GetTheoreticalLimit(Filter{IDs: []string{"a", "b", "c", "d", "e", "f"}}))
 	require.Equal(t, 9, GetTheoreticalLimit(Filter{Authors: []string{"a", "b", "c"}, Kinds: []int{3, 0, 10002}}))
@@ -151,3 +188,159 @@ func TestTheoreticalLimit(t *testing.T) {
 	require.Equal(t, 24, GetTheoreticalLimit(Filter{Authors: []string{"a", "b", "c", "d", "e", "f"}, Kinds: []int{30023, 30024}, Tags: TagMap{"d": []string{"aaa", "bbb"}}}))
 	require.Equal(t, -1, GetTheoreticalLimit(Filter{Authors: []string{"a", "b", "c", "d", "e", "f"}, Kinds: []int{30023, 30024}}))
 }
+
+func TestFilterSplitForRelay(t *testing.T) {
+	ids := make([]string, 500)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%064x", i)
+	}
+	since := Timestamp(1700000000)
+	flt := Filter{IDs: ids, Kinds: []int{1}, Since: &since}
+
+	parts := flt.SplitForRelay(RelayLimits{MaxIDs: 150})
+	assert.Len(t, parts, 4)
+	all := make([]string, 0, 500)
+	for i, part := range parts {
+		if i < 3 {
+			assert.Len(t, part.IDs, 150)
+		} else {
+			assert.Len(t, part.IDs, 50)
+		}
+		assert.Equal(t, []int{1}, part.Kinds)
+		assert.Equal(t, since, *part.Since)
+		all = append(all, part.IDs...)
+	}
+	assert.Equal(t, ids, all)
+
+	// no limits, nothing to do
+	parts = flt.SplitForRelay(RelayLimits{})
+	assert.Len(t, parts, 1)
+	assert.True(t, FilterEqual(flt, parts[0]))
+
+	// many fields over the limit
+	flt = Filter{
+		Authors: []string{"a", "b", "c"},
+		Kinds:   []int{1, 6, 7},
+		Tags:    TagMap{"t": {"x", "y"}},
+	}
+	parts = flt.SplitForRelay(RelayLimits{MaxAuthors: 2, MaxKinds: 2, MaxTagValues: 1})
+	assert.Len(t, parts, 2*2*2)
+	for _, part := range parts {
+		assert.LessOrEqual(t, len(part.Authors), 2)
+		assert.LessOrEqual(t, len(part.Kinds), 2)
+		assert.Len(t, part.Tags["t"], 1)
+	}
+
+	// the split filters match exactly the same events as the original
+	for _, author := range []string{"a", "b", "c", "d"} {
+		for _, kind := range []int{1, 6, 7, 9} {
+			for _, tv := range []string{"x", "y", "z"} {
+				evt := &Event{PubKey: author, Kind: kind, Tags: Tags{{"t", tv}}}
+				assert.Equal(t, flt.Matches(evt), Filters(parts).Match(evt))
+			}
+		}
+	}
+
+	rewritten := SplitFilterRewriter(RelayLimits{MaxAuthors: 1})(Filters{flt, {Authors: []string{"e"}}}, "unsupported: too many authors")
+	assert.Len(t, rewritten, 4)
+
+	// relays that don't accept many kinds together with tags get one kind per filter
+	parts = flt.SplitForRelay(RelayLimits{SingleKindWithTags: true})
+	assert.Len(t, parts, 3)
+	for i, part := range parts {
+		assert.Equal(t, []int{flt.Kinds[i]}, part.Kinds)
+		assert.Equal(t, flt.Authors, part.Authors)
+		assert.Equal(t, flt.Tags, part.Tags)
+	}
+	parts = Filter{Kinds: []int{1, 6, 7}}.SplitForRelay(RelayLimits{SingleKindWithTags: true})
+	assert.Len(t, parts, 1)
+
+	// fields that would go over MaxFilters are left whole
+	parts = flt.SplitForRelay(RelayLimits{MaxAuthors: 2, MaxKinds: 2, MaxTagValues: 1, MaxFilters: 5})
+	assert.Len(t, parts, 4)
+	for _, part := range parts {
+		assert.LessOrEqual(t, len(part.Authors), 2)
+		assert.LessOrEqual(t, len(part.Kinds), 2)
+		assert.Equal(t, []string{"x", "y"}, part.Tags["t"])
+	}
+}
+
+func TestFiltersMatch(t *testing.T) {
+	since := Timestamp(1000)
+	filters := Filters{
+		{Kinds: []int{KindTextNote}, Authors: []string{"a"}},
+		{Kinds: []int{KindReaction}, Tags: TagMap{"e": []string{"x"}}, Since: &since},
+	}
+
+	require.True(t, filters.Match(&Event{Kind: KindTextNote, PubKey: "a", CreatedAt: 1}))
+	require.True(t, filters.Match(&Event{Kind: KindReaction, PubKey: "b", CreatedAt: 1001, Tags: Tags{{"e", "x"}}}))
+
+	require.False(t, filters.Match(&Event{Kind: KindTextNote, PubKey: "b", CreatedAt: 1}), "wrong author")
+	require.False(t, filters.Match(&Event{Kind: KindReaction, PubKey: "b", CreatedAt: 1001, Tags: Tags{{"e", "y"}}}), "wrong tag")
+	require.False(t, filters.Match(&Event{Kind: KindReaction, PubKey: "b", CreatedAt: 999, Tags: Tags{{"e", "x"}}}), "too old")
+	require.False(t, filters.Match(&Event{Kind: KindRepost, PubKey: "a", CreatedAt: 1}), "wrong kind")
+	require.False(t, Filters{}.Match(&Event{Kind: KindTextNote, PubKey: "a"}), "no filters")
+}
+
+func TestFilterMatchesWithIDPrefixes(t *testing.T) {
+	evt1 := &Event{ID: "abcd1234" + strings.Repeat("0", 56), Kind: KindTextNote}
+	evt2 := &Event{ID: "abcd5678" + strings.Repeat("0", 56), Kind: KindTextNote}
+	evt3 := &Event{ID: "ffff0000" + strings.Repeat("0", 56), Kind: KindReaction}
+
+	// full ids work as usual
+	full := Filter{IDs: []string{evt1.ID}}
+	require.True(t, full.MatchesWithIDPrefixes(evt1))
+	require.False(t, full.MatchesWithIDPrefixes(evt2))
+	require.Equal(t, full.Matches(evt1), full.MatchesWithIDPrefixes(evt1))
+
+	// an unambiguous prefix
+	prefix := Filter{IDs: []string{"abcd12"}}
+	require.True(t, prefix.MatchesWithIDPrefixes(evt1))
+	require.False(t, prefix.MatchesWithIDPrefixes(evt2))
+	require.False(t, prefix.Matches(evt1), "plain Matches doesn't do prefixes")
+
+	// an ambiguous prefix matches all candidates
+	ambiguous := Filter{IDs: []string{"abcd"}}
+	require.True(t, ambiguous.MatchesWithIDPrefixes(evt1))
+	require.True(t, ambiguous.MatchesWithIDPrefixes(evt2))
+	require.False(t, ambiguous.MatchesWithIDPrefixes(evt3))
+
+	// the other conditions still apply
+	withKind := Filter{IDs: []string{"abcd", "ffff"}, Kinds: []int{KindReaction}}
+	require.False(t, withKind.MatchesWithIDPrefixes(evt1))
+	require.True(t, withKind.MatchesWithIDPrefixes(evt3))
+
+	require.False(t, Filter{IDs: []string{""}}.MatchesWithIDPrefixes(evt1), "empty prefix")
+	require.False(t, Filter{IDs: []string{}}.MatchesWithIDPrefixes(evt1))
+	require.True(t, Filter{}.MatchesWithIDPrefixes(evt1))
+	require.False(t, prefix.MatchesWithIDPrefixes(nil))
+}
+
+func TestFilterForLatest(t *testing.T) {
+	pk := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+
+	for _, kind := range []int{0, 3, 10002, 19999} {
+		filter, err := FilterForLatest(&Event{Kind: kind, PubKey: pk, Tags: Tags{{"d", "ignored"}}})
+		require.NoError(t, err)
+		require.Equal(t, Filter{Kinds: []int{kind}, Authors: []string{pk}}, filter)
+	}
+
+	for _, tc := range []struct {
+		tags Tags
+		d    string
+	}{
+		{Tags{{"title", "x"}, {"d", "article"}}, "article"},
+		{Tags{{"d", ""}}, ""},
+	} {
+		evt := &Event{Kind: 30023, PubKey: pk, Tags: tc.tags}
+		filter, err := FilterForLatest(evt)
+		require.NoError(t, err)
+		require.Equal(t, Filter{Kinds: []int{30023}, Authors: []string{pk}, Tags: TagMap{"d": []string{tc.d}}}, filter)
+		require.True(t, filter.Matches(evt))
+	}
+
+	for _, kind := range []int{1, 7, 9999, 20001, 40000} {
+		_, err := FilterForLatest(&Event{Kind: kind, PubKey: pk})
+		require.Error(t, err, "kind %d", kind)
+	}
+}
@@ -0,0 +1,52 @@
+package nip19
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FiltersFromCodes translates a list of "note", "nevent" and "naddr" codes into filters that can be used
+// in a single REQ to fetch all the events they reference.
+//
+// All the event ids are put in the same filter and each "naddr" gets its own filter. It also returns the
+// relay hints found in each code (keyed by the code) so the caller can decide where to send the filters.
+func FiltersFromCodes(codes []string) (nostr.Filters, map[string][]string, error) {
+	filters := make(nostr.Filters, 0, 1+len(codes)/2)
+	hints := make(map[string][]string, len(codes))
+	ids := make([]string, 0, len(codes))
+
+	for _, code := range codes {
+		prefix, data, err := Decode(code)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode '%s': %w", code, err)
+		}
+
+		switch prefix {
+		case "note":
+			ids = append(ids, data.(string))
+		case "nevent":
+			ep := data.(nostr.EventPointer)
+			ids = append(ids, ep.ID)
+			if len(ep.Relays) > 0 {
+				hints[code] = ep.Relays
+			}
+		case "naddr":
+			ep := data.(nostr.EntityPointer)
+			filters = append(filters, ep.AsFilter())
+			if len(ep.Relays) > 0 {
+				hints[code] = ep.Relays
+			}
+		default:
+			return nil, nil, fmt.Errorf("'%s' is a %s, not an event reference", code, prefix)
+		}
+	}
+
+	if len(ids) > 0 {
+		slices.Sort(ids)
+		filters = append(nostr.Filters{{IDs: slices.Compact(ids)}}, filters...)
+	}
+
+	return filters, hints, nil
+}
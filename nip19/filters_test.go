@@ -0,0 +1,46 @@
+package nip19
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiltersFromCodes(t *testing.T) {
+	id1 := "dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962"
+	id2 := "9894b4b5cb5166d23ee8899a4151cf0c66aec00bde101982a13b8e8ceb972df9"
+	pk := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+
+	note, _ := EncodeNote(id1)
+	nevent, _ := EncodeEvent(id2, []string{"wss://relay.one"}, pk)
+	neventNoRelays, _ := EncodeEvent(id1, nil, "")
+	naddr, _ := EncodeEntity(pk, 30023, "article", []string{"wss://relay.two", "wss://relay.three"})
+
+	filters, hints, err := FiltersFromCodes([]string{note, nevent, naddr, neventNoRelays})
+	require.NoError(t, err)
+	require.Len(t, filters, 2)
+
+	require.Equal(t, []string{id2, id1}, filters[0].IDs)
+	require.Equal(t, []int{30023}, filters[1].Kinds)
+	require.Equal(t, []string{pk}, filters[1].Authors)
+	require.Equal(t, nostr.TagMap{"d": {"article"}}, filters[1].Tags)
+
+	require.Equal(t, map[string][]string{
+		nevent: {"wss://relay.one"},
+		naddr:  {"wss://relay.two", "wss://relay.three"},
+	}, hints)
+
+	// only naddrs, no ids filter
+	filters, _, err = FiltersFromCodes([]string{naddr})
+	require.NoError(t, err)
+	require.Len(t, filters, 1)
+	require.Nil(t, filters[0].IDs)
+
+	npub, _ := EncodePublicKey(pk)
+	_, _, err = FiltersFromCodes([]string{note, npub})
+	require.Error(t, err)
+
+	_, _, err = FiltersFromCodes([]string{"nevent1invalid"})
+	require.Error(t, err)
+}
@@ -0,0 +1,41 @@
+package nip29
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NewChatMessage returns an unsigned kind 9 chat message to be sent to the group with the given id.
+//
+// To make it a reply add a "q" tag pointing to the message being replied to.
+func NewChatMessage(groupID string, content string) *nostr.Event {
+	return &nostr.Event{
+		Kind:      nostr.KindSimpleGroupChatMessage,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"h", groupID}},
+		Content:   content,
+	}
+}
+
+// ParseChatMessage reads the group id and the content of a kind 9 chat message, along with the id of the
+// message it replies to (from a "q" tag or, failing that, an "e" tag), if any.
+func ParseChatMessage(evt *nostr.Event) (groupID string, content string, replyTo string, err error) {
+	if evt.Kind != nostr.KindSimpleGroupChatMessage {
+		return "", "", "", fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupChatMessage, evt.Kind)
+	}
+
+	groupID, ok := GroupID(evt)
+	if !ok {
+		return "", "", "", fmt.Errorf("missing or invalid \"h\" tag: %w", ErrInvalidGroupID)
+	}
+
+	for _, name := range []string{"q", "e"} {
+		if tag := evt.Tags.GetFirst([]string{name, ""}); tag != nil && nostr.IsValid32ByteHex((*tag)[1]) {
+			replyTo = (*tag)[1]
+			break
+		}
+	}
+
+	return groupID, evt.Content, replyTo, nil
+}
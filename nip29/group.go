@@ -1,7 +1,10 @@
 package nip29
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"maps"
 	"net/url"
 	"slices"
 	"strings"
@@ -9,6 +12,15 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 )
 
+// ErrMissingDTag is returned when a group event that must be addressed by a "d" tag doesn't have one.
+var ErrMissingDTag = errors.New("missing \"d\" tag")
+
+// ErrInvalidGroupID is returned when a group id doesn't pass IsValidGroupID.
+var ErrInvalidGroupID = errors.New("invalid group id")
+
+// ErrWrongGroup is returned when an event given to a group is addressed to another group.
+var ErrWrongGroup = errors.New("event is for another group")
+
 type GroupAddress struct {
 	Relay string
 	ID    string
@@ -38,6 +50,8 @@ func ParseGroupAddress(raw string) (GroupAddress, error) {
 type Group struct {
 	Address GroupAddress
 
+	// Name defaults to the group id when the group has no name of its own, in which case no "name" tag
+	// is emitted by ToMetadataEvent (unless it was explicitly given in a metadata event).
 	Name    string
 	Picture string
 	About   string
@@ -47,10 +61,35 @@ type Group struct {
 
 	Roles []*Role
 
+	// DefaultRole, if set, is given to new members that join or are added without any explicit roles.
+	// each member gets their own copy of it.
+	DefaultRole *Role
+
+	// RelayHints are optional relay URLs for members, keyed by pubkey. In the admins event they are emitted
+	// as the last item of the "p" tag, after the role names: ["p", <pubkey>, <role>..., <relay>].
+	RelayHints map[string]string
+
+	// PendingJoins are join requests that are waiting for an admin to act, keyed by pubkey.
+	PendingJoins map[string]nostr.Timestamp
+
+	// OnMembersChanged, if set, is called whenever an Action adds or removes members from the group.
+	OnMembersChanged func(added, removed []string)
+
 	LastMetadataUpdate nostr.Timestamp
 	LastAdminsUpdate   nostr.Timestamp
 	LastMembersUpdate  nostr.Timestamp
 	LastRolesUpdate    nostr.Timestamp
+
+	// hasName is set when a merged metadata event had a "name" tag, so it is kept even if equal to the id
+	hasName bool
+
+	// lastAppliedByKind is the created_at of the newest event of each replaceable kind merged into the group
+	lastAppliedByKind map[int]nostr.Timestamp
+
+	// stateEvents are the last admins and members events merged and moderationLog the moderation events given
+	// to ApplyEvent since them, sorted, so the members can be recomputed when an event arrives out of order
+	stateEvents   map[int]*nostr.Event
+	moderationLog []loggedEvent
 }
 
 func (group Group) String() string {
@@ -100,25 +139,41 @@ func NewGroup(gadstr string) (Group, error) {
 		return Group{}, fmt.Errorf("invalid group id '%s': %w", gadstr, err)
 	}
 
-	return Group{
-		Address: gad,
-		Name:    gad.ID,
-		Members: make(map[string][]*Role),
-	}, nil
+	id := NormalizeGroupID(gad.ID)
+	if id == "" {
+		return Group{}, fmt.Errorf("invalid group id '%s': %w", gadstr, ErrInvalidGroupID)
+	}
+
+	group := NewGroupWithID(id)
+	group.Address.Relay = gad.Relay
+	return *group, nil
+}
+
+// NewGroupWithID returns a Group with only the id set and all its maps initialized, so events can be
+// merged and actions applied to it incrementally.
+func NewGroupWithID(id string) *Group {
+	return &Group{
+		Address:      GroupAddress{ID: id},
+		Name:         id,
+		Members:      make(map[string][]*Role),
+		RelayHints:   make(map[string]string),
+		PendingJoins: make(map[string]nostr.Timestamp),
+	}
 }
 
 func NewGroupFromMetadataEvent(relayURL string, evt *nostr.Event) (Group, error) {
-	g := Group{
-		Address: GroupAddress{
-			Relay: relayURL,
-			ID:    evt.Tags.GetD(),
-		},
-		Name:    evt.Tags.GetD(),
-		Members: make(map[string][]*Role),
+	if evt.Tags.GetD() == "" {
+		return Group{}, ErrMissingDTag
+	}
+	if !IsValidGroupID(evt.Tags.GetD()) {
+		return Group{}, ErrInvalidGroupID
 	}
 
+	g := NewGroupWithID(evt.Tags.GetD())
+	g.Address.Relay = relayURL
+
 	err := g.MergeInMetadataEvent(evt)
-	return g, err
+	return *g, err
 }
 
 func (group Group) ToMetadataEvent() *nostr.Event {
@@ -129,7 +184,7 @@ func (group Group) ToMetadataEvent() *nostr.Event {
 			nostr.Tag{"d", group.Address.ID},
 		},
 	}
-	if group.Name != "" {
+	if group.Name != "" && (group.Name != group.Address.ID || group.hasName) {
 		evt.Tags = append(evt.Tags, nostr.Tag{"name", group.Name})
 	}
 	if group.About != "" {
@@ -162,20 +217,28 @@ func (group Group) ToAdminsEvent() *nostr.Event {
 	}
 	evt.Tags[0] = nostr.Tag{"d", group.Address.ID}
 
-	for member, roles := range group.Members {
+	// iterate in a fixed order so the same group always produces the same event
+	for _, member := range slices.Sorted(maps.Keys(group.Members)) {
+		roles := group.Members[member]
 		if len(roles) == 0 {
 			// is not an admin
 			continue
 		}
 
 		// is an admin
-		tag := make([]string, 2, 2+len(roles))
+		tag := make([]string, 2, 3+len(roles))
 		tag[0] = "p"
 		tag[1] = member
 		for _, role := range roles {
-			tag = append(tag, role.Name)
+			if !slices.Contains(tag[2:], role.Name) {
+				tag = append(tag, role.Name)
+			}
+		}
+		if relay, ok := group.RelayHints[member]; ok && relay != "" {
+			// always after all the role names so it can't be mistaken for one
+			tag = append(tag, relay)
 		}
-		evt.Tags = append(evt.Tags, tag)
+		evt.Tags = evt.Tags.AppendUnique(tag)
 	}
 
 	return evt
@@ -189,7 +252,8 @@ func (group Group) ToMembersEvent() *nostr.Event {
 	}
 	evt.Tags[0] = nostr.Tag{"d", group.Address.ID}
 
-	for member := range group.Members {
+	// members are map keys so they're already unique, no need for the AppendUnique() cost here
+	for _, member := range slices.Sorted(maps.Keys(group.Members)) {
 		// include both admins and normal members
 		evt.Tags = append(evt.Tags, nostr.Tag{"p", member})
 	}
@@ -206,8 +270,7 @@ func (group Group) ToRolesEvent() *nostr.Event {
 	evt.Tags[0] = nostr.Tag{"d", group.Address.ID}
 
 	for _, role := range group.Roles {
-		// include both admins and normal members
-		evt.Tags = append(evt.Tags, nostr.Tag{"role", role.Name, role.Description})
+		evt.Tags = evt.Tags.AppendUnique(nostr.Tag{"role", role.Name, role.Description})
 	}
 
 	return evt
@@ -217,15 +280,25 @@ func (group *Group) MergeInMetadataEvent(evt *nostr.Event) error {
 	if evt.Kind != nostr.KindSimpleGroupMetadata {
 		return fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupMetadata, evt.Kind)
 	}
-	if evt.CreatedAt < group.LastMetadataUpdate {
-		return fmt.Errorf("event is older than our last update (%d vs %d)", evt.CreatedAt, group.LastMetadataUpdate)
+	if err := group.checkGroupID(evt); err != nil {
+		return err
+	}
+	if err := group.advance(evt); err != nil {
+		return err
 	}
 
+	// the newest metadata event replaces everything that came before it
 	group.LastMetadataUpdate = evt.CreatedAt
 	group.Name = group.Address.ID
+	group.hasName = false
+	group.About = ""
+	group.Picture = ""
+	group.Private = false
+	group.Closed = false
 
 	if tag := evt.Tags.GetFirst([]string{"name", ""}); tag != nil {
 		group.Name = (*tag)[1]
+		group.hasName = true
 	}
 	if tag := evt.Tags.GetFirst([]string{"about", ""}); tag != nil {
 		group.About = (*tag)[1]
@@ -248,11 +321,15 @@ func (group *Group) MergeInAdminsEvent(evt *nostr.Event) error {
 	if evt.Kind != nostr.KindSimpleGroupAdmins {
 		return fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupAdmins, evt.Kind)
 	}
-	if evt.CreatedAt < group.LastAdminsUpdate {
-		return fmt.Errorf("event is older than our last update (%d vs %d)", evt.CreatedAt, group.LastAdminsUpdate)
+	if err := group.checkGroupID(evt); err != nil {
+		return err
+	}
+	if err := group.advance(evt); err != nil {
+		return err
 	}
 
 	group.LastAdminsUpdate = evt.CreatedAt
+	group.saveStateEvent(evt)
 	for _, tag := range evt.Tags {
 		if len(tag) < 3 {
 			continue
@@ -260,12 +337,27 @@ func (group *Group) MergeInAdminsEvent(evt *nostr.Event) error {
 		if tag[0] != "p" {
 			continue
 		}
-		if !nostr.IsValid32ByteHex(tag[1]) {
+		pubkey, ok := normalizePubKey(tag[1])
+		if !ok {
 			continue
 		}
 
-		for _, roleName := range tag[2:] {
-			group.Members[tag[1]] = append(group.Members[tag[1]], group.GetRoleByName(roleName))
+		roleNames := tag[2:]
+		if last := roleNames[len(roleNames)-1]; nostr.IsValidRelayURL(last) {
+			// a relay hint, not a role
+			if group.RelayHints == nil {
+				group.RelayHints = make(map[string]string)
+			}
+			group.RelayHints[pubkey] = last
+			roleNames = roleNames[0 : len(roleNames)-1]
+		}
+
+		for _, roleName := range roleNames {
+			if slices.ContainsFunc(group.Members[pubkey], func(r *Role) bool { return r.Name == roleName }) {
+				// merging the same event again must not duplicate roles
+				continue
+			}
+			group.Members[pubkey] = append(group.Members[pubkey], group.GetRoleByName(roleName))
 		}
 	}
 
@@ -276,11 +368,15 @@ func (group *Group) MergeInMembersEvent(evt *nostr.Event) error {
 	if evt.Kind != nostr.KindSimpleGroupMembers {
 		return fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupMembers, evt.Kind)
 	}
-	if evt.CreatedAt < group.LastMembersUpdate {
-		return fmt.Errorf("event is older than our last update (%d vs %d)", evt.CreatedAt, group.LastMembersUpdate)
+	if err := group.checkGroupID(evt); err != nil {
+		return err
+	}
+	if err := group.advance(evt); err != nil {
+		return err
 	}
 
 	group.LastMembersUpdate = evt.CreatedAt
+	group.saveStateEvent(evt)
 	for _, tag := range evt.Tags {
 		if len(tag) < 2 {
 			continue
@@ -288,15 +384,223 @@ func (group *Group) MergeInMembersEvent(evt *nostr.Event) error {
 		if tag[0] != "p" {
 			continue
 		}
-		if !nostr.IsValid32ByteHex(tag[1]) {
+		pubkey, ok := normalizePubKey(tag[1])
+		if !ok {
 			continue
 		}
 
-		_, exists := group.Members[tag[1]]
+		_, exists := group.Members[pubkey]
 		if !exists {
-			group.Members[tag[1]] = nil
+			group.Members[pubkey] = nil
+		}
+	}
+
+	return nil
+}
+
+// ApplyEvent merges a metadata, admins or members event into the group or applies a moderation action, join
+// request or leave request, so a group can be kept up to date by feeding it every event that concerns it.
+//
+// Events addressed to another group are refused with ErrWrongGroup. The metadata, admins and members events
+// are replaceable, so one older than the last one of the same kind already given is refused. The admins and
+// members events are taken as the full list of members at their time, moderation events older than them are
+// ignored as they're supposed to be already reflected there (and are dropped from memory once these arrive).
+//
+// Moderation events are deduplicated by id and applied in created_at order (ties broken by id) no matter the
+// order they arrive in: when one comes late the members are recomputed from the last admins and members events
+// and all the moderation events since them, so changes made to Members in other ways are lost then. This means
+// that, in the end, the same events always result in the same group.
+func (group *Group) ApplyEvent(evt *nostr.Event) error {
+	switch evt.Kind {
+	case nostr.KindSimpleGroupMetadata:
+		if err := group.MergeInMetadataEvent(evt); err != nil {
+			return err
+		}
+		if len(group.moderationLog) > 0 {
+			// join requests depend on the group being closed or not
+			group.recomputeMembers()
+		}
+		return nil
+	case nostr.KindSimpleGroupAdmins, nostr.KindSimpleGroupMembers:
+		if err := group.checkGroupID(evt); err != nil {
+			return err
+		}
+		if err := group.advance(evt); err != nil {
+			return err
+		}
+		group.saveStateEvent(evt)
+		group.recomputeMembers()
+		return nil
+	case nostr.KindSimpleGroupLeaveRequest:
+		if _, ok := normalizePubKey(evt.PubKey); !ok {
+			return fmt.Errorf("invalid public key hex '%s'", evt.PubKey)
+		}
+		if err := group.checkGroupID(evt); err != nil {
+			return err
+		}
+	default:
+		if _, err := GetModerationAction(evt); err != nil {
+			return err
+		}
+		if err := group.checkGroupID(evt); err != nil {
+			return err
+		}
+	}
+
+	if evt.CreatedAt < group.membersSnapshotTime() {
+		// already reflected in the admins and members events
+		return nil
+	}
+
+	entry := loggedEvent{evt, evt.ID}
+	if entry.id == "" {
+		entry.id = evt.GetID()
+	}
+	idx, exists := slices.BinarySearchFunc(group.moderationLog, entry, compareLoggedEvents)
+	if exists {
+		return nil
+	}
+	group.moderationLog = slices.Insert(group.moderationLog, idx, entry)
+
+	if idx == len(group.moderationLog)-1 {
+		// the newest so far, so it can just be applied on top of the current state
+		group.applyModerationEvent(evt)
+	} else {
+		group.recomputeMembers()
+	}
+	return nil
+}
+
+// checkGroupID checks that evt is addressed to this group by its "d" or "h" tag (depending on the kind).
+func (group *Group) checkGroupID(evt *nostr.Event) error {
+	id, ok := GroupID(evt)
+	if !ok {
+		if MetadataEventKinds.Includes(evt.Kind) && evt.Tags.GetD() == "" {
+			return ErrMissingDTag
 		}
+		return ErrInvalidGroupID
+	}
+	if group.Address.ID != "" && id != group.Address.ID {
+		return fmt.Errorf("%w: '%s' instead of '%s'", ErrWrongGroup, id, group.Address.ID)
 	}
+	return nil
+}
 
+// advance checks that evt isn't older than the last event of its kind merged into the group and records it
+// as the new last one. It is only used for the replaceable kinds that describe the group state.
+func (group *Group) advance(evt *nostr.Event) error {
+	last := group.lastAppliedByKind[evt.Kind]
+	switch evt.Kind {
+	case nostr.KindSimpleGroupMetadata:
+		last = max(last, group.LastMetadataUpdate)
+	case nostr.KindSimpleGroupAdmins:
+		last = max(last, group.LastAdminsUpdate)
+	case nostr.KindSimpleGroupMembers:
+		last = max(last, group.LastMembersUpdate)
+	case nostr.KindSimpleGroupRoles:
+		last = max(last, group.LastRolesUpdate)
+	}
+	if evt.CreatedAt < last {
+		return fmt.Errorf("event is older than our last update (%d vs %d)", evt.CreatedAt, last)
+	}
+
+	if group.lastAppliedByKind == nil {
+		group.lastAppliedByKind = make(map[int]nostr.Timestamp)
+	}
+	group.lastAppliedByKind[evt.Kind] = evt.CreatedAt
 	return nil
 }
+
+// loggedEvent is a moderation event kept by ApplyEvent along with its id (computed if the event didn't have one).
+type loggedEvent struct {
+	evt *nostr.Event
+	id  string
+}
+
+func compareLoggedEvents(a, b loggedEvent) int {
+	if c := cmp.Compare(a.evt.CreatedAt, b.evt.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// saveStateEvent keeps an admins or members event as the base for recomputeMembers and drops the logged
+// moderation events older than it, as they will never be replayed again.
+func (group *Group) saveStateEvent(evt *nostr.Event) {
+	if group.stateEvents == nil {
+		group.stateEvents = make(map[int]*nostr.Event, 2)
+	}
+	group.stateEvents[evt.Kind] = evt
+
+	snapshot := group.membersSnapshotTime()
+	stale, _ := slices.BinarySearchFunc(group.moderationLog, snapshot, func(entry loggedEvent, ts nostr.Timestamp) int {
+		return cmp.Compare(entry.evt.CreatedAt, ts)
+	})
+	group.moderationLog = slices.Delete(group.moderationLog, 0, stale)
+}
+
+// membersSnapshotTime is the time of the newest admins or members event, moderation events older than that
+// are already accounted for in them.
+func (group *Group) membersSnapshotTime() nostr.Timestamp {
+	var snapshot nostr.Timestamp
+	for _, evt := range group.stateEvents {
+		snapshot = max(snapshot, evt.CreatedAt)
+	}
+	return snapshot
+}
+
+func (group *Group) applyModerationEvent(evt *nostr.Event) {
+	switch evt.Kind {
+	case nostr.KindSimpleGroupJoinRequest:
+		group.handleJoinRequest(evt)
+	case nostr.KindSimpleGroupLeaveRequest:
+		group.handleLeaveRequest(evt)
+	default:
+		if action, err := GetModerationAction(evt); err == nil {
+			action.Apply(group)
+		}
+	}
+}
+
+// recomputeMembers rebuilds Members and PendingJoins from the last admins and members events and the moderation
+// events that came after them. OnMembersChanged is called only once, with the overall difference.
+func (group *Group) recomputeMembers() {
+	onMembersChanged := group.OnMembersChanged
+	group.OnMembersChanged = nil
+	defer func() { group.OnMembersChanged = onMembersChanged }()
+
+	before := group.Members
+	group.Members = make(map[string][]*Role, len(before))
+	group.PendingJoins = make(map[string]nostr.Timestamp)
+
+	if evt, ok := group.stateEvents[nostr.KindSimpleGroupMembers]; ok {
+		group.MergeInMembersEvent(evt)
+	}
+	if evt, ok := group.stateEvents[nostr.KindSimpleGroupAdmins]; ok {
+		group.MergeInAdminsEvent(evt)
+	}
+
+	// the log only has events since the last admins and members events
+	for _, entry := range group.moderationLog {
+		group.applyModerationEvent(entry.evt)
+	}
+
+	if onMembersChanged != nil {
+		var added, removed []string
+		for pubkey := range group.Members {
+			if _, existed := before[pubkey]; !existed {
+				added = append(added, pubkey)
+			}
+		}
+		for pubkey := range before {
+			if _, exists := group.Members[pubkey]; !exists {
+				removed = append(removed, pubkey)
+			}
+		}
+		slices.Sort(added)
+		slices.Sort(removed)
+		if len(added) > 0 || len(removed) > 0 {
+			onMembersChanged(added, removed)
+		}
+	}
+}
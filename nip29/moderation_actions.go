@@ -0,0 +1,258 @@
+package nip29
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Action is something that can be done to a group as the result of an event, like adding or removing users.
+type Action interface {
+	Apply(group *Group)
+	Name() string
+}
+
+var (
+	_ Action = PutUser{}
+	_ Action = RemoveUser{}
+	_ Action = JoinRequest{}
+	_ Action = DeleteEvent{}
+)
+
+// GetModerationAction parses an event into the Action it represents.
+func GetModerationAction(evt *nostr.Event) (Action, error) {
+	factory, ok := moderationActionFactories[evt.Kind]
+	if !ok {
+		return nil, fmt.Errorf("event kind %d is not a supported moderation action", evt.Kind)
+	}
+	if _, ok := GroupID(evt); !ok {
+		return nil, fmt.Errorf("missing or invalid \"h\" tag: %w", ErrInvalidGroupID)
+	}
+	return factory(evt)
+}
+
+var moderationActionFactories = map[int]func(*nostr.Event) (Action, error){
+	nostr.KindSimpleGroupPutUser: func(evt *nostr.Event) (Action, error) {
+		targets := make([]PubKeyRoles, 0, len(evt.Tags))
+		for _, tag := range evt.Tags.GetAll([]string{"p", ""}) {
+			pubkey, ok := normalizePubKey(tag[1])
+			if !ok {
+				return nil, fmt.Errorf("invalid public key hex '%s'", tag[1])
+			}
+			targets = append(targets, PubKeyRoles{PubKey: pubkey, RoleNames: tag[2:]})
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("missing 'p' tags")
+		}
+		return PutUser{Targets: targets, When: evt.CreatedAt}, nil
+	},
+	nostr.KindSimpleGroupRemoveUser: func(evt *nostr.Event) (Action, error) {
+		targets := make([]string, 0, len(evt.Tags))
+		for _, tag := range evt.Tags.GetAll([]string{"p", ""}) {
+			pubkey, ok := normalizePubKey(tag[1])
+			if !ok {
+				return nil, fmt.Errorf("invalid public key hex '%s'", tag[1])
+			}
+			targets = append(targets, pubkey)
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("missing 'p' tags")
+		}
+		return RemoveUser{Targets: targets, When: evt.CreatedAt}, nil
+	},
+	nostr.KindSimpleGroupJoinRequest: func(evt *nostr.Event) (Action, error) {
+		pubkey, ok := normalizePubKey(evt.PubKey)
+		if !ok {
+			return nil, fmt.Errorf("invalid public key hex '%s'", evt.PubKey)
+		}
+		return JoinRequest{PubKey: pubkey, When: evt.CreatedAt}, nil
+	},
+	nostr.KindSimpleGroupDeleteEvent: func(evt *nostr.Event) (Action, error) {
+		// invalid ids are skipped and counted instead of failing the whole action, so one bad tag
+		// doesn't prevent the other events from being deleted
+		targets := make([]string, 0, len(evt.Tags))
+		skipped := 0
+		for _, tag := range evt.Tags.GetAll([]string{"e", ""}) {
+			if !nostr.IsValid32ByteHex(tag[1]) {
+				skipped++
+				continue
+			}
+			if slices.Contains(targets, tag[1]) {
+				continue
+			}
+			targets = append(targets, tag[1])
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("missing valid 'e' tags (%d invalid)", skipped)
+		}
+		return DeleteEvent{Targets: targets, Skipped: skipped, When: evt.CreatedAt}, nil
+	},
+}
+
+// UnmarshalAction parses an Action from the JSON produced by its MarshalJSON method, as in
+// {"action":"remove-user","targets":[...],"when":...}, so moderation logs can be stored and replayed.
+func UnmarshalAction(data []byte) (Action, error) {
+	var header struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid action json: %w", err)
+	}
+
+	var action Action
+	var err error
+	switch header.Action {
+	case PutUser{}.Name():
+		var a PutUser
+		err = json.Unmarshal(data, &a)
+		action = a
+	case RemoveUser{}.Name():
+		var a RemoveUser
+		err = json.Unmarshal(data, &a)
+		action = a
+	case JoinRequest{}.Name():
+		var a JoinRequest
+		err = json.Unmarshal(data, &a)
+		action = a
+	case DeleteEvent{}.Name():
+		var a DeleteEvent
+		err = json.Unmarshal(data, &a)
+		action = a
+	default:
+		return nil, fmt.Errorf("unknown action '%s'", header.Action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s action: %w", header.Action, err)
+	}
+
+	return action, nil
+}
+
+// PubKeyRoles is a member and the names of the roles they should have.
+type PubKeyRoles struct {
+	PubKey    string   `json:"pubkey"`
+	RoleNames []string `json:"roles,omitempty"`
+}
+
+// PutUser adds users to the group (or updates their roles if they're already there).
+type PutUser struct {
+	Targets []PubKeyRoles   `json:"targets"`
+	When    nostr.Timestamp `json:"when"`
+}
+
+func (_ PutUser) Name() string { return "put-user" }
+func (a PutUser) MarshalJSON() ([]byte, error) {
+	type alias PutUser
+	return json.Marshal(struct {
+		Action string `json:"action"`
+		alias
+	}{a.Name(), alias(a)})
+}
+func (a PutUser) Apply(group *Group) {
+	added := make([]string, 0, len(a.Targets))
+	for _, target := range a.Targets {
+		pubkey, ok := normalizePubKey(target.PubKey)
+		if !ok {
+			continue
+		}
+
+		_, exists := group.Members[pubkey]
+		if !exists {
+			added = append(added, pubkey)
+			if len(target.RoleNames) == 0 {
+				group.Members[pubkey] = group.defaultRoles()
+				delete(group.PendingJoins, pubkey)
+				continue
+			}
+		}
+
+		roles := make([]*Role, 0, len(target.RoleNames))
+		for _, roleName := range target.RoleNames {
+			if slices.ContainsFunc(roles, func(r *Role) bool { return r.Name == roleName }) {
+				continue
+			}
+			roles = append(roles, group.GetRoleByName(roleName))
+		}
+		group.Members[pubkey] = roles
+		delete(group.PendingJoins, pubkey)
+	}
+
+	group.notifyMembersChanged(added, nil)
+}
+
+// RemoveUser removes users from the group.
+type RemoveUser struct {
+	Targets []string        `json:"targets"`
+	When    nostr.Timestamp `json:"when"`
+}
+
+func (_ RemoveUser) Name() string { return "remove-user" }
+func (a RemoveUser) MarshalJSON() ([]byte, error) {
+	type alias RemoveUser
+	return json.Marshal(struct {
+		Action string `json:"action"`
+		alias
+	}{a.Name(), alias(a)})
+}
+func (a RemoveUser) Apply(group *Group) {
+	removed := make([]string, 0, len(a.Targets))
+	for _, target := range a.Targets {
+		pubkey, _ := normalizePubKey(target)
+		if _, exists := group.Members[pubkey]; exists {
+			delete(group.Members, pubkey)
+			removed = append(removed, pubkey)
+		}
+	}
+
+	group.notifyMembersChanged(nil, removed)
+}
+
+// JoinRequest is a request from a user to join the group, applying it admits the user as a plain member
+// (with the group's DefaultRole, if any).
+type JoinRequest struct {
+	PubKey string          `json:"pubkey"`
+	When   nostr.Timestamp `json:"when"`
+}
+
+func (_ JoinRequest) Name() string { return "join-request" }
+func (a JoinRequest) MarshalJSON() ([]byte, error) {
+	type alias JoinRequest
+	return json.Marshal(struct {
+		Action string `json:"action"`
+		alias
+	}{a.Name(), alias(a)})
+}
+func (a JoinRequest) Apply(group *Group) {
+	pubkey, ok := normalizePubKey(a.PubKey)
+	if !ok {
+		return
+	}
+	if _, exists := group.Members[pubkey]; exists {
+		return
+	}
+
+	group.Members[pubkey] = group.defaultRoles()
+	group.notifyMembersChanged([]string{pubkey}, nil)
+}
+
+// DeleteEvent asks for events to be removed from the group. Targets keeps the order of the valid "e" tags
+// (without duplicates) and Skipped counts the tags that were ignored for not having a valid event id.
+//
+// Deleting the events themselves is up to the relay, so applying it doesn't change the group state.
+type DeleteEvent struct {
+	Targets []string        `json:"targets"`
+	Skipped int             `json:"skipped,omitempty"`
+	When    nostr.Timestamp `json:"when"`
+}
+
+func (_ DeleteEvent) Name() string { return "delete-event" }
+func (a DeleteEvent) MarshalJSON() ([]byte, error) {
+	type alias DeleteEvent
+	return json.Marshal(struct {
+		Action string `json:"action"`
+		alias
+	}{a.Name(), alias(a)})
+}
+func (a DeleteEvent) Apply(group *Group) {}
@@ -2,6 +2,7 @@ package nip29
 
 import (
 	"slices"
+	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -9,6 +10,62 @@ import (
 type Role struct {
 	Name        string
 	Description string
+
+	// Permissions are the things members with this role can do. These are set by relays according to
+	// their own policies, they are not part of the roles event.
+	Permissions map[Permission]struct{}
+}
+
+// Permission is something a member can be allowed to do in a group, named after the moderation actions.
+type Permission string
+
+const (
+	PermissionPutUser       Permission = "put-user"
+	PermissionRemoveUser    Permission = "remove-user"
+	PermissionEditMetadata  Permission = "edit-metadata"
+	PermissionDeleteEvent   Permission = "delete-event"
+	PermissionCreateInvite  Permission = "create-invite"
+	PermissionDeleteGroup   Permission = "delete-group"
+	PermissionEditGroupRole Permission = "edit-group-role"
+)
+
+// AllPermissions is the list of all known permissions.
+var AllPermissions = []Permission{
+	PermissionPutUser,
+	PermissionRemoveUser,
+	PermissionEditMetadata,
+	PermissionDeleteEvent,
+	PermissionCreateInvite,
+	PermissionDeleteGroup,
+	PermissionEditGroupRole,
+}
+
+// MasterRoleName is the name of the role that implicitly has all the permissions.
+const MasterRoleName = "master"
+
+// Joinability is what someone has to do to become a member of a group, see Group.Joinability.
+type Joinability int
+
+const (
+	// AlreadyMember means there is nothing to do.
+	AlreadyMember Joinability = iota + 1
+	// Open means a join request is accepted immediately.
+	Open
+	// RequestRequired means the group is closed, so a join request must be approved by an admin (or come
+	// with an invite code).
+	RequestRequired
+)
+
+func (j Joinability) String() string {
+	switch j {
+	case AlreadyMember:
+		return "already-member"
+	case Open:
+		return "open"
+	case RequestRequired:
+		return "request-required"
+	}
+	return "<unknown>"
 }
 
 type KindRange []int
@@ -34,3 +91,44 @@ func (kr KindRange) Includes(kind int) bool {
 	_, ok := slices.BinarySearch(kr, kind)
 	return ok
 }
+
+// GroupID returns the id of the group an event belongs to: the "d" tag for the group state events
+// published by relays (metadata, admins, members and roles) and the "h" tag for everything else.
+func GroupID(evt *nostr.Event) (string, bool) {
+	name := "h"
+	if MetadataEventKinds.Includes(evt.Kind) {
+		name = "d"
+	}
+
+	if tag := evt.Tags.GetFirst([]string{name, ""}); tag != nil && IsValidGroupID((*tag)[1]) {
+		return (*tag)[1], true
+	}
+	return "", false
+}
+
+// MaxGroupIDLength is the maximum length of a group id we accept.
+const MaxGroupIDLength = 64
+
+// IsValidGroupID checks if id is made only of the characters allowed by NIP-29 (a-z, 0-9, '-' and '_')
+// and is not longer than MaxGroupIDLength.
+func IsValidGroupID(id string) bool {
+	if len(id) == 0 || len(id) > MaxGroupIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeGroupID trims spaces from id and lowercases it, returning "" if the result is still not a
+// valid group id.
+func NormalizeGroupID(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if !IsValidGroupID(id) {
+		return ""
+	}
+	return id
+}
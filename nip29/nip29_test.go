@@ -1,8 +1,13 @@
 package nip29
 
 import (
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,20 +28,20 @@ func TestGroupEventBackAndForth(t *testing.T) {
 	require.NotNil(t, meta1.Tags.GetFirst([]string{"name", "banana"}), "translation of group1 to metadata event failed: %s", meta1)
 	require.NotNil(t, meta1.Tags.GetFirst([]string{"private"}), "translation of group1 to metadata event failed: %s", meta1)
 
-	group2, _ := NewGroup("groups.com'abc")
+	group2, _ := NewGroup("groups.com'xyz")
 	group2.Members[ALICE] = []*Role{{Name: "nada"}}
 	group2.Members[BOB] = []*Role{{Name: "nada"}}
 	group2.Members[CAROL] = nil
 	group2.Members[DEREK] = nil
 	admins2 := group2.ToAdminsEvent()
 
-	require.Equal(t, "abc", admins2.Tags.GetD(), "translation of group2 to admins event failed")
+	require.Equal(t, "xyz", admins2.Tags.GetD(), "translation of group2 to admins event failed")
 	require.Equal(t, 3, len(admins2.Tags), "translation of group2 to admins event failed")
 	require.NotNil(t, admins2.Tags.GetFirst([]string{"p", ALICE, "nada"}), "translation of group2 to admins event failed")
 	require.NotNil(t, admins2.Tags.GetFirst([]string{"p", BOB, "nada"}), "translation of group2 to admins event failed")
 
 	members2 := group2.ToMembersEvent()
-	require.Equal(t, "abc", members2.Tags.GetD(), "translation of group2 to members2 event failed")
+	require.Equal(t, "xyz", members2.Tags.GetD(), "translation of group2 to members2 event failed")
 	require.Equal(t, 5, len(members2.Tags), "translation of group2 to members2 event failed")
 	require.NotNil(t, members2.Tags.GetFirst([]string{"p", ALICE}), "translation of group2 to members2 event failed")
 	require.NotNil(t, members2.Tags.GetFirst([]string{"p", BOB}), "translation of group2 to members2 event failed")
@@ -56,5 +61,762 @@ func TestGroupEventBackAndForth(t *testing.T) {
 
 	group2.MergeInMetadataEvent(meta1)
 	require.Equal(t, "banana", group2.Name, "merge of meta1 into group2 failed")
-	require.Equal(t, "abc", group2.Address.ID, "merge of meta1 into group2 failed")
+	require.Equal(t, "xyz", group2.Address.ID, "merge of meta1 into group2 failed")
+
+	// events from other groups are refused
+	other, _ := NewGroup("groups.com'abc")
+	require.ErrorIs(t, other.MergeInMetadataEvent(meta1), ErrWrongGroup)
+	require.ErrorIs(t, other.MergeInAdminsEvent(admins2), ErrWrongGroup)
+	require.ErrorIs(t, other.MergeInMembersEvent(members2), ErrWrongGroup)
+	require.Equal(t, "abc", other.Name)
+	require.Empty(t, other.Members)
+}
+
+func TestMergeMissingDTag(t *testing.T) {
+	group, _ := NewGroup("relay.com'xyz")
+
+	meta := &nostr.Event{
+		Kind: nostr.KindSimpleGroupMetadata,
+		Tags: nostr.Tags{{"name", "banana"}},
+	}
+	require.ErrorIs(t, group.MergeInMetadataEvent(meta), ErrMissingDTag)
+	require.Equal(t, "xyz", group.Name, "group was modified by an invalid event")
+
+	_, err := NewGroupFromMetadataEvent("wss://relay.com", meta)
+	require.ErrorIs(t, err, ErrMissingDTag)
+
+	members := &nostr.Event{
+		Kind: nostr.KindSimpleGroupMembers,
+		Tags: nostr.Tags{{"p", ALICE}},
+	}
+	require.ErrorIs(t, group.MergeInMembersEvent(members), ErrMissingDTag)
+	require.Len(t, group.Members, 0)
+}
+
+func TestMembersChangedCallback(t *testing.T) {
+	group, _ := NewGroup("relay.com'xyz")
+
+	var added, removed []string
+	calls := 0
+	group.OnMembersChanged = func(a, r []string) {
+		calls++
+		added = a
+		removed = r
+	}
+
+	apply := func(evt *nostr.Event) {
+		action, err := GetModerationAction(evt)
+		require.NoError(t, err)
+		action.Apply(&group)
+	}
+
+	apply(&nostr.Event{
+		Kind: nostr.KindSimpleGroupPutUser,
+		Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE}, {"p", BOB, "moderator"}},
+	})
+	require.Equal(t, 1, calls)
+	require.ElementsMatch(t, []string{ALICE, BOB}, added)
+	require.Empty(t, removed)
+	require.Equal(t, "moderator", group.Members[BOB][0].Name)
+
+	// changing only the roles of an existing member doesn't change the member list
+	apply(&nostr.Event{
+		Kind: nostr.KindSimpleGroupPutUser,
+		Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE, "moderator"}},
+	})
+	require.Equal(t, 1, calls)
+
+	apply(&nostr.Event{
+		Kind:   nostr.KindSimpleGroupJoinRequest,
+		PubKey: CAROL,
+		Tags:   nostr.Tags{{"h", "xyz"}},
+	})
+	require.Equal(t, 2, calls)
+	require.Equal(t, []string{CAROL}, added)
+	require.Empty(t, removed)
+
+	apply(&nostr.Event{
+		Kind: nostr.KindSimpleGroupRemoveUser,
+		Tags: nostr.Tags{{"h", "xyz"}, {"p", BOB}, {"p", DEREK}},
+	})
+	require.Equal(t, 3, calls)
+	require.Empty(t, added)
+	require.Equal(t, []string{BOB}, removed, "only actual members should be reported as removed")
+	require.Len(t, group.Members, 2)
+}
+
+func TestGroupEventsAreDeterministic(t *testing.T) {
+	group, _ := NewGroup("relay.com'xyz")
+	group.Members[DEREK] = nil
+	group.Members[ALICE] = []*Role{{Name: "admin"}}
+	group.Members[CAROL] = []*Role{{Name: "moderator"}}
+	group.Members[BOB] = nil
+
+	members := group.ToMembersEvent()
+	admins := group.ToAdminsEvent()
+	for range 20 {
+		require.Equal(t, members.String(), group.ToMembersEvent().String())
+		require.Equal(t, admins.String(), group.ToAdminsEvent().String())
+	}
+
+	require.Equal(t, nostr.Tags{{"d", "xyz"}, {"p", DEREK}, {"p", BOB}, {"p", ALICE}, {"p", CAROL}}, members.Tags)
+	require.Equal(t, nostr.Tags{{"d", "xyz"}, {"p", ALICE, "admin"}, {"p", CAROL, "moderator"}}, admins.Tags)
+}
+
+func TestJoinAndLeaveRequests(t *testing.T) {
+	join := func(pubkey string, ts nostr.Timestamp) *nostr.Event {
+		return &nostr.Event{Kind: nostr.KindSimpleGroupJoinRequest, PubKey: pubkey, CreatedAt: ts, Tags: nostr.Tags{{"h", "xyz"}}}
+	}
+	leave := func(pubkey string, ts nostr.Timestamp) *nostr.Event {
+		return &nostr.Event{Kind: nostr.KindSimpleGroupLeaveRequest, PubKey: pubkey, CreatedAt: ts, Tags: nostr.Tags{{"h", "xyz"}}}
+	}
+
+	t.Run("open", func(t *testing.T) {
+		group, _ := NewGroup("relay.com'xyz")
+
+		require.NoError(t, group.HandleJoinRequest(join(ALICE, 10)))
+		require.Contains(t, group.Members, ALICE, "open group should admit immediately")
+		require.Empty(t, group.PendingJoins)
+
+		require.NoError(t, group.HandleLeaveRequest(leave(ALICE, 20)))
+		require.NotContains(t, group.Members, ALICE)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		group, _ := NewGroup("relay.com'xyz")
+		group.Closed = true
+
+		require.NoError(t, group.HandleJoinRequest(join(ALICE, 10)))
+		require.NoError(t, group.HandleJoinRequest(join(BOB, 11)))
+		require.Empty(t, group.Members, "closed group shouldn't admit anyone automatically")
+		require.Equal(t, map[string]nostr.Timestamp{ALICE: 10, BOB: 11}, group.PendingJoins)
+
+		// admin approves alice
+		action, err := GetModerationAction(&nostr.Event{Kind: nostr.KindSimpleGroupPutUser, Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE}}})
+		require.NoError(t, err)
+		action.Apply(&group)
+		require.Contains(t, group.Members, ALICE)
+		require.NotContains(t, group.PendingJoins, ALICE)
+
+		// bob gives up
+		require.NoError(t, group.HandleLeaveRequest(leave(BOB, 20)))
+		require.Empty(t, group.PendingJoins)
+		require.NotContains(t, group.Members, BOB)
+	})
+
+	t.Run("uppercase pubkeys", func(t *testing.T) {
+		group, _ := NewGroup("relay.com'xyz")
+		group.Closed = true
+
+		require.NoError(t, group.HandleJoinRequest(join(strings.ToUpper(BOB), 10)))
+		require.Equal(t, map[string]nostr.Timestamp{BOB: 10}, group.PendingJoins)
+		require.NoError(t, group.HandleLeaveRequest(leave(strings.ToUpper(BOB), 20)))
+		require.Empty(t, group.PendingJoins)
+
+		group.Members[ALICE] = nil
+		require.NoError(t, group.HandleJoinRequest(join(strings.ToUpper(ALICE), 30)))
+		require.Empty(t, group.PendingJoins, "existing member shouldn't become pending")
+		require.NoError(t, group.HandleLeaveRequest(leave(strings.ToUpper(ALICE), 40)))
+		require.NotContains(t, group.Members, ALICE)
+	})
+
+	t.Run("wrong kind", func(t *testing.T) {
+		group, _ := NewGroup("relay.com'xyz")
+		require.Error(t, group.HandleJoinRequest(leave(ALICE, 10)))
+		require.Error(t, group.HandleLeaveRequest(join(ALICE, 10)))
+	})
+}
+
+func TestNewGroupWithID(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	require.Equal(t, "xyz", group.Address.ID)
+
+	action, err := GetModerationAction(&nostr.Event{
+		Kind: nostr.KindSimpleGroupPutUser,
+		Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE, "moderator"}, {"p", BOB}},
+	})
+	require.NoError(t, err)
+	action.Apply(group)
+
+	require.Len(t, group.Members, 2)
+	require.Len(t, group.Members[ALICE], 1)
+	require.Equal(t, "moderator", group.Members[ALICE][0].Name)
+	require.Empty(t, group.Members[BOB])
+
+	require.NoError(t, group.HandleJoinRequest(&nostr.Event{Kind: nostr.KindSimpleGroupJoinRequest, PubKey: CAROL, CreatedAt: 1, Tags: nostr.Tags{{"h", "xyz"}}}))
+	require.Contains(t, group.Members, CAROL)
+}
+
+func TestGroupEventsHaveNoDuplicateTags(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	admin := &Role{Name: "admin", Description: "does everything"}
+	group.Roles = []*Role{admin, {Name: "moderator"}, admin}
+	group.Members[ALICE] = []*Role{admin, admin, group.Roles[1]}
+	group.Members[BOB] = nil
+
+	for _, evt := range []*nostr.Event{group.ToAdminsEvent(), group.ToMembersEvent(), group.ToRolesEvent()} {
+		seen := make(map[string]bool, len(evt.Tags))
+		for _, tag := range evt.Tags {
+			key := strings.Join(tag[0:2], ":")
+			require.False(t, seen[key], "duplicate tag %v on kind %d", tag, evt.Kind)
+			seen[key] = true
+		}
+	}
+
+	require.Equal(t, nostr.Tag{"p", ALICE, "admin", "moderator"}, group.ToAdminsEvent().Tags[1])
+	require.Len(t, group.ToRolesEvent().Tags, 3)
+}
+
+func TestAdminsEventRelayHints(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	admin := &Role{Name: "admin"}
+	moderator := &Role{Name: "moderator"}
+	group.Roles = []*Role{admin, moderator}
+	group.Members[ALICE] = []*Role{admin, moderator}
+	group.Members[BOB] = []*Role{moderator}
+	group.Members[CAROL] = nil
+	group.RelayHints[BOB] = "wss://bob.relay"
+	group.RelayHints[CAROL] = "wss://carol.relay"
+
+	evt := group.ToAdminsEvent()
+	require.Equal(t, nostr.Tags{
+		{"d", "xyz"},
+		{"p", BOB, "moderator", "wss://bob.relay"},
+		{"p", ALICE, "admin", "moderator"},
+	}, evt.Tags)
+
+	// parsing it back doesn't mistake the relay for a role
+	parsed := NewGroupWithID("xyz")
+	require.NoError(t, parsed.MergeInAdminsEvent(evt))
+	require.Len(t, parsed.Members[BOB], 1)
+	require.Equal(t, "moderator", parsed.Members[BOB][0].Name)
+	require.Equal(t, "wss://bob.relay", parsed.RelayHints[BOB])
+	require.Len(t, parsed.Members[ALICE], 2)
+	require.NotContains(t, parsed.RelayHints, ALICE)
+}
+
+func TestGroupID(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		evt   nostr.Event
+		id    string
+		found bool
+	}{
+		{"metadata", nostr.Event{Kind: nostr.KindSimpleGroupMetadata, Tags: nostr.Tags{{"d", "xyz"}, {"name", "x"}}}, "xyz", true},
+		{"members with h", nostr.Event{Kind: nostr.KindSimpleGroupMembers, Tags: nostr.Tags{{"h", "xyz"}}}, "", false},
+		{"chat", nostr.Event{Kind: nostr.KindSimpleGroupChatMessage, Tags: nostr.Tags{{"h", "abc"}}}, "abc", true},
+		{"moderation", nostr.Event{Kind: nostr.KindSimpleGroupPutUser, Tags: nostr.Tags{{"p", ALICE}, {"h", "abc"}}}, "abc", true},
+		{"chat with d", nostr.Event{Kind: nostr.KindSimpleGroupChatMessage, Tags: nostr.Tags{{"d", "abc"}}}, "", false},
+		{"empty h", nostr.Event{Kind: nostr.KindSimpleGroupChatMessage, Tags: nostr.Tags{{"h", ""}}}, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			id, found := GroupID(&tc.evt)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.id, id)
+		})
+	}
+}
+
+func TestDefaultRole(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	group.DefaultRole = &Role{Name: "member", Description: "can chat"}
+
+	action, err := GetModerationAction(&nostr.Event{
+		Kind: nostr.KindSimpleGroupPutUser,
+		Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE}, {"p", BOB, "moderator"}},
+	})
+	require.NoError(t, err)
+	action.Apply(group)
+	require.NoError(t, group.HandleJoinRequest(&nostr.Event{Kind: nostr.KindSimpleGroupJoinRequest, PubKey: CAROL, CreatedAt: 1, Tags: nostr.Tags{{"h", "xyz"}}}))
+
+	require.Len(t, group.Members[ALICE], 1)
+	require.Equal(t, "member", group.Members[ALICE][0].Name)
+	require.Len(t, group.Members[BOB], 1)
+	require.Equal(t, "moderator", group.Members[BOB][0].Name, "explicit roles win over the default")
+	require.Len(t, group.Members[CAROL], 1)
+	require.Equal(t, "member", group.Members[CAROL][0].Name)
+
+	// every member has their own copy
+	group.Members[ALICE][0].Description = "can't chat anymore"
+	require.Equal(t, "can chat", group.Members[CAROL][0].Description)
+	require.Equal(t, "can chat", group.DefaultRole.Description)
+
+	// the default is only for new members, existing ones put again without roles end up with none
+	action, _ = GetModerationAction(&nostr.Event{Kind: nostr.KindSimpleGroupPutUser, Tags: nostr.Tags{{"h", "xyz"}, {"p", CAROL}}})
+	action.Apply(group)
+	require.Empty(t, group.Members[CAROL])
+}
+
+func TestActionJSONRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		action   Action
+		expected string
+	}{
+		{
+			PutUser{Targets: []PubKeyRoles{{PubKey: ALICE, RoleNames: []string{"admin"}}, {PubKey: BOB}}, When: 12},
+			`{"action":"put-user","targets":[{"pubkey":"` + ALICE + `","roles":["admin"]},{"pubkey":"` + BOB + `"}],"when":12}`,
+		},
+		{
+			RemoveUser{Targets: []string{CAROL, DEREK}, When: 13},
+			`{"action":"remove-user","targets":["` + CAROL + `","` + DEREK + `"],"when":13}`,
+		},
+		{
+			JoinRequest{PubKey: ALICE, When: 14},
+			`{"action":"join-request","pubkey":"` + ALICE + `","when":14}`,
+		},
+		{
+			DeleteEvent{Targets: []string{ALICE, BOB}, Skipped: 1, When: 15},
+			`{"action":"delete-event","targets":["` + ALICE + `","` + BOB + `"],"skipped":1,"when":15}`,
+		},
+	} {
+		t.Run(tc.action.Name(), func(t *testing.T) {
+			j, err := json.Marshal(tc.action)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(j))
+
+			decoded, err := UnmarshalAction(j)
+			require.NoError(t, err)
+			require.Equal(t, tc.action, decoded)
+		})
+	}
+
+	_, err := UnmarshalAction([]byte(`{"action":"explode","targets":[]}`))
+	require.Error(t, err)
+	_, err = UnmarshalAction([]byte(`{"action":"remove-user","targets":"nope"}`))
+	require.Error(t, err)
+	_, err = UnmarshalAction([]byte(`not json`))
+	require.Error(t, err)
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	perms := func(list ...Permission) map[Permission]struct{} {
+		set := make(map[Permission]struct{}, len(list))
+		for _, p := range list {
+			set[p] = struct{}{}
+		}
+		return set
+	}
+
+	group := NewGroupWithID("xyz")
+	group.Roles = []*Role{
+		{Name: MasterRoleName},
+		{Name: "admin", Permissions: perms(PermissionPutUser, PermissionRemoveUser, PermissionEditMetadata)},
+		{Name: "janitor", Permissions: perms(PermissionDeleteEvent)},
+	}
+
+	// roles coming from events only have names, the permissions come from the group definition
+	group.Members[ALICE] = []*Role{{Name: MasterRoleName}}
+	group.Members[BOB] = []*Role{{Name: "admin"}, {Name: "janitor"}}
+	group.Members[CAROL] = nil
+
+	require.Equal(t, perms(AllPermissions...), group.EffectivePermissions(ALICE))
+	require.Equal(t, perms(PermissionPutUser, PermissionRemoveUser, PermissionEditMetadata, PermissionDeleteEvent), group.EffectivePermissions(BOB))
+	require.Empty(t, group.EffectivePermissions(CAROL))
+	require.Empty(t, group.EffectivePermissions(DEREK), "non-members can't do anything")
+
+	// with a default role plain members get its permissions and others get them in addition to their own
+	group.DefaultRole = &Role{Name: "member", Permissions: perms(PermissionCreateInvite)}
+	require.Equal(t, perms(PermissionCreateInvite), group.EffectivePermissions(CAROL))
+	require.Equal(t, perms(PermissionPutUser, PermissionRemoveUser, PermissionEditMetadata, PermissionDeleteEvent, PermissionCreateInvite), group.EffectivePermissions(BOB))
+	require.Empty(t, group.EffectivePermissions(DEREK))
+
+	// the result can be modified freely
+	group.EffectivePermissions(CAROL)[PermissionDeleteGroup] = struct{}{}
+	require.NotContains(t, group.DefaultRole.Permissions, PermissionDeleteGroup)
+}
+
+func TestGroupEqual(t *testing.T) {
+	perms := func(list ...Permission) map[Permission]struct{} {
+		set := make(map[Permission]struct{}, len(list))
+		for _, p := range list {
+			set[p] = struct{}{}
+		}
+		return set
+	}
+
+	// builds a new group from scratch every time so nothing is shared between them
+	build := func() *Group {
+		group := NewGroupWithID("xyz")
+		group.Address.Relay = "wss://relay.com"
+		group.Name = "the group"
+		group.About = "about it"
+		group.Closed = true
+		group.Roles = []*Role{
+			{Name: "admin", Description: "can do things", Permissions: perms(PermissionPutUser, PermissionRemoveUser)},
+			{Name: "janitor", Permissions: perms(PermissionDeleteEvent)},
+		}
+		group.Members[ALICE] = []*Role{{Name: "admin"}, {Name: "janitor"}}
+		group.Members[BOB] = nil
+		group.RelayHints[ALICE] = "wss://alice.com"
+		group.PendingJoins[CAROL] = 10
+		group.LastMembersUpdate = 20
+		return group
+	}
+
+	require.True(t, build().Equal(*build()))
+	require.True(t, NewGroupWithID("xyz").Equal(*NewGroupWithID("xyz")))
+	require.True(t, NewGroupWithID("xyz").Equal(Group{Address: GroupAddress{ID: "xyz"}, Name: "xyz"}), "nil and empty maps are the same")
+
+	for name, change := range map[string]func(g *Group){
+		"relay":          func(g *Group) { g.Address.Relay = "wss://other.com" },
+		"name":           func(g *Group) { g.Name = "other" },
+		"private":        func(g *Group) { g.Private = true },
+		"timestamp":      func(g *Group) { g.LastMembersUpdate++ },
+		"new member":     func(g *Group) { g.Members[DEREK] = nil },
+		"removed member": func(g *Group) { delete(g.Members, BOB) },
+		"member role":    func(g *Group) { g.Members[BOB] = []*Role{{Name: "janitor"}} },
+		"role removed":   func(g *Group) { g.Members[ALICE] = g.Members[ALICE][0:1] },
+		"role duplicated": func(g *Group) {
+			g.Members[ALICE] = []*Role{{Name: "admin"}, {Name: "admin"}}
+		},
+		"role description":   func(g *Group) { g.Roles[0].Description = "can't do anything" },
+		"role permission":    func(g *Group) { g.Roles[1].Permissions[PermissionDeleteGroup] = struct{}{} },
+		"no permissions":     func(g *Group) { g.Roles[1].Permissions = nil },
+		"extra role":         func(g *Group) { g.Roles = append(g.Roles, &Role{Name: "other"}) },
+		"default role":       func(g *Group) { g.DefaultRole = &Role{Name: "member"} },
+		"relay hint":         func(g *Group) { g.RelayHints[BOB] = "wss://bob.com" },
+		"pending join":       func(g *Group) { g.PendingJoins[DEREK] = 11 },
+		"pending join time":  func(g *Group) { g.PendingJoins[CAROL] = 11 },
+		"member permissions": func(g *Group) { g.Members[ALICE][0].Permissions = perms(PermissionEditMetadata) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			changed := build()
+			change(changed)
+			require.False(t, build().Equal(*changed))
+			require.False(t, changed.Equal(*build()))
+		})
+	}
+
+	t.Run("order doesn't matter", func(t *testing.T) {
+		changed := build()
+		slices.Reverse(changed.Roles)
+		slices.Reverse(changed.Members[ALICE])
+		require.True(t, build().Equal(*changed))
+	})
+
+	t.Run("callbacks are ignored", func(t *testing.T) {
+		changed := build()
+		changed.OnMembersChanged = func(added, removed []string) {}
+		require.True(t, build().Equal(*changed))
+	})
+
+	t.Run("default role permissions", func(t *testing.T) {
+		a, b := build(), build()
+		a.DefaultRole = &Role{Name: "member", Permissions: perms(PermissionCreateInvite)}
+		b.DefaultRole = &Role{Name: "member", Permissions: perms(PermissionCreateInvite)}
+		require.True(t, a.Equal(*b))
+		b.DefaultRole.Permissions = perms(PermissionCreateInvite, PermissionDeleteEvent)
+		require.False(t, a.Equal(*b))
+	})
+}
+
+func TestGroupIDValidation(t *testing.T) {
+	for _, tc := range []struct {
+		id         string
+		valid      bool
+		normalized string
+	}{
+		{"xyz", true, "xyz"},
+		{"a", true, "a"},
+		{"_", true, "_"},
+		{"my-group_2", true, "my-group_2"},
+		{strings.Repeat("a", MaxGroupIDLength), true, strings.Repeat("a", MaxGroupIDLength)},
+		{"", false, ""},
+		{"   ", false, ""},
+		{"XYZ", false, "xyz"},
+		{" My-Group ", false, "my-group"},
+		{"with space", false, ""},
+		{"dots.are.bad", false, ""},
+		{"slash/", false, ""},
+		{"quote'", false, ""},
+		{"ção", false, ""},
+		{"emoji🙂", false, ""},
+		{strings.Repeat("a", MaxGroupIDLength+1), false, ""},
+	} {
+		require.Equal(t, tc.valid, IsValidGroupID(tc.id), "IsValidGroupID(%q)", tc.id)
+		require.Equal(t, tc.normalized, NormalizeGroupID(tc.id), "NormalizeGroupID(%q)", tc.id)
+	}
+
+	group, err := NewGroup("relay.com'XYZ")
+	require.NoError(t, err)
+	require.Equal(t, "xyz", group.Address.ID)
+
+	_, err = NewGroup("relay.com'x.y.z")
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+
+	_, err = NewGroupFromMetadataEvent("wss://relay.com", &nostr.Event{Kind: nostr.KindSimpleGroupMetadata, Tags: nostr.Tags{{"d", "x y z"}}})
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+
+	require.ErrorIs(t, group.MergeInMembersEvent(&nostr.Event{Kind: nostr.KindSimpleGroupMembers, Tags: nostr.Tags{{"d", "XYZ"}}}), ErrInvalidGroupID)
+
+	_, err = GetModerationAction(&nostr.Event{Kind: nostr.KindSimpleGroupPutUser, Tags: nostr.Tags{{"h", "x/y"}, {"p", ALICE}}})
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+	_, err = GetModerationAction(&nostr.Event{Kind: nostr.KindSimpleGroupPutUser, Tags: nostr.Tags{{"p", ALICE}}})
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+}
+
+func TestChatMessage(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+
+	msg := NewChatMessage("xyz", "hello group")
+	require.Equal(t, nostr.KindSimpleGroupChatMessage, msg.Kind)
+	require.NoError(t, msg.Sign(sk))
+
+	groupID, content, replyTo, err := ParseChatMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, "xyz", groupID)
+	require.Equal(t, "hello group", content)
+	require.Empty(t, replyTo)
+
+	// through json and back, as a reply
+	reply := NewChatMessage("xyz", "hello to you too")
+	reply.Tags = append(reply.Tags, nostr.Tag{"q", msg.ID, "wss://relay.com", msg.PubKey})
+	require.NoError(t, reply.Sign(sk))
+	j, err := json.Marshal(reply)
+	require.NoError(t, err)
+	var decoded nostr.Event
+	require.NoError(t, json.Unmarshal(j, &decoded))
+
+	groupID, content, replyTo, err = ParseChatMessage(&decoded)
+	require.NoError(t, err)
+	require.Equal(t, "xyz", groupID)
+	require.Equal(t, "hello to you too", content)
+	require.Equal(t, msg.ID, replyTo)
+
+	// older clients use "e" tags
+	_, _, replyTo, err = ParseChatMessage(&nostr.Event{Kind: 9, Tags: nostr.Tags{{"h", "xyz"}, {"e", msg.ID}}})
+	require.NoError(t, err)
+	require.Equal(t, msg.ID, replyTo)
+
+	_, _, _, err = ParseChatMessage(&nostr.Event{Kind: 1, Tags: nostr.Tags{{"h", "xyz"}}})
+	require.Error(t, err)
+	_, _, _, err = ParseChatMessage(&nostr.Event{Kind: 9})
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+	_, _, _, err = ParseChatMessage(NewChatMessage("X Y Z", "bad"))
+	require.ErrorIs(t, err, ErrInvalidGroupID)
+}
+
+func TestJoinability(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	group.Members[ALICE] = nil
+	group.Members[BOB] = []*Role{{Name: "admin"}}
+
+	require.Equal(t, AlreadyMember, group.Joinability(ALICE))
+	require.Equal(t, AlreadyMember, group.Joinability(BOB))
+	require.Equal(t, Open, group.Joinability(CAROL))
+
+	group.Private = true
+	require.Equal(t, Open, group.Joinability(CAROL), "private doesn't mean closed")
+
+	group.Closed = true
+	require.Equal(t, RequestRequired, group.Joinability(CAROL))
+	require.Equal(t, AlreadyMember, group.Joinability(ALICE))
+
+	// a pending request doesn't change anything
+	group.PendingJoins[CAROL] = 10
+	require.Equal(t, RequestRequired, group.Joinability(CAROL))
+
+	// the answer matches what happens on a join request
+	group.Closed = false
+	require.Equal(t, Open, group.Joinability(DEREK))
+	require.NoError(t, group.HandleJoinRequest(&nostr.Event{Kind: nostr.KindSimpleGroupJoinRequest, PubKey: DEREK, CreatedAt: 1, Tags: nostr.Tags{{"h", "xyz"}}}))
+	require.Equal(t, AlreadyMember, group.Joinability(DEREK))
+
+	require.Equal(t, "request-required", RequestRequired.String())
+}
+
+func TestDeleteEventAction(t *testing.T) {
+	// ids are just 32-byte hex, so the pubkey constants serve as event ids here
+	action, err := GetModerationAction(&nostr.Event{
+		Kind:      nostr.KindSimpleGroupDeleteEvent,
+		CreatedAt: 20,
+		Tags: nostr.Tags{
+			{"h", "xyz"},
+			{"e", CAROL},
+			{"e", "not-an-id"},
+			{"e", ALICE},
+			{"p", BOB},
+			{"e", CAROL},
+			{"e", ALICE[1:]},
+			{"e", DEREK},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, DeleteEvent{Targets: []string{CAROL, ALICE, DEREK}, Skipped: 2, When: 20}, action)
+
+	// applying doesn't touch the group
+	group := NewGroupWithID("xyz")
+	group.Members[BOB] = nil
+	action.Apply(group)
+	require.Len(t, group.Members, 1)
+	require.Contains(t, group.Members, BOB)
+
+	// only invalid ids is an error
+	_, err = GetModerationAction(&nostr.Event{
+		Kind: nostr.KindSimpleGroupDeleteEvent,
+		Tags: nostr.Tags{{"h", "xyz"}, {"e", "nope"}},
+	})
+	require.Error(t, err)
+	_, err = GetModerationAction(&nostr.Event{
+		Kind: nostr.KindSimpleGroupDeleteEvent,
+		Tags: nostr.Tags{{"h", "xyz"}},
+	})
+	require.Error(t, err)
+}
+
+func TestMetadataRoundTripWithoutName(t *testing.T) {
+	group := NewGroupWithID("xyz")
+	group.About = "no name here"
+	group.LastMetadataUpdate = 10
+	meta := group.ToMetadataEvent()
+	require.Nil(t, meta.Tags.GetFirst([]string{"name", ""}))
+
+	group2, err := NewGroupFromMetadataEvent("wss://relay.com", meta)
+	require.NoError(t, err)
+	require.Equal(t, "xyz", group2.Name, "name still defaults to the id")
+	meta2 := group2.ToMetadataEvent()
+	require.Nil(t, meta2.Tags.GetFirst([]string{"name", ""}), "round trip invented a name: %s", meta2)
+	require.Equal(t, meta.Tags, meta2.Tags)
+
+	// a name explicitly equal to the id is kept
+	meta.Tags = append(meta.Tags, nostr.Tag{"name", "xyz"})
+	group3, err := NewGroupFromMetadataEvent("wss://relay.com", meta)
+	require.NoError(t, err)
+	require.NotNil(t, group3.ToMetadataEvent().Tags.GetFirst([]string{"name", "xyz"}))
+	require.False(t, group2.Equal(group3))
+
+	// and a different name is always emitted
+	group.Name = "banana"
+	require.NotNil(t, group.ToMetadataEvent().Tags.GetFirst([]string{"name", "banana"}))
+}
+
+func TestApplyEventIsOrderIndependent(t *testing.T) {
+	state := func(kind int, ts nostr.Timestamp, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{Kind: kind, CreatedAt: ts, Tags: append(nostr.Tags{{"d", "xyz"}}, tags...)}
+	}
+	moderation := func(kind int, ts nostr.Timestamp, tags ...nostr.Tag) *nostr.Event {
+		return &nostr.Event{Kind: kind, CreatedAt: ts, Tags: append(nostr.Tags{{"h", "xyz"}}, tags...)}
+	}
+	request := func(kind int, pubkey string, ts nostr.Timestamp) *nostr.Event {
+		return &nostr.Event{Kind: kind, PubKey: pubkey, CreatedAt: ts, Tags: nostr.Tags{{"h", "xyz"}}}
+	}
+
+	stream := []*nostr.Event{
+		state(nostr.KindSimpleGroupMetadata, 10, nostr.Tag{"name", "first"}, nostr.Tag{"closed"}),
+		moderation(nostr.KindSimpleGroupPutUser, 11, nostr.Tag{"p", CAROL}), // before the members list, ignored
+		request(nostr.KindSimpleGroupJoinRequest, BOB, 12),
+		state(nostr.KindSimpleGroupMembers, 15, nostr.Tag{"p", ALICE}, nostr.Tag{"p", BOB}),
+		state(nostr.KindSimpleGroupAdmins, 15, nostr.Tag{"p", ALICE, "admin"}),
+		moderation(nostr.KindSimpleGroupRemoveUser, 16, nostr.Tag{"p", BOB}),
+		moderation(nostr.KindSimpleGroupPutUser, 16, nostr.Tag{"p", CAROL, "moderator"}),
+		moderation(nostr.KindSimpleGroupPutUser, 17, nostr.Tag{"p", DEREK}),
+		moderation(nostr.KindSimpleGroupPutUser, 17, nostr.Tag{"p", ALICE}), // same time, different event
+		request(nostr.KindSimpleGroupJoinRequest, BOB, 18),
+		request(nostr.KindSimpleGroupLeaveRequest, DEREK, 19),
+		state(nostr.KindSimpleGroupMetadata, 20, nostr.Tag{"name", "second"}),
+	}
+
+	expected := NewGroupWithID("xyz")
+	for _, evt := range stream {
+		require.NoError(t, expected.ApplyEvent(evt))
+	}
+	require.Equal(t, "second", expected.Name)
+	require.False(t, expected.Closed)
+	require.Len(t, expected.Members, 3)
+	require.Empty(t, expected.Members[ALICE])
+	require.Equal(t, "moderator", expected.Members[CAROL][0].Name)
+	require.Contains(t, expected.Members, BOB, "joined again once the group was open")
+	require.NotContains(t, expected.Members, DEREK)
+	require.Empty(t, expected.PendingJoins)
+
+	// replaying everything changes nothing, stale state events are refused
+	for _, evt := range stream {
+		expected.ApplyEvent(evt)
+	}
+	require.Error(t, expected.ApplyEvent(stream[0]))
+
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		shuffled := slices.Clone(stream)
+		// some events are delivered twice
+		shuffled = append(shuffled, shuffled[rnd.IntN(len(shuffled))], shuffled[rnd.IntN(len(shuffled))])
+		rnd.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+		group := NewGroupWithID("xyz")
+		for _, evt := range shuffled {
+			group.ApplyEvent(evt)
+		}
+		require.True(t, expected.Equal(*group), "%s != %s", expected, group)
+	}
+}
+
+func TestApplyEventChecksGroupAndTrimsLog(t *testing.T) {
+	group := NewGroupWithID("xyz")
+
+	for _, evt := range []*nostr.Event{
+		{Kind: nostr.KindSimpleGroupPutUser, CreatedAt: 1, Tags: nostr.Tags{{"h", "abc"}, {"p", ALICE}}},
+		{Kind: nostr.KindSimpleGroupMembers, CreatedAt: 1, Tags: nostr.Tags{{"d", "abc"}, {"p", ALICE}}},
+		{Kind: nostr.KindSimpleGroupJoinRequest, PubKey: ALICE, CreatedAt: 1, Tags: nostr.Tags{{"h", "abc"}}},
+		{Kind: nostr.KindSimpleGroupLeaveRequest, PubKey: ALICE, CreatedAt: 1, Tags: nostr.Tags{{"h", "abc"}}},
+	} {
+		require.ErrorIs(t, group.ApplyEvent(evt), ErrWrongGroup, "kind %d", evt.Kind)
+	}
+	require.ErrorIs(t, group.ApplyEvent(&nostr.Event{Kind: nostr.KindSimpleGroupLeaveRequest, PubKey: ALICE, CreatedAt: 1}), ErrInvalidGroupID)
+	require.Empty(t, group.Members)
+	require.Empty(t, group.moderationLog)
+
+	for ts := range nostr.Timestamp(10) {
+		require.NoError(t, group.ApplyEvent(&nostr.Event{Kind: nostr.KindSimpleGroupPutUser, CreatedAt: ts, Tags: nostr.Tags{{"h", "xyz"}, {"p", BOB}}}))
+	}
+	require.Len(t, group.moderationLog, 10)
+
+	// a members list makes everything before it unnecessary
+	require.NoError(t, group.ApplyEvent(&nostr.Event{Kind: nostr.KindSimpleGroupMembers, CreatedAt: 7, Tags: nostr.Tags{{"d", "xyz"}, {"p", ALICE}}}))
+	require.Len(t, group.moderationLog, 3)
+	require.Contains(t, group.Members, BOB, "re-added after the members list")
+
+	// and older events aren't logged anymore
+	require.NoError(t, group.ApplyEvent(&nostr.Event{Kind: nostr.KindSimpleGroupRemoveUser, CreatedAt: 5, Tags: nostr.Tags{{"h", "xyz"}, {"p", ALICE}}}))
+	require.Len(t, group.moderationLog, 3)
+	require.Contains(t, group.Members, ALICE)
+}
+
+func TestMixedCaseMembersAreMerged(t *testing.T) {
+	upper := strings.ToUpper(ALICE)
+	mixed := strings.ToUpper(ALICE[0:32]) + ALICE[32:]
+
+	group := NewGroupWithID("xyz")
+	require.NoError(t, group.MergeInMembersEvent(&nostr.Event{
+		Kind:      nostr.KindSimpleGroupMembers,
+		CreatedAt: 10,
+		Tags:      nostr.Tags{{"d", "xyz"}, {"p", ALICE}, {"p", upper}, {"p", mixed}},
+	}))
+	require.NoError(t, group.MergeInAdminsEvent(&nostr.Event{
+		Kind:      nostr.KindSimpleGroupAdmins,
+		CreatedAt: 10,
+		Tags:      nostr.Tags{{"d", "xyz"}, {"p", upper, "admin"}, {"p", mixed, "admin"}},
+	}))
+	PutUser{Targets: []PubKeyRoles{{PubKey: upper}}}.Apply(group)
+	JoinRequest{PubKey: mixed}.Apply(group)
+	require.NoError(t, group.ApplyEvent(&nostr.Event{
+		Kind:      nostr.KindSimpleGroupPutUser,
+		CreatedAt: 11,
+		Tags:      nostr.Tags{{"h", "xyz"}, {"p", mixed, "admin"}},
+	}))
+
+	require.Len(t, group.Members, 1)
+	require.Len(t, group.Members[ALICE], 1)
+	require.Equal(t, "admin", group.Members[ALICE][0].Name)
+	require.Equal(t, nostr.Tags{{"d", "xyz"}, {"p", ALICE}}, group.ToMembersEvent().Tags)
+
+	RemoveUser{Targets: []string{upper}}.Apply(group)
+	require.Empty(t, group.Members)
+
+	// members inserted directly are fixed by NormalizeMembers
+	group.Members[ALICE] = []*Role{{Name: "admin"}}
+	group.Members[upper] = []*Role{{Name: "admin"}, {Name: "moderator"}}
+	group.Members["not-a-key"] = nil
+	group.NormalizeMembers()
+	require.Len(t, group.Members, 1)
+	require.Len(t, group.Members[ALICE], 2)
 }
@@ -0,0 +1,69 @@
+package nip29
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HandleJoinRequest processes a join request (kind 9021) from a user.
+//
+// If the group is open the user is admitted immediately, otherwise the request is kept in
+// group.PendingJoins until an admin adds the user (or the user gives up with a leave request).
+//
+// It goes through ApplyEvent, so requests are deduplicated and ordered along with the other moderation events.
+func (group *Group) HandleJoinRequest(evt *nostr.Event) error {
+	if evt.Kind != nostr.KindSimpleGroupJoinRequest {
+		return fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupJoinRequest, evt.Kind)
+	}
+	return group.ApplyEvent(evt)
+}
+
+func (group *Group) handleJoinRequest(evt *nostr.Event) {
+	action, err := GetModerationAction(evt)
+	if err != nil {
+		return
+	}
+
+	pubkey, ok := normalizePubKey(evt.PubKey)
+	if !ok {
+		return
+	}
+
+	if _, isMember := group.Members[pubkey]; isMember {
+		return
+	}
+
+	if !group.Closed {
+		action.Apply(group)
+		return
+	}
+
+	if group.PendingJoins == nil {
+		group.PendingJoins = make(map[string]nostr.Timestamp)
+	}
+	if evt.CreatedAt > group.PendingJoins[pubkey] {
+		group.PendingJoins[pubkey] = evt.CreatedAt
+	}
+}
+
+// HandleLeaveRequest processes a leave request (kind 9022) from a user, removing them from
+// the group members or from the pending join requests.
+//
+// Like HandleJoinRequest, it goes through ApplyEvent.
+func (group *Group) HandleLeaveRequest(evt *nostr.Event) error {
+	if evt.Kind != nostr.KindSimpleGroupLeaveRequest {
+		return fmt.Errorf("expected kind %d, got %d", nostr.KindSimpleGroupLeaveRequest, evt.Kind)
+	}
+	return group.ApplyEvent(evt)
+}
+
+func (group *Group) handleLeaveRequest(evt *nostr.Event) {
+	pubkey, ok := normalizePubKey(evt.PubKey)
+	if !ok {
+		return
+	}
+
+	delete(group.PendingJoins, pubkey)
+	RemoveUser{Targets: []string{pubkey}, When: evt.CreatedAt}.Apply(group)
+}
@@ -1,6 +1,12 @@
 package nip29
 
-import "slices"
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
 
 func (group Group) GetRoleByName(name string) *Role {
 	idx := slices.IndexFunc(group.Roles, func(role *Role) bool { return role.Name == name })
@@ -10,3 +16,155 @@ func (group Group) GetRoleByName(name string) *Role {
 		return group.Roles[idx]
 	}
 }
+
+// normalizePubKey returns pubkey in lowercase, the form used for the keys of Members, and tells if it is a
+// valid 32-byte hex public key.
+func normalizePubKey(pubkey string) (string, bool) {
+	pubkey = strings.ToLower(pubkey)
+	return pubkey, nostr.IsValid32ByteHex(pubkey)
+}
+
+// NormalizeMembers rewrites the keys of Members in lowercase, merging the roles of entries that differ only
+// in case and dropping the ones that aren't valid public keys. The MergeIn* methods and the actions already
+// normalize the keys they insert, so this is only needed when Members was filled in some other way.
+func (group *Group) NormalizeMembers() {
+	for pubkey, roles := range group.Members {
+		canonical, ok := normalizePubKey(pubkey)
+		if ok && canonical == pubkey {
+			continue
+		}
+
+		delete(group.Members, pubkey)
+		if !ok {
+			continue
+		}
+		merged := group.Members[canonical]
+		for _, role := range roles {
+			if !slices.ContainsFunc(merged, func(r *Role) bool { return r.Name == role.Name }) {
+				merged = append(merged, role)
+			}
+		}
+		group.Members[canonical] = merged
+	}
+}
+
+func (group *Group) notifyMembersChanged(added, removed []string) {
+	if group.OnMembersChanged != nil && (len(added) > 0 || len(removed) > 0) {
+		group.OnMembersChanged(added, removed)
+	}
+}
+
+// defaultRoles returns the roles a new member should get, with a fresh copy of DefaultRole
+// so changing one member's role doesn't affect the others.
+func (group *Group) defaultRoles() []*Role {
+	if group.DefaultRole == nil {
+		return nil
+	}
+	role := *group.DefaultRole
+	role.Permissions = maps.Clone(role.Permissions)
+	return []*Role{&role}
+}
+
+// EffectivePermissions returns everything the given user can do in the group: all permissions if they have
+// the master role, otherwise the union of the permissions of their roles (as defined in group.Roles) and of
+// the group's DefaultRole. Non-members can't do anything.
+func (group Group) EffectivePermissions(pubkey string) map[Permission]struct{} {
+	perms := make(map[Permission]struct{})
+
+	roles, isMember := group.Members[pubkey]
+	if !isMember {
+		return perms
+	}
+
+	if group.DefaultRole != nil {
+		maps.Copy(perms, group.DefaultRole.Permissions)
+	}
+
+	for _, role := range roles {
+		if role.Name == MasterRoleName {
+			for _, perm := range AllPermissions {
+				perms[perm] = struct{}{}
+			}
+			return perms
+		}
+
+		// the group definition of the role is authoritative, members may hold just a reference to its name
+		maps.Copy(perms, group.GetRoleByName(role.Name).Permissions)
+		maps.Copy(perms, role.Permissions)
+	}
+
+	return perms
+}
+
+// Joinability tells if pubkey is already a member of the group and, if not, whether they can join just by
+// sending a join request or need to have it approved. Private groups can still be open.
+func (group Group) Joinability(pubkey string) Joinability {
+	if _, isMember := group.Members[pubkey]; isMember {
+		return AlreadyMember
+	}
+	if group.Closed {
+		return RequestRequired
+	}
+	return Open
+}
+
+// Equal tells if two groups have the same state, comparing members, roles (in any order) and
+// their permissions by value. OnMembersChanged is ignored.
+func (group Group) Equal(other Group) bool {
+	if group.Address != other.Address ||
+		group.Name != other.Name ||
+		group.hasName != other.hasName ||
+		group.Picture != other.Picture ||
+		group.About != other.About ||
+		group.Private != other.Private ||
+		group.Closed != other.Closed ||
+		group.LastMetadataUpdate != other.LastMetadataUpdate ||
+		group.LastAdminsUpdate != other.LastAdminsUpdate ||
+		group.LastMembersUpdate != other.LastMembersUpdate ||
+		group.LastRolesUpdate != other.LastRolesUpdate {
+		return false
+	}
+
+	if !group.DefaultRole.Equal(other.DefaultRole) ||
+		!rolesEqual(group.Roles, other.Roles) ||
+		!maps.Equal(group.RelayHints, other.RelayHints) ||
+		!maps.Equal(group.PendingJoins, other.PendingJoins) ||
+		!maps.Equal(group.lastAppliedByKind, other.lastAppliedByKind) {
+		return false
+	}
+
+	return maps.EqualFunc(group.Members, other.Members, rolesEqual)
+}
+
+// Equal compares two roles by value, including their permissions.
+func (role *Role) Equal(other *Role) bool {
+	if role == nil || other == nil {
+		return role == other
+	}
+	return role.Name == other.Name &&
+		role.Description == other.Description &&
+		maps.Equal(role.Permissions, other.Permissions)
+}
+
+// rolesEqual tells if two lists have the same roles, regardless of their order.
+func rolesEqual(a, b []*Role) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	matched := make([]bool, len(b))
+	for _, ra := range a {
+		found := false
+		for j, rb := range b {
+			if !matched[j] && ra.Equal(rb) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
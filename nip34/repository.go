@@ -2,7 +2,6 @@ package nip34
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -118,7 +117,7 @@ func (repo Repository) GetPatchesSync(ctx context.Context, s nostr.RelayStore) [
 	res, _ := s.QuerySync(ctx, nostr.Filter{
 		Kinds: []int{nostr.KindPatch},
 		Tags: nostr.TagMap{
-			"a": []string{fmt.Sprintf("%d:%s:%s", nostr.KindRepositoryAnnouncement, repo.Event.PubKey, repo.ID)},
+			"a": []string{nostr.EntityAddress(nostr.KindRepositoryAnnouncement, repo.Event.PubKey, repo.ID)},
 		},
 	})
 	patches := make([]Patch, len(res))
@@ -117,25 +117,41 @@ type EntityPointer struct {
 	Relays     []string `json:"relays,omitempty"`
 }
 
-// EntityPointerFromTag creates an EntityPointer from an "a" tag (but it doesn't check if the tag is really "a", it could be anything).
-func EntityPointerFromTag(refTag Tag) (EntityPointer, error) {
-	spl := strings.SplitN(refTag[1], ":", 3)
+// EntityAddress returns the canonical "<kind>:<pubkey>:<identifier>" address of a replaceable or addressable
+// event, as used in "a" tags. The last colon is always present, even when identifier is empty.
+func EntityAddress(kind int, pubkey string, identifier string) string {
+	return strconv.Itoa(kind) + ":" + pubkey + ":" + identifier
+}
+
+// ParseEntityAddress parses an address in the format produced by EntityAddress.
+func ParseEntityAddress(addr string) (kind int, pubkey string, identifier string, err error) {
+	spl := strings.SplitN(addr, ":", 3)
 	if len(spl) != 3 {
-		return EntityPointer{}, fmt.Errorf("invalid addr ref '%s'", refTag[1])
+		return 0, "", "", fmt.Errorf("invalid addr ref '%s'", addr)
 	}
 	if !IsValidPublicKey(spl[1]) {
-		return EntityPointer{}, fmt.Errorf("invalid addr pubkey '%s'", spl[1])
+		return 0, "", "", fmt.Errorf("invalid addr pubkey '%s'", spl[1])
+	}
+
+	kind, err = strconv.Atoi(spl[0])
+	if err != nil || kind < 0 || kind > (1<<16) {
+		return 0, "", "", fmt.Errorf("invalid addr kind '%s'", spl[0])
 	}
 
-	kind, err := strconv.Atoi(spl[0])
-	if err != nil || kind > (1<<16) {
-		return EntityPointer{}, fmt.Errorf("invalid addr kind '%s'", spl[0])
+	return kind, spl[1], spl[2], nil
+}
+
+// EntityPointerFromTag creates an EntityPointer from an "a" tag (but it doesn't check if the tag is really "a", it could be anything).
+func EntityPointerFromTag(refTag Tag) (EntityPointer, error) {
+	kind, pubkey, identifier, err := ParseEntityAddress(refTag[1])
+	if err != nil {
+		return EntityPointer{}, err
 	}
 
 	pointer := EntityPointer{
 		Kind:       kind,
-		PublicKey:  spl[1],
-		Identifier: spl[2],
+		PublicKey:  pubkey,
+		Identifier: identifier,
 	}
 	if len(refTag) > 2 {
 		if relay := (refTag)[2]; IsValidRelayURL(relay) {
@@ -154,7 +170,7 @@ func (ep EntityPointer) MatchesEvent(evt Event) bool {
 }
 
 func (ep EntityPointer) AsTagReference() string {
-	return fmt.Sprintf("%d:%s:%s", ep.Kind, ep.PublicKey, ep.Identifier)
+	return EntityAddress(ep.Kind, ep.PublicKey, ep.Identifier)
 }
 
 func (ep EntityPointer) AsFilter() Filter {
@@ -0,0 +1,44 @@
+package nostr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntityAddress(t *testing.T) {
+	pk := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+
+	for _, test := range []struct {
+		kind       int
+		identifier string
+		expected   string
+	}{
+		{30023, "banana", "30023:" + pk + ":banana"},
+		{30023, "", "30023:" + pk + ":"},
+		{10002, "", "10002:" + pk + ":"},
+		{30023, "with:colons", "30023:" + pk + ":with:colons"},
+	} {
+		addr := EntityAddress(test.kind, pk, test.identifier)
+		require.Equal(t, test.expected, addr)
+
+		kind, pubkey, identifier, err := ParseEntityAddress(addr)
+		require.NoError(t, err)
+		require.Equal(t, test.kind, kind)
+		require.Equal(t, pk, pubkey)
+		require.Equal(t, test.identifier, identifier)
+
+		require.Equal(t, addr, EntityPointer{PublicKey: pk, Kind: test.kind, Identifier: test.identifier}.AsTagReference())
+	}
+
+	for _, invalid := range []string{
+		"",
+		"30023:" + pk,
+		"banana:" + pk + ":",
+		"-1:" + pk + ":",
+		"30023:abc:xyz",
+	} {
+		_, _, _, err := ParseEntityAddress(invalid)
+		require.Error(t, err, "'%s' should be invalid", invalid)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"math"
 	"net/http"
 	"slices"
@@ -31,6 +32,16 @@ type SimplePool struct {
 	eventMiddleware     func(RelayEvent)
 	duplicateMiddleware func(relay string, id string)
 	queryMiddleware     func(relay string, pubkey string, kind int)
+	noticeMiddleware    func(relay string, notice string)
+
+	dropPolicy DropPolicy
+	dropped    atomic.Int64
+
+	metrics *xsync.MapOf[string, *labelCounters]
+
+	goroutines   sync.WaitGroup
+	goroutinesMu sync.Mutex
+	shutdown     bool
 
 	// custom things not often used
 	penaltyBoxMu sync.Mutex
@@ -48,9 +59,23 @@ type DirectedFilter struct {
 type RelayEvent struct {
 	*Event
 	Relay *Relay
+
+	// ReceivedAt is the moment the event was received by us from Relay.
+	ReceivedAt time.Time
+
+	// EOSE is only set on the sentinel emitted by SubscribeManyWithEOSE, which has no Event or Relay.
+	EOSE bool
+
+	// RawEnvelope is the EVENT message exactly as Relay sent it, only set when subscribing WithRawEnvelopes.
+	RawEnvelope []byte
 }
 
-func (ie RelayEvent) String() string { return fmt.Sprintf("[%s] >> %s", ie.Relay.URL, ie.Event) }
+func (ie RelayEvent) String() string {
+	if ie.EOSE {
+		return "[*] >> EOSE"
+	}
+	return fmt.Sprintf("[%s] >> %s", ie.Relay.URL, ie.Event)
+}
 
 // PoolOption is an interface for options that can be applied to a SimplePool.
 type PoolOption interface {
@@ -62,7 +87,8 @@ func NewSimplePool(ctx context.Context, opts ...PoolOption) *SimplePool {
 	ctx, cancel := context.WithCancelCause(ctx)
 
 	pool := &SimplePool{
-		Relays: xsync.NewMapOf[string, *Relay](),
+		Relays:  xsync.NewMapOf[string, *Relay](),
+		metrics: xsync.NewMapOf[string, *labelCounters](),
 
 		Context: ctx,
 		cancel:  cancel,
@@ -153,10 +179,45 @@ func (h WithAuthorKindQueryMiddleware) ApplyPoolOption(pool *SimplePool) {
 	pool.queryMiddleware = h
 }
 
+// WithNoticeMiddleware is a function that will be called with every NOTICE received from any relay, besides
+// the notice handler each relay may have been given with WithRelayOptions.
+type WithNoticeMiddleware func(relay string, notice string)
+
+func (h WithNoticeMiddleware) ApplyPoolOption(pool *SimplePool) {
+	pool.noticeMiddleware = h
+}
+
+// DropPolicy says what a pool subscription does with an event when its consumer isn't reading fast enough.
+type DropPolicy int
+
+const (
+	// DropPolicyBlock waits for the consumer to read, so no events are lost. This is the default.
+	DropPolicyBlock DropPolicy = iota
+	// DropPolicyDropOldest discards the oldest buffered event to make room for the new one.
+	DropPolicyDropOldest
+	// DropPolicyDropNewest discards the new event when the buffer is full.
+	DropPolicyDropNewest
+)
+
+// dropPolicyBufferSize is how many events subscriptions buffer when a policy other than DropPolicyBlock is used.
+const dropPolicyBufferSize = 512
+
+// WithDropPolicy makes subscriptions buffer events and drop some of them, as given by policy, when the consumer
+// can't keep up, instead of holding up the relays. SimplePool.DroppedCount tells how many were lost.
+func WithDropPolicy(policy DropPolicy) withDropPolicyOpt { return withDropPolicyOpt(policy) }
+
+type withDropPolicyOpt DropPolicy
+
+func (h withDropPolicyOpt) ApplyPoolOption(pool *SimplePool) {
+	pool.dropPolicy = DropPolicy(h)
+}
+
 var (
 	_ PoolOption = (WithAuthHandler)(nil)
 	_ PoolOption = (WithEventMiddleware)(nil)
+	_ PoolOption = (WithNoticeMiddleware)(nil)
 	_ PoolOption = WithPenaltyBox()
+	_ PoolOption = WithDropPolicy(DropPolicyBlock)
 	_ PoolOption = WithRelayOptions(WithRequestHeader(http.Header{}))
 )
 
@@ -191,6 +252,18 @@ func (pool *SimplePool) EnsureRelay(url string) (*Relay, error) {
 	defer cancel()
 
 	relay = NewRelay(context.Background(), url, pool.relayOptions...)
+	relay.spawner = pool.Go
+	if mh := pool.noticeMiddleware; mh != nil {
+		handler := relay.noticeHandler
+		relay.noticeHandler = func(notice string) {
+			if handler != nil {
+				handler(notice)
+			} else {
+				log.Printf("NOTICE from %s: '%s'\n", nm, notice)
+			}
+			mh(nm, notice)
+		}
+	}
 	if err := relay.Connect(ctx); err != nil {
 		if pool.penaltyBox != nil {
 			// putting relay in penalty box
@@ -206,6 +279,199 @@ func (pool *SimplePool) EnsureRelay(url string) (*Relay, error) {
 	return relay, nil
 }
 
+// ConnectedRelays returns the URLs of the relays this pool currently has an open connection to.
+func (pool *SimplePool) ConnectedRelays() []string {
+	urls := make([]string, 0, pool.Relays.Size())
+	pool.Relays.Range(func(url string, relay *Relay) bool {
+		if relay != nil && relay.IsConnected() {
+			urls = append(urls, url)
+		}
+		return true
+	})
+	slices.Sort(urls)
+	return urls
+}
+
+// Go runs f in a new goroutine that Shutdown will wait for, f should return soon after pool.Context is done.
+// After Shutdown has been called f still runs, but isn't waited for anymore.
+func (pool *SimplePool) Go(f func()) {
+	pool.goroutinesMu.Lock()
+	if pool.shutdown {
+		pool.goroutinesMu.Unlock()
+		go f()
+		return
+	}
+	pool.goroutines.Add(1)
+	pool.goroutinesMu.Unlock()
+
+	go func() {
+		defer pool.goroutines.Done()
+		f()
+	}()
+}
+
+// DroppedCount returns how many events were dropped so far by this pool's subscriptions because of
+// the policy set with WithDropPolicy.
+func (pool *SimplePool) DroppedCount() int64 {
+	return pool.dropped.Load()
+}
+
+// LabelMetrics are the counters for the subscriptions made by a pool with a given WithLabel.
+type LabelMetrics struct {
+	Subscriptions int64 // subscriptions opened on relays
+	Events        int64 // events received from relays, including those the pool drops
+	EOSEs         int64
+	Errors        int64 // failures to connect or to subscribe and subscriptions closed by relays
+}
+
+type labelCounters struct {
+	subscriptions atomic.Int64
+	events        atomic.Int64
+	eoses         atomic.Int64
+	errors        atomic.Int64
+}
+
+// Metrics returns a snapshot of the counters of the subscriptions made through SubscribeMany, FetchMany and
+// the other methods that open subscriptions on many relays, keyed by the label given to them with WithLabel.
+// Subscriptions without a label are counted under "".
+func (pool *SimplePool) Metrics() map[string]LabelMetrics {
+	snapshot := make(map[string]LabelMetrics, pool.metrics.Size())
+	pool.metrics.Range(func(label string, c *labelCounters) bool {
+		snapshot[label] = LabelMetrics{
+			Subscriptions: c.subscriptions.Load(),
+			Events:        c.events.Load(),
+			EOSEs:         c.eoses.Load(),
+			Errors:        c.errors.Load(),
+		}
+		return true
+	})
+	return snapshot
+}
+
+func (pool *SimplePool) labelCounters(opts []SubscriptionOption) *labelCounters {
+	label := ""
+	for _, opt := range opts {
+		if l, ok := opt.(WithLabel); ok {
+			label = string(l)
+		}
+	}
+	c, _ := pool.metrics.LoadOrCompute(label, func() *labelCounters { return &labelCounters{} })
+	return c
+}
+
+func (pool *SimplePool) makeEventsChan() chan RelayEvent {
+	if pool.dropPolicy == DropPolicyBlock {
+		return make(chan RelayEvent)
+	}
+	return make(chan RelayEvent, dropPolicyBufferSize)
+}
+
+// deliver sends ie to events following the pool's drop policy, it returns false if ctx is canceled first.
+func (pool *SimplePool) deliver(ctx context.Context, events chan RelayEvent, ie RelayEvent) bool {
+	switch pool.dropPolicy {
+	case DropPolicyDropNewest:
+		select {
+		case events <- ie:
+		default:
+			pool.dropped.Add(1)
+		}
+	case DropPolicyDropOldest:
+		for {
+			select {
+			case events <- ie:
+				return true
+			default:
+			}
+
+			// make room by taking the oldest event out of the buffer
+			select {
+			case <-events:
+				pool.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case events <- ie:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// PingResult is what SimplePool.Ping found out about a single relay.
+type PingResult struct {
+	Connected bool
+	RTT       time.Duration
+	Err       error
+}
+
+// Ping checks which of the given relays are alive, reusing connections the pool already has or opening new ones,
+// and measures the round-trip time of a websocket ping to each of them. The results are keyed by normalized URL.
+//
+// NIP-11 documents aren't fetched here, use sdk.System.PingRelays for that.
+func (pool *SimplePool) Ping(ctx context.Context, urls []string) map[string]PingResult {
+	results := make(map[string]PingResult, len(urls))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	seen := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		nm := NormalizeURL(url)
+		if _, ok := seen[nm]; ok {
+			continue
+		}
+		seen[nm] = struct{}{}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := pool.ping(ctx, nm)
+			mu.Lock()
+			results[nm] = res
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+func (pool *SimplePool) ping(ctx context.Context, url string) PingResult {
+	type ensured struct {
+		relay *Relay
+		err   error
+	}
+	ch := make(chan ensured, 1)
+	pool.Go(func() {
+		relay, err := pool.EnsureRelay(url)
+		ch <- ensured{relay, err}
+	})
+
+	var relay *Relay
+	select {
+	case <-ctx.Done():
+		return PingResult{Err: context.Cause(ctx)}
+	case e := <-ch:
+		if e.err != nil {
+			return PingResult{Err: e.err}
+		}
+		relay = e.relay
+	}
+
+	conn := relay.connection()
+	if conn == nil {
+		return PingResult{Err: fmt.Errorf("not connected to %s", url)}
+	}
+
+	start := time.Now()
+	if err := conn.Ping(ctx); err != nil {
+		return PingResult{Connected: relay.IsConnected(), Err: fmt.Errorf("ping failed: %w", err)}
+	}
+	return PingResult{Connected: true, RTT: time.Since(start)}
+}
+
 // PublishResult represents the result of publishing an event to a relay.
 type PublishResult struct {
 	Error    error
@@ -217,7 +483,7 @@ type PublishResult struct {
 func (pool *SimplePool) PublishMany(ctx context.Context, urls []string, evt Event) chan PublishResult {
 	ch := make(chan PublishResult, len(urls))
 
-	go func() {
+	pool.Go(func() {
 		for _, url := range urls {
 			relay, err := pool.EnsureRelay(url)
 			if err != nil {
@@ -229,7 +495,7 @@ func (pool *SimplePool) PublishMany(ctx context.Context, urls []string, evt Even
 		}
 
 		close(ch)
-	}()
+	})
 
 	return ch
 }
@@ -278,6 +544,64 @@ func (pool *SimplePool) SubscribeManyNotifyEOSE(
 	return pool.subMany(ctx, urls, Filters{filter}, eoseChan, opts...)
 }
 
+// SubscribeManyWithEOSE is like SubscribeMany, but once all relays have sent an EOSE it emits a single
+// RelayEvent with EOSE set to true and no Event, then keeps streaming live events as they come.
+//
+// This allows callers to render all the stored events at once and then append new ones as they arrive.
+// If the subscription ends before all relays have sent an EOSE the sentinel isn't emitted.
+func (pool *SimplePool) SubscribeManyWithEOSE(
+	ctx context.Context,
+	urls []string,
+	filter Filter,
+	opts ...SubscriptionOption,
+) chan RelayEvent {
+	eoseChan := make(chan struct{})
+	events := pool.subMany(ctx, urls, Filters{filter}, eoseChan, opts...)
+
+	out := make(chan RelayEvent)
+	pool.Go(func() {
+		defer close(out)
+		for {
+			var ie RelayEvent
+			select {
+			case evt, more := <-events:
+				if !more {
+					return
+				}
+				ie = evt
+			case <-eoseChan:
+				eoseChan = nil // so we only do this once
+
+				// stored events may still be sitting in the buffer, they must go out before the sentinel
+				for range len(events) {
+					evt, more := <-events
+					if !more {
+						break
+					}
+					select {
+					case out <- evt:
+					case <-ctx.Done():
+						return
+					case <-pool.Context.Done():
+						return
+					}
+				}
+				ie = RelayEvent{EOSE: true}
+			}
+
+			select {
+			case out <- ie:
+			case <-ctx.Done():
+				return
+			case <-pool.Context.Done():
+				return
+			}
+		}
+	})
+
+	return out
+}
+
 func (pool *SimplePool) subMany(
 	ctx context.Context,
 	urls []string,
@@ -287,19 +611,23 @@ func (pool *SimplePool) subMany(
 ) chan RelayEvent {
 	ctx, cancel := context.WithCancelCause(ctx)
 	_ = cancel // do this so `go vet` will stop complaining
-	events := make(chan RelayEvent)
+	stop := context.AfterFunc(pool.Context, func() { cancel(context.Cause(pool.Context)) })
+	events := pool.makeEventsChan()
 	seenAlready := xsync.NewMapOf[string, Timestamp]()
 	ticker := time.NewTicker(seenAlreadyDropTick)
 
 	eoseWg := sync.WaitGroup{}
 	eoseWg.Add(len(urls))
 	if eoseChan != nil {
-		go func() {
+		pool.Go(func() {
 			eoseWg.Wait()
 			close(eoseChan)
-		}()
+		})
 	}
 
+	fallback := getUnsupportedFallback(opts)
+	counters := pool.labelCounters(opts)
+
 	pending := xsync.NewCounter()
 	pending.Add(int64(len(urls)))
 	for i, url := range urls {
@@ -314,11 +642,13 @@ func (pool *SimplePool) subMany(
 		eosed := atomic.Bool{}
 		firstConnection := true
 
-		go func(nm string) {
+		pool.Go(func() {
+			nm := url
 			defer func() {
 				pending.Dec()
 				if pending.Value() == 0 {
 					close(events)
+					stop()
 					cancel(fmt.Errorf("aborted: %w", context.Cause(ctx)))
 				}
 				if eosed.CompareAndSwap(false, true) {
@@ -328,6 +658,7 @@ func (pool *SimplePool) subMany(
 
 			hasAuthed := false
 			interval := 3 * time.Second
+			filters := filters
 			for {
 				select {
 				case <-ctx.Done():
@@ -351,6 +682,8 @@ func (pool *SimplePool) subMany(
 
 				relay, err := pool.EnsureRelay(nm)
 				if err != nil {
+					counters.errors.Add(1)
+
 					// if we never connected to this just fail
 					if firstConnection {
 						return
@@ -372,18 +705,11 @@ func (pool *SimplePool) subMany(
 					return exists
 				}))...)
 				if err != nil {
+					counters.errors.Add(1)
 					debugLogf("%s reconnecting because subscription died\n", nm)
 					goto reconnect
 				}
-
-				go func() {
-					<-sub.EndOfStoredEvents
-
-					// guard here otherwise a resubscription will trigger a duplicate call to eoseWg.Done()
-					if eosed.CompareAndSwap(false, true) {
-						eoseWg.Done()
-					}
-				}()
+				counters.subscriptions.Add(1)
 
 				// reset interval when we get a good subscription
 				interval = 3 * time.Second
@@ -403,18 +729,25 @@ func (pool *SimplePool) subMany(
 							goto reconnect
 						}
 
-						ie := RelayEvent{Event: evt, Relay: relay}
+						counters.events.Add(1)
+						ie := RelayEvent{Event: evt, Relay: relay, ReceivedAt: time.Now(), RawEnvelope: sub.RawEnvelope(evt)}
 						if mh := pool.eventMiddleware; mh != nil {
 							mh(ie)
 						}
 
 						seenAlready.Store(evt.ID, evt.CreatedAt)
 
-						select {
-						case events <- ie:
-						case <-ctx.Done():
+						if !pool.deliver(ctx, events, ie) {
 							return
 						}
+					case <-sub.EndOfStoredEvents:
+						// handled in this same loop so the stored events above are always handed over before it
+						counters.eoses.Add(1)
+
+						// guard here otherwise a resubscription will trigger a duplicate call to eoseWg.Done()
+						if eosed.CompareAndSwap(false, true) {
+							eoseWg.Done()
+						}
 					case <-ticker.C:
 						if eosed.Load() {
 							old := Timestamp(time.Now().Add(-seenAlreadyDropTick).Unix())
@@ -425,6 +758,7 @@ func (pool *SimplePool) subMany(
 							}
 						}
 					case reason := <-sub.ClosedReason:
+						counters.errors.Add(1)
 						if strings.HasPrefix(reason, "auth-required:") && pool.authHandler != nil && !hasAuthed {
 							// relay is requesting auth. if we can we will perform auth and try again
 							err := relay.Auth(ctx, func(event *Event) error {
@@ -438,6 +772,17 @@ func (pool *SimplePool) subMany(
 							debugLogf("CLOSED from %s: '%s'\n", nm, reason)
 						}
 
+						if next, nextFilters, ok := fallback.nextRelay(nm, filters, reason); ok {
+							// try the same thing on another relay
+							if nextRelay, err := pool.EnsureRelay(next); err == nil {
+								nm = next
+								relay = nextRelay
+								filters = nextFilters
+								hasAuthed = false
+								goto subscribe
+							}
+						}
+
 						return
 					case <-ctx.Done():
 						return
@@ -447,10 +792,14 @@ func (pool *SimplePool) subMany(
 			reconnect:
 				// we will go back to the beginning of the loop and try to connect again and again
 				// until the context is canceled
-				time.Sleep(interval)
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return
+				}
 				interval = interval * 17 / 10 // the next time we try we will wait longer
 			}
-		}(url)
+		})
 	}
 
 	return events
@@ -482,24 +831,36 @@ func (pool *SimplePool) subManyEoseNonOverwriteCheckDuplicate(
 	opts ...SubscriptionOption,
 ) chan RelayEvent {
 	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(pool.Context, func() { cancel(context.Cause(pool.Context)) })
 
-	events := make(chan RelayEvent)
+	events := pool.makeEventsChan()
 	wg := sync.WaitGroup{}
 	wg.Add(len(urls))
 
 	opts = append(opts, wcd)
+	fallback := getUnsupportedFallback(opts)
+	counters := pool.labelCounters(opts)
+	var onEOSE WithEOSEHandler
+	for _, opt := range opts {
+		if h, ok := opt.(WithEOSEHandler); ok {
+			onEOSE = h
+		}
+	}
 
-	go func() {
+	pool.Go(func() {
 		// this will happen when all subscriptions get an eose (or when they die)
 		wg.Wait()
+		stop()
 		cancel(errors.New("all subscriptions ended"))
 		close(events)
-	}()
+	})
 
 	for _, url := range urls {
-		go func(nm string) {
+		pool.Go(func() {
+			nm := NormalizeURL(url)
 			defer wg.Done()
 
+			filters := filters
 			if mh := pool.queryMiddleware; mh != nil {
 				for _, filter := range filters {
 					if filter.Kinds != nil && filter.Authors != nil {
@@ -514,6 +875,7 @@ func (pool *SimplePool) subManyEoseNonOverwriteCheckDuplicate(
 
 			relay, err := pool.EnsureRelay(nm)
 			if err != nil {
+				counters.errors.Add(1)
 				debugLogf("error connecting to %s with %v: %s", nm, filters, err)
 				return
 			}
@@ -523,17 +885,24 @@ func (pool *SimplePool) subManyEoseNonOverwriteCheckDuplicate(
 		subscribe:
 			sub, err := relay.Subscribe(ctx, filters, opts...)
 			if err != nil {
+				counters.errors.Add(1)
 				debugLogf("error subscribing to %s with %v: %s", relay, filters, err)
 				return
 			}
+			counters.subscriptions.Add(1)
 
 			for {
 				select {
 				case <-ctx.Done():
 					return
 				case <-sub.EndOfStoredEvents:
+					counters.eoses.Add(1)
+					if onEOSE != nil {
+						onEOSE(nm)
+					}
 					return
 				case reason := <-sub.ClosedReason:
+					counters.errors.Add(1)
 					if strings.HasPrefix(reason, "auth-required:") && pool.authHandler != nil && !hasAuthed {
 						// relay is requesting auth. if we can we will perform auth and try again
 						err := relay.Auth(ctx, func(event *Event) error {
@@ -545,32 +914,84 @@ func (pool *SimplePool) subManyEoseNonOverwriteCheckDuplicate(
 						}
 					}
 					debugLogf("CLOSED from %s: '%s'\n", nm, reason)
+
+					if next, nextFilters, ok := fallback.nextRelay(nm, filters, reason); ok {
+						// try the same thing on another relay
+						if nextRelay, err := pool.EnsureRelay(next); err == nil {
+							nm = next
+							relay = nextRelay
+							filters = nextFilters
+							hasAuthed = false
+							goto subscribe
+						}
+					}
+					if onEOSE != nil {
+						onEOSE(nm)
+					}
 					return
 				case evt, more := <-sub.Events:
 					if !more {
 						return
 					}
 
-					ie := RelayEvent{Event: evt, Relay: relay}
+					counters.events.Add(1)
+					ie := RelayEvent{Event: evt, Relay: relay, ReceivedAt: time.Now(), RawEnvelope: sub.RawEnvelope(evt)}
 					if mh := pool.eventMiddleware; mh != nil {
 						mh(ie)
 					}
 
 					seenAlready.Store(evt.ID, true)
 
-					select {
-					case events <- ie:
-					case <-ctx.Done():
+					if !pool.deliver(ctx, events, ie) {
 						return
 					}
 				}
 			}
-		}(NormalizeURL(url))
+		})
 	}
 
 	return events
 }
 
+type unsupportedFallback struct {
+	WithUnsupportedFallback
+	next atomic.Int32
+}
+
+func getUnsupportedFallback(opts []SubscriptionOption) *unsupportedFallback {
+	for _, opt := range opts {
+		if uf, ok := opt.(WithUnsupportedFallback); ok {
+			return &unsupportedFallback{WithUnsupportedFallback: uf}
+		}
+	}
+	return nil
+}
+
+// nextRelay returns the next fallback relay and the filters to be used on it if the subscription to current
+// was closed for being unsupported. fallback relays are shared by all subscriptions in the same call.
+func (uf *unsupportedFallback) nextRelay(current string, filters Filters, reason string) (string, Filters, bool) {
+	if uf == nil || !strings.HasPrefix(reason, "unsupported:") {
+		return "", nil, false
+	}
+
+	for {
+		idx := int(uf.next.Add(1)) - 1
+		if idx >= len(uf.Relays) {
+			return "", nil, false
+		}
+
+		url := NormalizeURL(uf.Relays[idx])
+		if url == current {
+			continue
+		}
+
+		if uf.Rewrite != nil {
+			filters = uf.Rewrite(filters, reason)
+		}
+		return url, filters, true
+	}
+}
+
 // CountMany aggregates count results from multiple relays using NIP-45 HyperLogLog
 func (pool *SimplePool) CountMany(
 	ctx context.Context,
@@ -620,6 +1041,26 @@ func (pool *SimplePool) QuerySingle(
 	return nil
 }
 
+// SubManyByRelay queries multiple relays until each of them sends an EOSE and returns the events grouped by
+// the (normalized) URL of the relay that sent them, in the order they were received. Unlike SubManyEose an
+// event returned by many relays is kept under each of them, so what different relays have can be compared.
+//
+// Relays that couldn't be reached or that had nothing matching are absent from the result.
+func (pool *SimplePool) SubManyByRelay(
+	ctx context.Context,
+	urls []string,
+	filters Filters,
+	opts ...SubscriptionOption,
+) map[string][]*Event {
+	results := make(map[string][]*Event, len(urls))
+	for ie := range pool.subManyEoseNonOverwriteCheckDuplicate(ctx, urls, filters,
+		WithCheckDuplicate(func(id, relay string) bool { return false }),
+		xsync.NewMapOf[string, bool](), opts...) {
+		results[ie.Relay.URL] = append(results[ie.Relay.URL], ie.Event)
+	}
+	return results
+}
+
 // BatchedSubManyEose performs batched subscriptions to multiple relays with different filters.
 func (pool *SimplePool) BatchedSubManyEose(
 	ctx context.Context,
@@ -632,7 +1073,7 @@ func (pool *SimplePool) BatchedSubManyEose(
 	seenAlready := xsync.NewMapOf[string, bool]()
 
 	for _, df := range dfs {
-		go func(df DirectedFilter) {
+		pool.Go(func() {
 			for ie := range pool.subManyEoseNonOverwriteCheckDuplicate(ctx,
 				[]string{df.Relay},
 				Filters{df.Filter},
@@ -643,17 +1084,21 @@ func (pool *SimplePool) BatchedSubManyEose(
 					}
 					return exists
 				}), seenAlready, opts...) {
-				res <- ie
+				select {
+				case res <- ie:
+				case <-ctx.Done():
+				case <-pool.Context.Done():
+				}
 			}
 
 			wg.Done()
-		}(df)
+		})
 	}
 
-	go func() {
+	pool.Go(func() {
 		wg.Wait()
 		close(res)
-	}()
+	})
 
 	return res
 }
@@ -662,3 +1107,39 @@ func (pool *SimplePool) BatchedSubManyEose(
 func (pool *SimplePool) Close(reason string) {
 	pool.cancel(fmt.Errorf("pool closed with reason: '%s'", reason))
 }
+
+// Shutdown closes the pool for good: it cancels pool.Context, which ends all subscriptions started from the
+// pool, sends a CLOSE for every subscription still open on its relays and disconnects from all of them, then
+// waits for the goroutines started by the pool, by its relays and their subscriptions (and given to Go) to finish.
+//
+// If ctx ends before that an error is returned.
+func (pool *SimplePool) Shutdown(ctx context.Context) error {
+	pool.goroutinesMu.Lock()
+	pool.shutdown = true
+	pool.goroutinesMu.Unlock()
+
+	pool.cancel(errors.New("pool shut down"))
+
+	for _, relay := range pool.Relays.Range {
+		if relay == nil {
+			continue
+		}
+		for _, sub := range relay.Subscriptions.Range {
+			sub.Unsub()
+		}
+		relay.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		pool.goroutines.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("pool goroutines didn't finish: %w", context.Cause(ctx))
+	}
+}
@@ -0,0 +1,457 @@
+//go:build !js
+
+package nostr
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestFetchManyUnsupportedFallback(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	note := Event{Kind: KindTextNote, Content: "hello", CreatedAt: Now()}
+	require.NoError(t, note.Sign(priv))
+
+	// serves stored events for filters without "search", closes everything else
+	relayHandler := func(stored ...Event) func(conn *websocket.Conn, subid string, filters Filters) {
+		return func(conn *websocket.Conn, subid string, filters Filters) {
+			for _, filter := range filters {
+				if filter.Search != "" {
+					websocket.JSON.Send(conn, []any{"CLOSED", subid, "unsupported: search is not supported"})
+					return
+				}
+			}
+			sendStored(stored...)(conn, subid, filters)
+		}
+	}
+
+	primary := newRelayServer(relayHandler())
+	defer primary.Close()
+	fallback := newRelayServer(relayHandler(note))
+	defer fallback.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx)
+	results := make([]RelayEvent, 0, 1)
+	for ie := range pool.FetchMany(ctx, []string{primary.URL},
+		Filter{Kinds: []int{KindTextNote}, Search: "hello"},
+		WithUnsupportedFallback{
+			Relays: []string{fallback.URL},
+			Rewrite: func(filters Filters, reason string) Filters {
+				degraded := make(Filters, len(filters))
+				for i, filter := range filters {
+					degraded[i] = filter.Clone()
+					degraded[i].Search = ""
+				}
+				return degraded
+			},
+		},
+	) {
+		results = append(results, ie)
+	}
+
+	require.Len(t, results, 1)
+	require.Equal(t, note.ID, results[0].ID)
+	require.Equal(t, NormalizeURL(fallback.URL), results[0].Relay.URL)
+}
+
+func TestRelayEventReceivedAt(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	stored := make([]Event, 3)
+	for i := range stored {
+		stored[i] = Event{Kind: KindTextNote, Content: "hello", CreatedAt: Now() - Timestamp(i)}
+		require.NoError(t, stored[i].Sign(priv))
+	}
+
+	ws := newRelayServer(func(conn *websocket.Conn, subid string, _ Filters) {
+		for _, evt := range stored {
+			websocket.JSON.Send(conn, []any{"EVENT", subid, evt})
+			time.Sleep(10 * time.Millisecond)
+		}
+		websocket.JSON.Send(conn, []any{"EOSE", subid})
+	})
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx)
+	start := time.Now()
+	var last time.Time
+	count := 0
+	for ie := range pool.FetchMany(ctx, []string{ws.URL}, Filter{Kinds: []int{KindTextNote}}) {
+		require.False(t, ie.ReceivedAt.IsZero())
+		require.False(t, ie.ReceivedAt.Before(start))
+		require.False(t, ie.ReceivedAt.After(time.Now()))
+		require.False(t, ie.ReceivedAt.Before(last), "events received later must have a later ReceivedAt")
+		last = ie.ReceivedAt
+		count++
+	}
+	require.Equal(t, 3, count)
+}
+
+func TestConnectedRelays(t *testing.T) {
+	ws1 := newRelayServer(nil)
+	defer ws1.Close()
+	ws2 := newRelayServer(nil)
+	defer ws2.Close()
+
+	pool := NewSimplePool(context.Background())
+	require.Empty(t, pool.ConnectedRelays())
+
+	r1, err := pool.EnsureRelay(ws1.URL)
+	require.NoError(t, err)
+	_, err = pool.EnsureRelay(ws2.URL)
+	require.NoError(t, err)
+
+	expected := []string{NormalizeURL(ws1.URL), NormalizeURL(ws2.URL)}
+	slices.Sort(expected)
+	require.Equal(t, expected, pool.ConnectedRelays())
+
+	r1.Close()
+	require.Equal(t, []string{NormalizeURL(ws2.URL)}, pool.ConnectedRelays())
+}
+
+func TestSubscribeManyWithEOSE(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	newNote := func(content string) Event {
+		evt := Event{Kind: KindTextNote, Content: content, CreatedAt: Now()}
+		require.NoError(t, evt.Sign(priv))
+		return evt
+	}
+
+	// sends the stored events, then an EOSE, then a live event a little later
+	relayHandler := func(live Event, stored ...Event) func(conn *websocket.Conn, subid string, filters Filters) {
+		return func(conn *websocket.Conn, subid string, filters Filters) {
+			sendStored(stored...)(conn, subid, filters)
+			time.Sleep(200 * time.Millisecond)
+			websocket.JSON.Send(conn, []any{"EVENT", subid, live})
+		}
+	}
+
+	ws1 := newRelayServer(relayHandler(newNote("live 1"), newNote("a"), newNote("b")))
+	defer ws1.Close()
+	ws2 := newRelayServer(relayHandler(newNote("live 2"), newNote("c")))
+	defer ws2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx)
+	eoses := 0
+	stored := 0
+	live := 0
+	for ie := range pool.SubscribeManyWithEOSE(ctx, []string{ws1.URL, ws2.URL}, Filter{Kinds: []int{KindTextNote}}) {
+		if ie.EOSE {
+			require.Nil(t, ie.Event)
+			eoses++
+			continue
+		}
+
+		if strings.HasPrefix(ie.Content, "live") {
+			require.Equal(t, 1, eoses, "live events must come after the EOSE sentinel")
+			live++
+			if live == 2 {
+				cancel()
+			}
+		} else {
+			stored++
+		}
+	}
+
+	require.Equal(t, 1, eoses)
+	require.Equal(t, 3, stored)
+	require.Equal(t, 2, live)
+}
+
+func TestSubscribeManyWithEOSEOrdering(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+
+	urls := make([]string, 0, 3)
+	for r := range 3 {
+		stored := make([]Event, 50)
+		for i := range stored {
+			stored[i] = Event{Kind: KindTextNote, Content: strconv.Itoa(r) + "-" + strconv.Itoa(i), CreatedAt: Now()}
+			require.NoError(t, stored[i].Sign(priv))
+		}
+		ws := newRelayServer(sendStored(stored...))
+		defer ws.Close()
+		urls = append(urls, ws.URL)
+	}
+
+	for _, policy := range []DropPolicy{DropPolicyBlock, DropPolicyDropNewest} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pool := NewSimplePool(ctx, WithDropPolicy(policy))
+
+		stored := 0
+		for ie := range pool.SubscribeManyWithEOSE(ctx, urls, Filter{Kinds: []int{KindTextNote}}) {
+			if ie.EOSE {
+				cancel()
+				break
+			}
+			stored++
+		}
+		cancel()
+
+		require.Equal(t, 150, stored, "all stored events must come before the EOSE sentinel (policy %d)", policy)
+	}
+}
+
+func TestPing(t *testing.T) {
+	alive := newRelayServer(nil)
+	defer alive.Close()
+
+	// accepts the connection but never reads from it, so pings are never answered
+	stuck := make(chan struct{})
+	unresponsive := newWebsocketServer(func(conn *websocket.Conn) { <-stuck })
+	defer unresponsive.Close()
+	defer close(stuck)
+
+	dead := newWebsocketServer(func(conn *websocket.Conn) {})
+	deadURL := dead.URL
+	dead.Close()
+
+	pool := NewSimplePool(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results := pool.Ping(ctx, []string{alive.URL, unresponsive.URL, deadURL, alive.URL + "/"})
+	require.Len(t, results, 3)
+
+	res := results[NormalizeURL(alive.URL)]
+	require.NoError(t, res.Err)
+	require.True(t, res.Connected)
+	require.Greater(t, res.RTT, time.Duration(0))
+
+	res = results[NormalizeURL(unresponsive.URL)]
+	require.Error(t, res.Err)
+	require.Zero(t, res.RTT)
+
+	res = results[NormalizeURL(deadURL)]
+	require.Error(t, res.Err)
+	require.False(t, res.Connected)
+
+	// the connection to the alive relay is reused
+	relay, _ := pool.Relays.Load(NormalizeURL(alive.URL))
+	results = pool.Ping(ctx, []string{alive.URL})
+	require.True(t, results[NormalizeURL(alive.URL)].Connected)
+	again, _ := pool.Relays.Load(NormalizeURL(alive.URL))
+	require.Same(t, relay, again)
+}
+
+func TestDropPolicy(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	total := dropPolicyBufferSize * 2
+	stored := make([]Event, total)
+	for i := range stored {
+		stored[i] = Event{Kind: KindTextNote, Content: strconv.Itoa(i), CreatedAt: Timestamp(1000 + i)}
+		require.NoError(t, stored[i].Sign(priv))
+	}
+
+	// a firehose relay that dumps everything at once on every REQ
+	relay := newRelayServer(sendStored(stored...))
+	defer relay.Close()
+
+	for _, policy := range []DropPolicy{DropPolicyDropNewest, DropPolicyDropOldest} {
+		t.Run(strconv.Itoa(int(policy)), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+			defer cancel()
+
+			pool := NewSimplePool(ctx, WithDropPolicy(policy))
+			events := pool.FetchMany(ctx, []string{relay.URL}, Filter{Kinds: []int{KindTextNote}}, WithLabel("firehose"))
+
+			// a slow consumer that only starts reading after the EOSE, which the pool only handles after
+			// every stored event has been delivered (or dropped), so by then the count is final
+			for pool.Metrics()["firehose"].EOSEs == 0 {
+				select {
+				case <-ctx.Done():
+					t.Fatal("EOSE never came")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			require.Equal(t, int64(total-dropPolicyBufferSize), pool.DroppedCount())
+
+			received := make([]string, 0, total)
+			for ie := range events {
+				received = append(received, ie.ID)
+			}
+			require.Len(t, received, dropPolicyBufferSize)
+			require.Equal(t, int64(total-dropPolicyBufferSize), pool.DroppedCount())
+		})
+	}
+}
+
+func TestPoolShutdown(t *testing.T) {
+	closes := make(chan string, 10)
+	handler := func(conn *websocket.Conn) {
+		for {
+			var raw []stdjson.RawMessage
+			if err := websocket.JSON.Receive(conn, &raw); err != nil {
+				return
+			}
+			var typ, subid string
+			json.Unmarshal(raw[0], &typ)
+			json.Unmarshal(raw[1], &subid)
+			switch typ {
+			case "REQ":
+				websocket.JSON.Send(conn, []any{"EOSE", subid})
+			case "CLOSE":
+				closes <- subid
+			}
+		}
+	}
+	ws1 := newWebsocketServer(handler)
+	defer ws1.Close()
+	ws2 := newWebsocketServer(handler)
+	defer ws2.Close()
+
+	// other tests may have left relays behind
+	before := poolGoroutines(nil)
+
+	pool := NewSimplePool(context.Background())
+
+	// a live subscription that would never end by itself
+	events := pool.SubscribeManyWithEOSE(context.Background(), []string{ws1.URL, ws2.URL}, Filter{Kinds: []int{1}})
+	eose := <-events
+	require.True(t, eose.EOSE)
+
+	// the profile fetch in sdk is an example of something that is tied to the pool with Go
+	finished := atomic.Bool{}
+	pool.Go(func() {
+		<-pool.Context.Done()
+		finished.Store(true)
+	})
+
+	// and a batched query whose results nobody reads
+	pool.BatchedSubManyEose(context.Background(), []DirectedFilter{{Filter: Filter{Kinds: []int{1}}, Relay: ws1.URL}})
+	require.NotEmpty(t, poolGoroutines(before))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	require.NoError(t, pool.Shutdown(ctx))
+	cancel()
+	require.True(t, finished.Load())
+	require.Empty(t, poolGoroutines(before))
+
+	// the subscription is over and the relays were told so
+	for range events {
+	}
+	require.Len(t, pool.ConnectedRelays(), 0)
+	received := []string{<-closes, <-closes}
+	require.Len(t, received, 2)
+
+	// subscriptions started after the shutdown end right away
+	for range pool.SubscribeMany(context.Background(), []string{ws1.URL}, Filter{Kinds: []int{1}}) {
+	}
+}
+
+// poolGoroutines returns the stacks of the goroutines that are running code from pools, relays or
+// subscriptions, keyed by goroutine id, leaving out the ones that were in ignore already.
+func poolGoroutines(ignore map[string]string) map[string]string {
+	buf := make([]byte, 1<<20)
+	buf = buf[0:runtime.Stack(buf, true)]
+
+	found := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if !strings.Contains(stack, "go-nostr.(*SimplePool)") &&
+			!strings.Contains(stack, "go-nostr.(*Relay)") &&
+			!strings.Contains(stack, "go-nostr.(*Subscription)") {
+			continue
+		}
+		id := strings.Fields(stack)[1]
+		if _, ok := ignore[id]; !ok {
+			found[id] = stack
+		}
+	}
+	return found
+}
+
+func TestPoolMetrics(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	stored := make([]Event, 3)
+	for i := range stored {
+		stored[i] = Event{Kind: KindTextNote, Content: strconv.Itoa(i), CreatedAt: Timestamp(1000 + i)}
+		require.NoError(t, stored[i].Sign(priv))
+	}
+
+	// answers REQs with all the events it has, unless the filter asks for kind 7, which is refused
+	relay := newRelayServer(func(conn *websocket.Conn, subid string, filters Filters) {
+		if slices.Contains(filters[0].Kinds, 7) {
+			websocket.JSON.Send(conn, []any{"CLOSED", subid, "blocked: no reactions"})
+			return
+		}
+		sendStored(stored...)(conn, subid, filters)
+	})
+	defer relay.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pool := NewSimplePool(ctx)
+
+	for range 2 {
+		for range pool.FetchMany(ctx, []string{relay.URL}, Filter{Kinds: []int{KindTextNote}}, WithLabel("notes")) {
+		}
+	}
+	for range pool.FetchMany(ctx, []string{relay.URL}, Filter{Kinds: []int{7}}, WithLabel("reactions")) {
+	}
+	for range pool.FetchMany(ctx, []string{relay.URL}, Filter{Kinds: []int{KindTextNote}}) {
+	}
+	for range pool.FetchMany(ctx, []string{"ws://localhost:1"}, Filter{Kinds: []int{KindTextNote}}, WithLabel("notes")) {
+	}
+
+	metrics := pool.Metrics()
+	require.Equal(t, LabelMetrics{Subscriptions: 2, Events: 6, EOSEs: 2, Errors: 1}, metrics["notes"])
+	require.Equal(t, LabelMetrics{Subscriptions: 1, Errors: 1}, metrics["reactions"])
+	require.Equal(t, LabelMetrics{Subscriptions: 1, Events: 3, EOSEs: 1}, metrics[""])
+}
+
+func TestSubManyByRelay(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	newNote := func(content string) Event {
+		evt := Event{Kind: KindTextNote, Content: content, CreatedAt: Now()}
+		require.NoError(t, evt.Sign(priv))
+		return evt
+	}
+
+	a, b, c, d := newNote("a"), newNote("b"), newNote("c"), newNote("d")
+	ws1 := newRelayServer(sendStored(a, b))
+	defer ws1.Close()
+	ws2 := newRelayServer(sendStored(b, c))
+	defer ws2.Close()
+	ws3 := newRelayServer(sendStored(d))
+	defer ws3.Close()
+	empty := newRelayServer(sendStored())
+	defer empty.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx)
+	results := pool.SubManyByRelay(ctx, []string{ws1.URL, ws2.URL, ws3.URL, empty.URL}, Filters{{Kinds: []int{KindTextNote}}})
+
+	ids := func(url string) []string {
+		evts := results[NormalizeURL(url)]
+		ids := make([]string, len(evts))
+		for i, evt := range evts {
+			ids[i] = evt.ID
+		}
+		return ids
+	}
+
+	require.Len(t, results, 3)
+	require.Equal(t, []string{a.ID, b.ID}, ids(ws1.URL))
+	require.Equal(t, []string{b.ID, c.ID}, ids(ws2.URL), "events also returned by other relays are kept")
+	require.Equal(t, []string{d.ID}, ids(ws3.URL))
+	require.NotContains(t, results, NormalizeURL(empty.URL))
+}
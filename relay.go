@@ -36,10 +36,14 @@ type Relay struct {
 	challenge                     string       // NIP-42 challenge, we only keep the last
 	noticeHandler                 func(string) // NIP-01 NOTICEs
 	customHandler                 func([]byte) // nonstandard unparseable messages
-	okCallbacks                   *xsync.MapOf[string, func(bool, string)]
+	okCallbacks                   *xsync.MapOf[string, func(*OKEnvelope)]
 	writeQueue                    chan writeRequest
 	subscriptionChannelCloseQueue chan *Subscription
 
+	// spawner starts the goroutines of this relay, it is set to SimplePool.Go by the pool that owns the relay
+	// so they are accounted for when it is shut down
+	spawner func(func())
+
 	// custom things that aren't often used
 	//
 	AssumeValid bool // this will skip verifying signatures for events received from this relay
@@ -58,7 +62,7 @@ func NewRelay(ctx context.Context, url string, opts ...RelayOption) *Relay {
 		connectionContext:             ctx,
 		connectionContextCancel:       cancel,
 		Subscriptions:                 xsync.NewMapOf[int64, *Subscription](),
-		okCallbacks:                   xsync.NewMapOf[string, func(bool, string)](),
+		okCallbacks:                   xsync.NewMapOf[string, func(*OKEnvelope)](),
 		writeQueue:                    make(chan writeRequest),
 		subscriptionChannelCloseQueue: make(chan *Subscription),
 		requestHeader:                 nil,
@@ -117,6 +121,15 @@ func (ch WithRequestHeader) ApplyRelayOption(r *Relay) {
 	r.requestHeader = http.Header(ch)
 }
 
+// spawn runs f in a new goroutine.
+func (r *Relay) spawn(f func()) {
+	if r.spawner != nil {
+		r.spawner(f)
+		return
+	}
+	go f()
+}
+
 // String just returns the relay URL.
 func (r *Relay) String() string {
 	return r.URL
@@ -129,6 +142,13 @@ func (r *Relay) Context() context.Context { return r.connectionContext }
 // IsConnected returns true if the connection to this relay seems to be active.
 func (r *Relay) IsConnected() bool { return r.connectionContext.Err() == nil }
 
+// connection returns r.Connection, which is set to nil from another goroutine when the connection closes.
+func (r *Relay) connection() *Connection {
+	r.closeMutex.Lock()
+	defer r.closeMutex.Unlock()
+	return r.Connection
+}
+
 // Connect tries to establish a websocket connection to r.URL.
 // If the context expires before the connection is complete, an error is returned.
 // Once successfully connected, context expiration has no effect: call r.Close
@@ -167,28 +187,30 @@ func (r *Relay) ConnectWithTLS(ctx context.Context, tlsConfig *tls.Config) error
 	ticker := time.NewTicker(29 * time.Second)
 
 	// to be used when the connection is closed
-	go func() {
+	r.spawn(func() {
 		<-r.connectionContext.Done()
 
 		// stop the ticker
 		ticker.Stop()
 
 		// nil the connection
+		r.closeMutex.Lock()
 		r.Connection = nil
+		r.closeMutex.Unlock()
 
 		// close all subscriptions
 		for _, sub := range r.Subscriptions.Range {
 			sub.unsub(fmt.Errorf("relay connection closed: %w / %w", context.Cause(r.connectionContext), r.ConnectionError))
 		}
-	}()
+	})
 
 	// queue all write operations here so we don't do mutex spaghetti
-	go func() {
+	r.spawn(func() {
 		for {
 			select {
 			case <-ticker.C:
-				if r.Connection != nil {
-					err := r.Connection.Ping(r.connectionContext)
+				if conn != nil {
+					err := conn.Ping(r.connectionContext)
 					if err != nil && !strings.Contains(err.Error(), "failed to wait for pong") {
 						InfoLogger.Printf("{%s} error writing ping: %v; closing websocket", r.URL, err)
 						r.Close() // this should trigger a context cancelation
@@ -198,7 +220,7 @@ func (r *Relay) ConnectWithTLS(ctx context.Context, tlsConfig *tls.Config) error
 			case writeRequest := <-r.writeQueue:
 				// all write requests will go through this to prevent races
 				debugLogf("{%s} sending %v\n", r.URL, string(writeRequest.msg))
-				if err := r.Connection.WriteMessage(r.connectionContext, writeRequest.msg); err != nil {
+				if err := conn.WriteMessage(r.connectionContext, writeRequest.msg); err != nil {
 					writeRequest.answer <- err
 				}
 				close(writeRequest.answer)
@@ -207,10 +229,10 @@ func (r *Relay) ConnectWithTLS(ctx context.Context, tlsConfig *tls.Config) error
 				return
 			}
 		}
-	}()
+	})
 
 	// general message reader loop
-	go func() {
+	r.spawn(func() {
 		buf := new(bytes.Buffer)
 
 		for {
@@ -276,6 +298,11 @@ func (r *Relay) ConnectWithTLS(ctx context.Context, tlsConfig *tls.Config) error
 						}
 					}
 
+					// the read buffer is reused, so this must be a copy
+					if subscription.rawEnvelopes != nil {
+						subscription.rawEnvelopes.Store(&env.Event, bytes.Clone(message))
+					}
+
 					// dispatch this to the internal .events channel of the subscription
 					subscription.dispatchEvent(&env.Event)
 				}
@@ -292,14 +319,15 @@ func (r *Relay) ConnectWithTLS(ctx context.Context, tlsConfig *tls.Config) error
 					subscription.countResult <- *env
 				}
 			case *OKEnvelope:
-				if okCallback, exist := r.okCallbacks.Load(env.EventID); exist {
-					okCallback(env.OK, env.Reason)
+				// ids are stored in lowercase, but some relays send them back in uppercase
+				if okCallback, exist := r.okCallbacks.Load(strings.ToLower(env.EventID)); exist {
+					okCallback(env)
 				} else {
 					InfoLogger.Printf("{%s} got an unexpected OK message for event %s", r.URL, env.EventID)
 				}
 			}
 		}
-	}()
+	})
 
 	return nil
 }
@@ -317,7 +345,9 @@ func (r *Relay) Write(msg []byte) <-chan error {
 
 // Publish sends an "EVENT" command to the relay r as in NIP-01 and waits for an OK response.
 func (r *Relay) Publish(ctx context.Context, event Event) error {
-	return r.publish(ctx, event.ID, &EventEnvelope{Event: event})
+	return r.publish(ctx, event.ID, &EventEnvelope{Event: event}, func(oke *OKEnvelope) (bool, bool) {
+		return oke.OK, true
+	})
 }
 
 // Auth sends an "AUTH" command client->relay as in NIP-42 and waits for an OK response.
@@ -338,10 +368,14 @@ func (r *Relay) Auth(ctx context.Context, sign func(event *Event) error) error {
 		return fmt.Errorf("error signing auth event: %w", err)
 	}
 
-	return r.publish(ctx, authEvent.ID, &AuthEnvelope{Event: authEvent})
+	return r.publish(ctx, authEvent.ID, &AuthEnvelope{Event: authEvent}, func(oke *OKEnvelope) (bool, bool) {
+		return IsAuthOK(oke, authEvent.ID)
+	})
 }
 
-func (r *Relay) publish(ctx context.Context, id string, env Envelope) error {
+// publish sends env and waits for the OK with the given id, which check turns into a result (ok), or
+// ignores if it isn't really about what was sent (matched).
+func (r *Relay) publish(ctx context.Context, id string, env Envelope, check func(*OKEnvelope) (ok bool, matched bool)) error {
 	var err error
 	var cancel context.CancelFunc
 
@@ -357,10 +391,15 @@ func (r *Relay) publish(ctx context.Context, id string, env Envelope) error {
 
 	// listen for an OK callback
 	gotOk := false
-	r.okCallbacks.Store(id, func(ok bool, reason string) {
+	id = strings.ToLower(id)
+	r.okCallbacks.Store(id, func(oke *OKEnvelope) {
+		ok, matched := check(oke)
+		if !matched {
+			return
+		}
 		gotOk = true
 		if !ok {
-			err = fmt.Errorf("msg: %s", reason)
+			err = fmt.Errorf("msg: %s", oke.Reason)
 		}
 		cancel()
 	})
@@ -396,7 +435,7 @@ func (r *Relay) publish(ctx context.Context, id string, env Envelope) error {
 func (r *Relay) Subscribe(ctx context.Context, filters Filters, opts ...SubscriptionOption) (*Subscription, error) {
 	sub := r.PrepareSubscription(ctx, filters, opts...)
 
-	if r.Connection == nil {
+	if r.connection() == nil {
 		return nil, fmt.Errorf("not connected to %s", r.URL)
 	}
 
@@ -434,6 +473,8 @@ func (r *Relay) PrepareSubscription(ctx context.Context, filters Filters, opts .
 			label = string(o)
 		case WithCheckDuplicate:
 			sub.checkDuplicate = o
+		case WithRawEnvelopes:
+			sub.rawEnvelopes = xsync.NewMapOf[*Event, []byte]()
 		}
 	}
 
@@ -449,7 +490,7 @@ func (r *Relay) PrepareSubscription(ctx context.Context, filters Filters, opts .
 	r.Subscriptions.Store(int64(sub.counter), sub)
 
 	// start handling events, eose, unsub etc:
-	go sub.start()
+	r.spawn(sub.start)
 
 	return sub
 }
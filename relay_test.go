@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -105,6 +106,46 @@ func TestPublishWriteFailed(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestAuth(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+
+	// accepts the first AUTH (echoing its id in uppercase), rejects the others
+	auths := 0
+	ws := newWebsocketServer(func(conn *websocket.Conn) {
+		for {
+			var msg []byte
+			if err := websocket.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			env, ok := ParseMessage(msg).(*AuthEnvelope)
+			if !ok {
+				continue
+			}
+			assert.Equal(t, KindClientAuthentication, env.Event.Kind)
+
+			auths++
+			if auths == 1 {
+				// an OK for something else first, which must be ignored
+				websocket.JSON.Send(conn, []any{"OK", strings.Repeat("0", 64), false, "error: what"})
+				websocket.JSON.Send(conn, []any{"OK", strings.ToUpper(env.Event.ID), true, ""})
+			} else {
+				websocket.JSON.Send(conn, []any{"OK", env.Event.ID, false, "restricted: go away"})
+			}
+		}
+	})
+	defer ws.Close()
+
+	rl := mustRelayConnect(t, ws.URL)
+	sign := func(evt *Event) error { return evt.Sign(priv) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, rl.Auth(ctx, sign))
+	err := rl.Auth(ctx, sign)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "restricted: go away")
+}
+
 func TestConnectContext(t *testing.T) {
 	// fake relay server
 	var mu sync.Mutex // guards connected to satisfy go test -race
@@ -157,6 +198,31 @@ func TestConnectWithOrigin(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRawEnvelopesAreReleased(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	stored := make([]Event, 5)
+	for i := range stored {
+		stored[i] = Event{Kind: KindTextNote, Content: "hello", CreatedAt: Now() - Timestamp(i)}
+		require.NoError(t, stored[i].Sign(priv))
+	}
+	ws := newRelayServer(sendStored(stored...))
+	defer ws.Close()
+
+	rl := mustRelayConnect(t, ws.URL)
+	defer rl.Close()
+
+	sub, err := rl.Subscribe(context.Background(), Filters{{Kinds: []int{KindTextNote}}}, WithRawEnvelopes{})
+	require.NoError(t, err)
+
+	// take the raw envelope of the first event only, leave the second one, never read the others
+	first := <-sub.Events
+	require.Contains(t, string(sub.RawEnvelope(first)), first.ID)
+	<-sub.Events
+	sub.Unsub()
+
+	require.Eventually(t, func() bool { return sub.rawEnvelopes.Size() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
 func discardingHandler(conn *websocket.Conn) {
 	io.ReadAll(conn) // discard all input
 }
@@ -168,6 +234,40 @@ func newWebsocketServer(handler func(*websocket.Conn)) *httptest.Server {
 	})
 }
 
+// newRelayServer starts a relay that reads messages until the connection is closed, calling onReq
+// with the subscription id and filters of every REQ it gets and ignoring everything else.
+func newRelayServer(onReq func(conn *websocket.Conn, subid string, filters Filters)) *httptest.Server {
+	return newWebsocketServer(func(conn *websocket.Conn) {
+		for {
+			var raw []stdjson.RawMessage
+			if err := websocket.JSON.Receive(conn, &raw); err != nil {
+				return
+			}
+			var typ, subid string
+			json.Unmarshal(raw[0], &typ)
+			if typ != "REQ" || len(raw) < 2 || onReq == nil {
+				continue
+			}
+			json.Unmarshal(raw[1], &subid)
+			filters := make(Filters, len(raw)-2)
+			for i, b := range raw[2:] {
+				json.Unmarshal(b, &filters[i])
+			}
+			onReq(conn, subid, filters)
+		}
+	})
+}
+
+// sendStored answers every REQ with all the given events, regardless of the filters, and an EOSE.
+func sendStored(stored ...Event) func(conn *websocket.Conn, subid string, filters Filters) {
+	return func(conn *websocket.Conn, subid string, _ Filters) {
+		for _, evt := range stored {
+			websocket.JSON.Send(conn, []any{"EVENT", subid, evt})
+		}
+		websocket.JSON.Send(conn, []any{"EOSE", subid})
+	}
+}
+
 // anyOriginHandshake is an alternative to default in golang.org/x/net/websocket
 // which checks for origin. nostr client sends no origin and it makes no difference
 // for the tests here anyway.
@@ -0,0 +1,46 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// IsDeleted checks if the event with the given id was retracted by its author, i.e. if there is a kind:5
+// deletion request signed by author that references it in an "e" tag. The local store is checked first and
+// then the author's outbox relays. If one is found it is returned together with true.
+//
+// Deletion requests signed by anyone other than author are ignored, as they don't mean anything.
+func (sys *System) IsDeleted(ctx context.Context, eventID string, author string) (bool, *nostr.Event, error) {
+	if !nostr.IsValid32ByteHex(eventID) {
+		return false, nil, fmt.Errorf("invalid event id '%s'", eventID)
+	}
+	if !nostr.IsValidPublicKey(author) {
+		return false, nil, fmt.Errorf("invalid author '%s'", author)
+	}
+
+	filter := nostr.Filter{
+		Kinds:   []int{nostr.KindDeletion},
+		Authors: []string{author},
+		Tags:    nostr.TagMap{"e": []string{eventID}},
+	}
+
+	if res, _ := sys.StoreRelay.QuerySync(ctx, filter); len(res) != 0 {
+		return true, res[0], nil
+	}
+
+	relays := sys.FetchOutboxRelays(ctx, author, sys.OutboxRelayCount)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errors.New("IsDeleted() ended"))
+	for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("isdeleted")) {
+		sys.StoreRelay.Publish(ctx, *ie.Event)
+		return true, ie.Event, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return false, nil, fmt.Errorf("couldn't check for deletions of %s: %w", eventID, context.Cause(ctx))
+	}
+	return false, nil, nil
+}
@@ -0,0 +1,52 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDeleted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	deleted := nostr.Event{Kind: 1, CreatedAt: nostr.Now() - 20, Content: "oops"}
+	deleted.Sign(sk)
+	kept := nostr.Event{Kind: 1, CreatedAt: nostr.Now() - 10, Content: "fine"}
+	kept.Sign(sk)
+	deletion := nostr.Event{Kind: nostr.KindDeletion, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"e", deleted.ID}}}
+	deletion.Sign(sk)
+
+	// someone else trying to delete the event we want to keep
+	otherSk := nostr.GeneratePrivateKey()
+	fake := nostr.Event{Kind: nostr.KindDeletion, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"e", kept.ID}}}
+	fake.Sign(otherSk)
+
+	// this relay sends everything it has, no matter what was asked
+	relay := nostr.NormalizeURL(startLyingRelay(t, deletion, fake))
+
+	sys := NewSystem(WithRelayListRelays([]string{relay}))
+	defer sys.Close()
+	sys.OutboxFetchTimeout = 300 * time.Millisecond
+	sys.Hints.Save(pk, relay, hints.LastInHint, nostr.Now())
+
+	isDeleted, evt, err := sys.IsDeleted(ctx, deleted.ID, pk)
+	require.NoError(t, err)
+	require.True(t, isDeleted)
+	require.Equal(t, deletion.ID, evt.ID)
+
+	isDeleted, evt, err = sys.IsDeleted(ctx, kept.ID, pk)
+	require.NoError(t, err)
+	require.False(t, isDeleted)
+	require.Nil(t, evt)
+
+	_, _, err = sys.IsDeleted(ctx, "nope", pk)
+	require.Error(t, err)
+}
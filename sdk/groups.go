@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip29"
+)
+
+// FetchGroup assembles the current state of a NIP-29 group by fetching its metadata, admins and members
+// events from the relay that hosts it and merging the newest of each, in that order, into a nip29.Group.
+//
+// The metadata event is required, a group without it is considered not to exist. Missing admins or
+// members events just leave the group without them.
+func (sys *System) FetchGroup(ctx context.Context, relayURL string, groupID string) (*nip29.Group, error) {
+	if !nip29.IsValidGroupID(groupID) {
+		return nil, fmt.Errorf("invalid group id '%s': %w", groupID, nip29.ErrInvalidGroupID)
+	}
+	relay := nostr.NormalizeURL(relayURL)
+
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindSimpleGroupMetadata, nostr.KindSimpleGroupAdmins, nostr.KindSimpleGroupMembers},
+		Tags:  nostr.TagMap{"d": []string{groupID}},
+	}
+
+	// these are addressable, so only the newest of each kind matters
+	newest := make(map[int]*nostr.Event, 3)
+	for ie := range sys.Pool.FetchMany(ctx, []string{relay}, filter, nostr.WithLabel("group")) {
+		if curr, ok := newest[ie.Kind]; !ok || ie.CreatedAt > curr.CreatedAt {
+			newest[ie.Kind] = ie.Event
+		}
+	}
+
+	metadata, ok := newest[nostr.KindSimpleGroupMetadata]
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("couldn't fetch group '%s' from %s: %w", groupID, relay, context.Cause(ctx))
+		}
+		return nil, fmt.Errorf("group '%s' not found on %s", groupID, relay)
+	}
+
+	group := nip29.NewGroupWithID(groupID)
+	group.Address.Relay = relay
+	if err := group.MergeInMetadataEvent(metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata event for group '%s': %w", groupID, err)
+	}
+	if admins, ok := newest[nostr.KindSimpleGroupAdmins]; ok {
+		if err := group.MergeInAdminsEvent(admins); err != nil {
+			return nil, fmt.Errorf("invalid admins event for group '%s': %w", groupID, err)
+		}
+	}
+	if members, ok := newest[nostr.KindSimpleGroupMembers]; ok {
+		if err := group.MergeInMembersEvent(members); err != nil {
+			return nil, fmt.Errorf("invalid members event for group '%s': %w", groupID, err)
+		}
+	}
+
+	return group, nil
+}
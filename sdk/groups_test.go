@@ -0,0 +1,73 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	relaySk := nostr.GeneratePrivateKey()
+	alice, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	bob, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	now := nostr.Now()
+	oldMetadata := nostr.Event{
+		Kind:      nostr.KindSimpleGroupMetadata,
+		CreatedAt: now - 100,
+		Tags:      nostr.Tags{{"d", "abc"}, {"name", "old name"}},
+	}
+	metadata := nostr.Event{
+		Kind:      nostr.KindSimpleGroupMetadata,
+		CreatedAt: now - 10,
+		Tags:      nostr.Tags{{"d", "abc"}, {"name", "the group"}, {"about", "a place"}, {"closed"}},
+	}
+	admins := nostr.Event{
+		Kind:      nostr.KindSimpleGroupAdmins,
+		CreatedAt: now - 10,
+		Tags:      nostr.Tags{{"d", "abc"}, {"p", alice, "admin"}},
+	}
+	members := nostr.Event{
+		Kind:      nostr.KindSimpleGroupMembers,
+		CreatedAt: now - 10,
+		Tags:      nostr.Tags{{"d", "abc"}, {"p", alice}, {"p", bob}},
+	}
+	otherGroup := nostr.Event{
+		Kind:      nostr.KindSimpleGroupMetadata,
+		CreatedAt: now - 10,
+		Tags:      nostr.Tags{{"d", "xyz"}, {"name", "another group"}},
+	}
+	for _, evt := range []*nostr.Event{&metadata, &oldMetadata, &admins, &members, &otherGroup} {
+		evt.Sign(relaySk)
+	}
+
+	relay := startFakeRelay(t, metadata, oldMetadata, admins, members, otherGroup)
+
+	sys := NewSystem()
+	defer sys.Close()
+
+	group, err := sys.FetchGroup(ctx, relay, "abc")
+	require.NoError(t, err)
+	require.Equal(t, "abc", group.Address.ID)
+	require.Equal(t, nostr.NormalizeURL(relay), group.Address.Relay)
+	require.Equal(t, "the group", group.Name)
+	require.Equal(t, "a place", group.About)
+	require.True(t, group.Closed)
+	require.False(t, group.Private)
+	require.Len(t, group.Members, 2)
+	require.Len(t, group.Members[alice], 1)
+	require.Equal(t, "admin", group.Members[alice][0].Name)
+	require.Empty(t, group.Members[bob])
+
+	_, err = sys.FetchGroup(ctx, relay, "nothere")
+	require.Error(t, err)
+
+	_, err = sys.FetchGroup(ctx, relay, "Not A Valid ID")
+	require.Error(t, err)
+}
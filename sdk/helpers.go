@@ -5,6 +5,7 @@ import (
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/nbd-wtf/go-nostr"
 )
 
 var json = jsoniter.ConfigFastest
@@ -21,6 +22,38 @@ func appendUnique[I comparable](arr []I, item ...I) []I {
 	return arr
 }
 
+// RelaySource is a list of relays that should be tried with a given priority (higher goes first).
+type RelaySource struct {
+	URLs     []string
+	Priority int
+}
+
+// mergeRelaySources normalizes and dedupes relays from all sources, returning them ordered by priority
+// and, within the same priority, in the order they were given.
+func mergeRelaySources(sources ...RelaySource) []string {
+	slices.SortStableFunc(sources, func(a, b RelaySource) int { return b.Priority - a.Priority })
+
+	n := 0
+	for _, source := range sources {
+		n += len(source.URLs)
+	}
+
+	relays := make([]string, 0, n)
+	for _, source := range sources {
+		for _, url := range source.URLs {
+			if url == "" {
+				continue
+			}
+			url = nostr.NormalizeURL(url)
+			if !slices.Contains(relays, url) {
+				relays = append(relays, url)
+			}
+		}
+	}
+
+	return relays
+}
+
 // doThisNotMoreThanOnceAnHour checks if an operation with the given key
 // has been performed in the last hour. If not, it returns true and records
 // the operation to prevent it from running again within the hour.
@@ -0,0 +1,176 @@
+package sdk
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+// startTestRelays starts n local relays backed by in-memory stores, each on a random free port,
+// and returns their URLs. the relays are shut down when the test ends.
+func startTestRelays(t *testing.T, n int) []string {
+	t.Helper()
+
+	urls := make([]string, n)
+	for i := range urls {
+		relay := khatru.NewRelay()
+		db := &slicestore.SliceStore{}
+		db.Init()
+		relay.QueryEvents = append(relay.QueryEvents, db.QueryEvents)
+		relay.StoreEvent = append(relay.StoreEvent, db.SaveEvent)
+		relay.ReplaceEvent = append(relay.ReplaceEvent, db.ReplaceEvent)
+		relay.DeleteEvent = append(relay.DeleteEvent, db.DeleteEvent)
+
+		started := make(chan bool)
+		go relay.Start("127.0.0.1", 0, started)
+		<-started
+
+		t.Cleanup(func() {
+			relay.Shutdown(context.Background())
+			db.Close()
+		})
+
+		urls[i] = "ws://" + relay.Addr
+	}
+
+	return urls
+}
+
+// publishTo publishes the events directly to the relay at url.
+func publishTo(t *testing.T, ctx context.Context, url string, events ...nostr.Event) {
+	t.Helper()
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	require.NoError(t, err)
+	defer relay.Close()
+
+	for _, evt := range events {
+		require.NoError(t, relay.Publish(ctx, evt))
+	}
+}
+
+// startFakeRelay starts a minimal relay that answers every REQ with the given events that match
+// its filters (without storing anything else) and returns its URL.
+func startFakeRelay(t *testing.T, events ...nostr.Event) string {
+	t.Helper()
+	return startFakeRelayWithMatcher(t, nostr.Filters.Match, nil, events...)
+}
+
+// startLyingRelay is like startFakeRelay, but it answers every REQ with all the events it has.
+func startLyingRelay(t *testing.T, events ...nostr.Event) string {
+	t.Helper()
+	return startFakeRelayWithMatcher(t, func(nostr.Filters, *nostr.Event) bool { return true }, nil, events...)
+}
+
+// startRecordingRelay is like startFakeRelay, but also returns a function that gives all the filters
+// it has received so far.
+func startRecordingRelay(t *testing.T, events ...nostr.Event) (string, func() []nostr.Filter) {
+	t.Helper()
+
+	mu := sync.Mutex{}
+	received := make([]nostr.Filter, 0, 10)
+	url := startFakeRelayWithMatcher(t, nostr.Filters.Match, func(filters nostr.Filters) {
+		mu.Lock()
+		received = append(received, filters...)
+		mu.Unlock()
+	}, events...)
+
+	return url, func() []nostr.Filter {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(received)
+	}
+}
+
+func startFakeRelayWithMatcher(
+	t *testing.T,
+	match func(nostr.Filters, *nostr.Event) bool,
+	onReq func(nostr.Filters),
+	events ...nostr.Event,
+) string {
+	t.Helper()
+
+	server := httptest.NewServer(&websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			for {
+				var raw []stdjson.RawMessage
+				if err := websocket.JSON.Receive(conn, &raw); err != nil {
+					return
+				}
+
+				var req nostr.ReqEnvelope
+				if err := req.UnmarshalJSON(mustMarshal(raw)); err != nil {
+					continue
+				}
+				if onReq != nil {
+					onReq(req.Filters)
+				}
+				for _, evt := range events {
+					if match(req.Filters, &evt) {
+						websocket.JSON.Send(conn, []any{"EVENT", req.SubscriptionID, evt})
+					}
+				}
+				websocket.JSON.Send(conn, []any{"EOSE", req.SubscriptionID})
+			}
+		},
+	})
+	t.Cleanup(server.Close)
+
+	return "ws" + server.URL[len("http"):]
+}
+
+// startSilentRelay starts a relay that accepts connections and reads everything but never answers.
+func startSilentRelay(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(&websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			for {
+				var raw []stdjson.RawMessage
+				if err := websocket.JSON.Receive(conn, &raw); err != nil {
+					return
+				}
+			}
+		},
+	})
+	t.Cleanup(server.Close)
+
+	return "ws" + server.URL[len("http"):]
+}
+
+func mustMarshal(v any) []byte {
+	j, _ := stdjson.Marshal(v)
+	return j
+}
+
+func TestMergeRelaySources(t *testing.T) {
+	relays := mergeRelaySources(
+		RelaySource{URLs: []string{"wss://fallback.com"}},
+		RelaySource{URLs: []string{"wss://outbox1.com", "wss://hint.com/", "wss://outbox2.com"}, Priority: 1},
+		RelaySource{URLs: []string{"hint.com", "wss://other-hint.com", ""}, Priority: 2},
+		RelaySource{URLs: []string{"wss://fallback.com", "wss://another-fallback.com"}},
+	)
+	require.Equal(t, []string{
+		"wss://hint.com",
+		"wss://other-hint.com",
+		"wss://outbox1.com",
+		"wss://outbox2.com",
+		"wss://fallback.com",
+		"wss://another-fallback.com",
+	}, relays)
+
+	require.Empty(t, mergeRelaySources())
+	require.Empty(t, mergeRelaySources(RelaySource{URLs: []string{""}, Priority: 3}))
+}
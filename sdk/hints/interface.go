@@ -6,4 +6,15 @@ type HintsDB interface {
 	TopN(pubkey string, n int) []string
 	Save(pubkey string, relay string, key HintKey, score nostr.Timestamp)
 	PrintScores()
+
+	// SaveBatch is the same as calling Save for each entry, but faster when there are many of them.
+	SaveBatch(entries []HintEntry)
+}
+
+// HintEntry is a single hint, as given to HintsDB.SaveBatch.
+type HintEntry struct {
+	PubKey string
+	Relay  string
+	Key    HintKey
+	When   nostr.Timestamp
 }
@@ -11,13 +11,15 @@ const (
 	MostRecentEventFetched
 	LastInRelayList
 	LastInHint
+	NegativeHint
 )
 
-var KeyBasePoints = [4]int64{
+var KeyBasePoints = [5]int64{
 	-500, // attempting has negative power because it may fail
 	700,  // when it succeeds that should cancel the negative effect of trying
 	350,  // a relay list is a very strong indicator
 	20,   // hints from various sources (tags, nprofile, nevent, nip05)
+	-100, // the relay was queried for something from this author and didn't have it
 }
 
 func (hk HintKey) BasePoints() int64 { return KeyBasePoints[hk] }
@@ -32,6 +34,8 @@ func (hk HintKey) String() string {
 		return "last_in_relay_list"
 	case LastInHint:
 		return "last_in_hint"
+	case NegativeHint:
+		return "last_queried_empty"
 	}
 	return "<unexpected>"
 }
@@ -27,7 +27,27 @@ func NewHintDB() *HintDB {
 }
 
 func (db *HintDB) Save(pubkey string, relay string, key hints.HintKey, ts nostr.Timestamp) {
-	if now := nostr.Now(); ts > now {
+	now := nostr.Now()
+
+	db.Lock()
+	defer db.Unlock()
+
+	db.save(pubkey, relay, key, ts, now)
+}
+
+func (db *HintDB) SaveBatch(entries []hints.HintEntry) {
+	now := nostr.Now()
+
+	db.Lock()
+	defer db.Unlock()
+
+	for _, e := range entries {
+		db.save(e.PubKey, e.Relay, e.Key, e.When, now)
+	}
+}
+
+func (db *HintDB) save(pubkey string, relay string, key hints.HintKey, ts nostr.Timestamp, now nostr.Timestamp) {
+	if ts > now {
 		ts = now
 	}
 
@@ -36,9 +56,6 @@ func (db *HintDB) Save(pubkey string, relay string, key hints.HintKey, ts nostr.
 		relayIndex = len(db.RelayBySerial)
 		db.RelayBySerial = append(db.RelayBySerial, relay)
 	}
-
-	db.Lock()
-	defer db.Unlock()
 	// fmt.Println(" ", relay, "index", relayIndex, "--", "adding", hints.HintKey(key).String(), ts)
 
 	rfpk, _ := db.OrderedRelaysByPubKey[pubkey]
@@ -114,7 +131,7 @@ type RelaysForPubKey struct {
 
 type RelayEntry struct {
 	Relay      int
-	Timestamps [4]nostr.Timestamp
+	Timestamps [5]nostr.Timestamp
 }
 
 func (re RelayEntry) Sum() int64 {
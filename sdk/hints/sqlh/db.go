@@ -117,6 +117,16 @@ func NewSQLHints(db *sql.DB, driverName string) (SQLHints, error) {
 			}
 		}
 
+		if version == 2 {
+			version = 3
+			if _, err := txn.Exec(
+				`ALTER TABLE nostr_sdk_pubkey_relays ADD COLUMN last_queried_empty integer`,
+			); err != nil {
+				txn.Rollback()
+				return SQLHints{}, err
+			}
+		}
+
 		if _, err := txn.Exec(
 			fmt.Sprintf(`UPDATE nostr_sdk_db_version SET version = %d`, version),
 		); err != nil {
@@ -182,6 +192,35 @@ func (sh SQLHints) Save(pubkey string, relay string, key hints.HintKey, ts nostr
 	}
 }
 
+func (sh SQLHints) SaveBatch(entries []hints.HintEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	now := nostr.Now()
+
+	txn, err := sh.Beginx()
+	if err != nil {
+		nostr.InfoLogger.Printf("[sdk/hints/sql] unexpected error starting batch of %d: %s\n", len(entries), err)
+		return
+	}
+
+	var stmts [len(hints.KeyBasePoints)]*sqlx.Stmt
+	for _, e := range entries {
+		ts := min(e.When, now)
+		if stmts[e.Key] == nil {
+			stmts[e.Key] = txn.Stmtx(sh.saves[e.Key])
+		}
+		if _, err := stmts[e.Key].Exec(e.PubKey, e.Relay, ts, ts); err != nil {
+			nostr.InfoLogger.Printf("[sdk/hints/sql] unexpected error on insert for %s, %s, %d: %s\n",
+				e.PubKey, e.Relay, ts, err)
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		nostr.InfoLogger.Printf("[sdk/hints/sql] unexpected error committing batch of %d: %s\n", len(entries), err)
+	}
+}
+
 func (sh SQLHints) PrintScores() {
 	fmt.Println("= print scores")
 
@@ -1,11 +1,51 @@
 package test
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
 	"github.com/nbd-wtf/go-nostr/sdk/hints/memoryh"
 )
 
 func TestMemoryHints(t *testing.T) {
 	runTestWith(t, memoryh.NewHintDB())
 }
+
+func TestMemoryHintsConcurrency(t *testing.T) {
+	runConcurrencyTestWith(t, memoryh.NewHintDB())
+}
+
+func benchmarkHintEntries() []hints.HintEntry {
+	entries := make([]hints.HintEntry, 500)
+	for i := range entries {
+		entries[i] = hints.HintEntry{
+			PubKey: fmt.Sprintf("%064x", i%50),
+			Relay:  fmt.Sprintf("wss://relay%d.com", i%20),
+			Key:    hints.HintKey(i % 4),
+			When:   nostr.Now() - nostr.Timestamp(i),
+		}
+	}
+	return entries
+}
+
+func BenchmarkMemoryHintsSave(b *testing.B) {
+	hdb := memoryh.NewHintDB()
+	entries := benchmarkHintEntries()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range entries {
+			hdb.Save(e.PubKey, e.Relay, e.Key, e.When)
+		}
+	}
+}
+
+func BenchmarkMemoryHintsSaveBatch(b *testing.B) {
+	hdb := memoryh.NewHintDB()
+	entries := benchmarkHintEntries()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hdb.SaveBatch(entries)
+	}
+}
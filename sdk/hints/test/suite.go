@@ -1,6 +1,8 @@
 package test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -129,4 +131,56 @@ func runTestWith(t *testing.T, hdb hints.HintsDB) {
 	require.Equal(t, []string{relayC, relayA}, hdb.TopN(key2, 2))
 	require.Equal(t, []string{relayB, relayA, relayC}, hdb.TopN(key1, 3))
 	require.Equal(t, []string{relayA, relayB}, hdb.TopN(key3, 3))
+
+	// key5 gets everything key3 got, but all at once, and ends up the same
+	const key5 = "0000000000000000000000000000000000000000000000000000000000000005"
+	hdb.SaveBatch([]hints.HintEntry{
+		{PubKey: key5, Relay: relayA, Key: hints.LastInHint, When: nostr.Now() - day*2},
+		{PubKey: key5, Relay: relayB, Key: hints.LastInHint, When: nostr.Now() - day},
+		{PubKey: key5, Relay: relayA, Key: hints.LastFetchAttempt, When: nostr.Now() - 5*hour},
+		{PubKey: key5, Relay: relayA, Key: hints.MostRecentEventFetched, When: nostr.Now() - day},
+		{PubKey: key5, Relay: relayB, Key: hints.LastFetchAttempt, When: nostr.Now() - 5*hour},
+		{PubKey: key5, Relay: relayB, Key: hints.MostRecentEventFetched, When: nostr.Now() - day*30},
+		// an older timestamp in the same batch doesn't overwrite a newer one
+		{PubKey: key5, Relay: relayB, Key: hints.MostRecentEventFetched, When: nostr.Now() - day*60},
+	})
+	hdb.SaveBatch(nil)
+	require.Equal(t, hdb.TopN(key3, 3), hdb.TopN(key5, 3))
+}
+
+func runConcurrencyTestWith(t *testing.T, hdb hints.HintsDB) {
+	relays := make([]string, 16)
+	for i := range relays {
+		relays[i] = fmt.Sprintf("wss://relay%d.com", i)
+	}
+	pubkeys := []string{
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000000000000000000002",
+		"0000000000000000000000000000000000000000000000000000000000000003",
+	}
+
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < 200; i++ {
+				pubkey := pubkeys[(g+i)%len(pubkeys)]
+				relay := relays[(g+i)%len(relays)]
+				if i%3 == 2 {
+					hdb.TopN(pubkey, 3)
+				} else {
+					hdb.Save(pubkey, relay, hints.HintKey(i%4), nostr.Now()-nostr.Timestamp(i))
+				}
+			}
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+
+	for _, pubkey := range pubkeys {
+		require.ElementsMatch(t, relays, hdb.TopN(pubkey, len(relays)))
+	}
 }
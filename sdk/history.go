@@ -0,0 +1,59 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FetchReplaceableHistory fetches past versions of a replaceable or addressable event from the local store,
+// from the relays in the pointer and from the author's outbox relays.
+//
+// Unlike FetchSpecificEvent it doesn't keep only the newest version, it returns all the versions it could find
+// sorted by created_at (newest first), up to limit (no limit if limit is 0).
+func (sys *System) FetchReplaceableHistory(
+	ctx context.Context,
+	pointer nostr.EntityPointer,
+	limit int,
+) ([]*nostr.Event, error) {
+	filter := pointer.AsFilter()
+	if !nostr.IsAddressableKind(pointer.Kind) {
+		// plain replaceable events don't have a "d" tag
+		filter.Tags = nil
+	}
+	if limit > 0 {
+		filter.Limit = limit
+	}
+
+	relays := make([]string, 0, 10)
+	for _, r := range pointer.Relays {
+		relays = appendUnique(relays, nostr.NormalizeURL(r))
+	}
+	relays = appendUnique(relays, sys.FetchOutboxRelays(ctx, pointer.PublicKey, sys.OutboxRelayCount)...)
+	relays = appendUnique(relays, sys.FallbackRelays.Next())
+
+	results := make([]*nostr.Event, 0, max(limit, 10))
+	if res, _ := sys.StoreRelay.QuerySync(ctx, filter); len(res) != 0 {
+		results = append(results, res...)
+	}
+
+	for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("history")) {
+		if slices.ContainsFunc(results, func(evt *nostr.Event) bool { return evt.ID == ie.ID }) {
+			continue
+		}
+		results = append(results, ie.Event)
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("couldn't find any version of %s", pointer.AsTagReference())
+	}
+
+	slices.SortFunc(results, func(a, b *nostr.Event) int { return int(b.CreatedAt - a.CreatedAt) })
+	if limit > 0 && len(results) > limit {
+		results = results[0:limit]
+	}
+
+	return results, nil
+}
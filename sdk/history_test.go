@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchReplaceableHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	relays := startTestRelays(t, 3)
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	versions := make([]nostr.Event, 3)
+	for i := range versions {
+		versions[i] = nostr.Event{
+			Kind:      30023,
+			CreatedAt: nostr.Now() - nostr.Timestamp(100*(3-i)),
+			Tags:      nostr.Tags{{"d", "article"}},
+			Content:   "version " + strconv.Itoa(i+1),
+		}
+		versions[i].Sign(sk)
+	}
+
+	// each relay only has one of the versions
+	for i, url := range relays {
+		publishTo(t, ctx, url, versions[i])
+	}
+
+	sys := NewSystem(WithFallbackRelays([]string{relays[0]}))
+	defer sys.Close()
+
+	pointer := nostr.EntityPointer{PublicKey: pk, Kind: 30023, Identifier: "article", Relays: relays}
+
+	history, err := sys.FetchReplaceableHistory(ctx, pointer, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, versions[2].ID, history[0].ID)
+	require.Equal(t, versions[1].ID, history[1].ID)
+	require.Equal(t, versions[0].ID, history[2].ID)
+
+	history, err = sys.FetchReplaceableHistory(ctx, pointer, 2)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, versions[2].ID, history[0].ID)
+	require.Equal(t, versions[1].ID, history[1].ID)
+
+	_, err = sys.FetchReplaceableHistory(ctx, nostr.EntityPointer{PublicKey: pk, Kind: 30023, Identifier: "nothing", Relays: relays}, 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ImportEvents saves events from some archive or dump to StoreRelay and uses them to warm up the rest of
+// the system, mining relay hints from their tags and caching the profiles and relay lists among them.
+//
+// Events that are invalid, ephemeral or already known are skipped, as are replaceable and addressable
+// events for which we already have a newer version. imported is the number of events actually saved.
+func (sys *System) ImportEvents(ctx context.Context, events []*nostr.Event) (imported int, err error) {
+	for _, evt := range events {
+		if err := ctx.Err(); err != nil {
+			return imported, fmt.Errorf("import interrupted: %w", err)
+		}
+
+		if nostr.IsEphemeralKind(evt.Kind) {
+			continue
+		}
+		// the signature is checked against the serialized event, not against the id, so that is checked first
+		if !evt.CheckID() {
+			continue
+		}
+		if ok, _ := evt.CheckSignature(); !ok {
+			continue
+		}
+		if sys.hasSameOrNewer(ctx, evt) {
+			continue
+		}
+
+		if err := sys.StoreRelay.Publish(ctx, *evt); err != nil {
+			return imported, fmt.Errorf("failed to store %s: %w", evt.ID, err)
+		}
+		imported++
+
+		sys.trackEventHints(nostr.RelayEvent{Event: evt})
+
+		// the caches may have something newer than what the store had, which is fetched from relays
+		switch evt.Kind {
+		case 0:
+			if cached, ok := sys.MetadataCache.Get(evt.PubKey); ok && cached.Event != nil && cached.Event.CreatedAt >= evt.CreatedAt {
+				continue
+			}
+			if pm, err := ParseMetadata(evt); err == nil {
+				pm.PubKey = evt.PubKey
+				pm.Event = evt
+				sys.MetadataCache.SetWithTTL(evt.PubKey, pm, time.Hour*6)
+			}
+		case 10002:
+			if cached, ok := sys.RelayListCache.Get(evt.PubKey); ok && cached.Event != nil && cached.Event.CreatedAt >= evt.CreatedAt {
+				continue
+			}
+			sys.RelayListCache.SetWithTTL(evt.PubKey, GenericList[Relay]{
+				PubKey: evt.PubKey,
+				Event:  evt,
+				Items:  parseItemsFromEventTags(evt, parseRelayFromKind10002),
+			}, time.Hour*6)
+		}
+	}
+
+	return imported, nil
+}
+
+// hasSameOrNewer tells if StoreRelay already has evt or, if it is replaceable or addressable, a version of
+// it that is at least as recent.
+func (sys *System) hasSameOrNewer(ctx context.Context, evt *nostr.Event) bool {
+	filter, err := nostr.FilterForLatest(evt)
+	if err != nil {
+		filter = nostr.Filter{IDs: []string{evt.ID}}
+	}
+	filter.Limit = 1
+
+	res, _ := sys.StoreRelay.QuerySync(ctx, filter)
+	return len(res) > 0 && res[0].CreatedAt >= evt.CreatedAt
+}
@@ -0,0 +1,100 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr"
+	cache_memory "github.com/nbd-wtf/go-nostr/sdk/cache/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportEvents(t *testing.T) {
+	ctx := context.Background()
+
+	store := &slicestore.SliceStore{}
+	store.Init()
+	sys := NewSystem(WithStore(store))
+	defer sys.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	friend, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	sign := func(evt nostr.Event) *nostr.Event {
+		require.NoError(t, evt.Sign(sk))
+		return &evt
+	}
+
+	now := nostr.Now()
+	newProfile := sign(nostr.Event{Kind: 0, CreatedAt: now - 10, Content: `{"name":"new"}`})
+	oldProfile := sign(nostr.Event{Kind: 0, CreatedAt: now - 100, Content: `{"name":"old"}`})
+	relayList := sign(nostr.Event{Kind: 10002, CreatedAt: now - 50, Tags: nostr.Tags{{"r", "wss://outbox.com", "write"}, {"r", "wss://inbox.com", "read"}}})
+	note := sign(nostr.Event{Kind: 1, CreatedAt: now - 20, Tags: nostr.Tags{{"p", friend, "wss://friend.com"}}, Content: "hello"})
+	ephemeral := sign(nostr.Event{Kind: 20001, CreatedAt: now - 5})
+	forged := sign(nostr.Event{Kind: 1, CreatedAt: now - 30, Content: "original"})
+	forged.Content = "forged"
+	wrongID := sign(nostr.Event{Kind: 1, CreatedAt: now - 40, Content: "wrong id"})
+	wrongID.ID = note.ID[0:60] + "0000"
+
+	imported, err := sys.ImportEvents(ctx, []*nostr.Event{newProfile, relayList, note, oldProfile, note, ephemeral, forged, wrongID})
+	require.NoError(t, err)
+	require.Equal(t, 3, imported)
+
+	// store
+	stored, _ := sys.StoreRelay.QuerySync(ctx, nostr.Filter{Authors: []string{pk}})
+	ids := make([]string, len(stored))
+	for i, evt := range stored {
+		ids[i] = evt.ID
+	}
+	require.ElementsMatch(t, []string{newProfile.ID, relayList.ID, note.ID}, ids)
+
+	// hints
+	require.Equal(t, []string{"wss://outbox.com"}, sys.Hints.TopN(pk, 3))
+	require.Equal(t, []string{"wss://friend.com"}, sys.Hints.TopN(friend, 3))
+
+	// caches
+	require.Eventually(t, func() bool {
+		pm, ok := sys.MetadataCache.Get(pk)
+		return ok && pm.Name == "new"
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		rl, ok := sys.RelayListCache.Get(pk)
+		return ok && len(rl.Items) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// importing again doesn't do anything
+	imported, err = sys.ImportEvents(ctx, []*nostr.Event{newProfile, relayList, note})
+	require.NoError(t, err)
+	require.Equal(t, 0, imported)
+
+	// a system that has newer profiles and relay lists in its caches (but not in its store) keeps them
+	otherStore := &slicestore.SliceStore{}
+	otherStore.Init()
+	metadataCache := cache_memory.New32[ProfileMetadata](100)
+	relayListCache := cache_memory.New32[GenericList[Relay]](100)
+	other := NewSystem(WithStore(otherStore), WithMetadataCache(metadataCache), WithRelayListCache(relayListCache))
+	defer other.Close()
+	newerRelayList := sign(nostr.Event{Kind: 10002, CreatedAt: now - 1, Tags: nostr.Tags{{"r", "wss://newer.com"}}})
+	relayListCache.SetWithTTL(pk, GenericList[Relay]{PubKey: pk, Event: newerRelayList}, time.Hour)
+	metadataCache.SetWithTTL(pk, ProfileMetadata{PubKey: pk, Event: sign(nostr.Event{Kind: 0, CreatedAt: now - 1}), Name: "newer"}, time.Hour)
+	relayListCache.Cache.Wait()
+	metadataCache.Cache.Wait()
+
+	imported, err = other.ImportEvents(ctx, []*nostr.Event{newProfile, relayList})
+	require.NoError(t, err)
+	require.Equal(t, 2, imported)
+	relayListCache.Cache.Wait()
+	metadataCache.Cache.Wait()
+	pm, _ := other.MetadataCache.Get(pk)
+	require.Equal(t, "newer", pm.Name)
+	rl, _ := other.RelayListCache.Get(pk)
+	require.Equal(t, newerRelayList.ID, rl.Event.ID)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = sys.ImportEvents(canceled, []*nostr.Event{oldProfile})
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -3,6 +3,7 @@ package sdk
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip05"
@@ -63,3 +64,30 @@ func InputToEventPointer(input string) *nostr.EventPointer {
 	// handle nip05 ids, if that's the case
 	return nil
 }
+
+// EventIDToNote turns a hex event id into a note1 code.
+func EventIDToNote(id string) (string, error) {
+	if !nostr.IsValid32ByteHex(id) {
+		return "", fmt.Errorf("invalid event id '%s'", id)
+	}
+	return nip19.EncodeNote(id)
+}
+
+// EventToNevent turns evt into a nevent1 code with its author and the given relays as hints. If no relays
+// are given it picks up to 3 from the relays we have seen evt on or, failing that, from the ones its author
+// is most likely to be found on, according to our hints.
+func (sys *System) EventToNevent(evt *nostr.Event, relays []string) (string, error) {
+	if len(relays) == 0 {
+		relays = make([]string, 0, 3)
+		for _, url := range sys.knownEventRelays(evt, 10) {
+			if IsVirtualRelay(url) {
+				continue
+			}
+			relays = append(relays, url)
+			if len(relays) == 3 {
+				break
+			}
+		}
+	}
+	return nip19.EncodeEvent(evt.ID, relays, evt.PubKey)
+}
@@ -0,0 +1,68 @@
+package sdk
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventIDToNote(t *testing.T) {
+	id := "dc90c95f09947507c1044e8f48bcf6350aa6bff1507dd4acfc755b9239b5c962"
+	note, err := EventIDToNote(id)
+	require.NoError(t, err)
+
+	prefix, data, err := nip19.Decode(note)
+	require.NoError(t, err)
+	require.Equal(t, "note", prefix)
+	require.Equal(t, id, data)
+	require.Equal(t, id, InputToEventPointer(note).ID)
+
+	_, err = EventIDToNote("dc90c95f")
+	require.Error(t, err)
+	_, err = EventIDToNote("DC90C95F09947507C1044E8F48BCF6350AA6BFF1507DD4ACFC755B9239B5C962")
+	require.Error(t, err)
+}
+
+func TestEventToNevent(t *testing.T) {
+	sys := NewSystem()
+	defer sys.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	seen := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "seen"}
+	seen.Sign(sk)
+	unseen := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "unseen"}
+	unseen.Sign(sk)
+
+	sys.trackEventRelay(seen.ID, "wss://seen.com", false)
+	sys.trackEventRelay(seen.ID, "ws://localhost:7777", false)
+	sys.Hints.Save(pk, "wss://outbox.com", hints.LastInRelayList, nostr.Now())
+
+	decode := func(code string) nostr.EventPointer {
+		prefix, data, err := nip19.Decode(code)
+		require.NoError(t, err)
+		require.Equal(t, "nevent", prefix)
+		return data.(nostr.EventPointer)
+	}
+
+	// explicit relays are used as they are
+	code, err := sys.EventToNevent(&seen, []string{"wss://given.com"})
+	require.NoError(t, err)
+	require.Equal(t, nostr.EventPointer{ID: seen.ID, Author: pk, Relays: []string{"wss://given.com"}}, decode(code))
+
+	// otherwise relays we've seen the event on are used, except the virtual ones
+	code, err = sys.EventToNevent(&seen, nil)
+	require.NoError(t, err)
+	require.Equal(t, nostr.EventPointer{ID: seen.ID, Author: pk, Relays: []string{"wss://seen.com"}}, decode(code))
+
+	// and then relays from the author
+	code, err = sys.EventToNevent(&unseen, nil)
+	require.NoError(t, err)
+	require.Equal(t, nostr.EventPointer{ID: unseen.ID, Author: pk, Relays: []string{"wss://outbox.com"}}, decode(code))
+
+	_, err = sys.EventToNevent(&nostr.Event{ID: "invalid"}, nil)
+	require.Error(t, err)
+}
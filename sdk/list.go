@@ -5,6 +5,7 @@ import (
 	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -24,7 +25,7 @@ type TagItemWithValue interface {
 
 var (
 	genericListMutexes = [60]sync.Mutex{}
-	valueWasJustCached = [60]bool{}
+	valueWasJustCached = [60]atomic.Bool{}
 )
 
 func fetchGenericList[I TagItemWithValue](
@@ -43,10 +44,10 @@ func fetchGenericList[I TagItemWithValue](
 	lockIdx := (n + uint64(actualKind)) % 60
 	genericListMutexes[lockIdx].Lock()
 
-	if valueWasJustCached[lockIdx] {
+	if valueWasJustCached[lockIdx].Load() {
 		// this ensures the cache has had time to commit the values
 		// so we don't repeat a fetch immediately after the other
-		valueWasJustCached[lockIdx] = false
+		valueWasJustCached[lockIdx].Store(false)
 		time.Sleep(time.Millisecond * 10)
 	}
 
@@ -81,7 +82,7 @@ func fetchGenericList[I TagItemWithValue](
 
 		// and finally save this to cache
 		cache.SetWithTTL(pubkey, v, time.Hour*6)
-		valueWasJustCached[lockIdx] = true
+		valueWasJustCached[lockIdx].Store(true)
 
 		return v, true
 	}
@@ -96,7 +97,7 @@ func fetchGenericList[I TagItemWithValue](
 
 	// save cache even if we didn't get anything
 	cache.SetWithTTL(pubkey, v, time.Hour*6)
-	valueWasJustCached[lockIdx] = true
+	valueWasJustCached[lockIdx].Store(true)
 
 	return v, false
 }
@@ -2,8 +2,6 @@ package sdk
 
 import (
 	"context"
-	"strconv"
-	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 	cache_memory "github.com/nbd-wtf/go-nostr/sdk/cache/memory"
@@ -43,29 +41,25 @@ func parseEventRef(tag nostr.Tag) (evr EventRef, ok bool) {
 		pointer := nostr.EventPointer{
 			ID: tag[1],
 		}
-		if len(tag) >= 3 {
-			pointer.Relays = []string{nostr.NormalizeURL(tag[2])}
-			if len(tag) >= 4 {
-				pointer.Author = tag[3]
-			}
+		if relay, ok := tag.RelayHint(); ok {
+			pointer.Relays = []string{relay}
+		}
+		if len(tag) >= 4 {
+			pointer.Author = tag[3]
 		}
 		evr.Pointer = pointer
 	case "a":
-		spl := strings.SplitN(tag[1], ":", 3)
-		if len(spl) != 3 || !nostr.IsValidPublicKey(spl[1]) {
+		kind, pubkey, identifier, err := nostr.ParseEntityAddress(tag[1])
+		if err != nil {
 			return evr, false
 		}
 		pointer := nostr.EntityPointer{
-			PublicKey:  spl[1],
-			Identifier: spl[2],
-		}
-		if kind, err := strconv.Atoi(spl[0]); err != nil {
-			return evr, false
-		} else {
-			pointer.Kind = kind
+			PublicKey:  pubkey,
+			Kind:       kind,
+			Identifier: identifier,
 		}
-		if len(tag) >= 3 {
-			pointer.Relays = []string{nostr.NormalizeURL(tag[2])}
+		if relay, ok := tag.RelayHint(); ok {
+			pointer.Relays = []string{relay}
 		}
 		evr.Pointer = pointer
 	default:
@@ -105,12 +105,17 @@ func (sys System) FetchProfileFromInput(ctx context.Context, nip19OrNip05Code st
 // FetchProfileMetadata fetches metadata for a given user from the local cache, or from the local store,
 // or, failing these, from the target user's defined outbox relays -- then caches the result.
 // It always returns a ProfileMetadata, even if no metadata was found (in which case only the PubKey field is set).
+//
+// If ctx is canceled before a result is found nothing is cached, so a later call can try again.
 func (sys *System) FetchProfileMetadata(ctx context.Context, pubkey string) (pm ProfileMetadata) {
 	if v, ok := sys.MetadataCache.Get(pubkey); ok {
 		return v
 	}
 
 	pm.PubKey = pubkey
+	if ctx.Err() != nil {
+		return pm
+	}
 
 	res, _ := sys.StoreRelay.QuerySync(ctx, nostr.Filter{Kinds: []int{0}, Authors: []string{pubkey}})
 	if len(res) != 0 {
@@ -122,7 +127,7 @@ func (sys *System) FetchProfileMetadata(ctx context.Context, pubkey string) (pm
 		// but if we haven't tried fetching from the network recently we should do it
 		lastFetchKey := makeLastFetchKey(0, pubkey)
 		lastFetchData, _ := sys.KVStore.Get(lastFetchKey)
-		if lastFetchData == nil || nostr.Now()-decodeTimestamp(lastFetchData) > 7*24*60*60 {
+		if ctx.Err() == nil && (lastFetchData == nil || nostr.Now()-decodeTimestamp(lastFetchData) > 7*24*60*60) {
 			newM := sys.tryFetchMetadataFromNetwork(ctx, pubkey)
 			if newM != nil && newM.Event.CreatedAt > pm.Event.CreatedAt {
 				pm = *newM
@@ -147,6 +152,11 @@ func (sys *System) FetchProfileMetadata(ctx context.Context, pubkey string) (pm
 		sys.KVStore.Set(lastFetchKey, encodeTimestamp(nostr.Now()))
 	}
 
+	if ctx.Err() != nil {
+		// we were canceled midway, so this result can't be trusted as a definitive "not found"
+		return pm
+	}
+
 	// save cache even if we didn't get anything
 	sys.MetadataCache.SetWithTTL(pubkey, pm, time.Hour*6)
 
@@ -154,10 +164,32 @@ func (sys *System) FetchProfileMetadata(ctx context.Context, pubkey string) (pm
 }
 
 func (sys *System) tryFetchMetadataFromNetwork(ctx context.Context, pubkey string) *ProfileMetadata {
+	if ctx.Err() != nil {
+		return nil
+	}
+
 	thunk0 := sys.replaceableLoaders[kind_0].Load(ctx, pubkey)
-	evt, err := thunk0()
-	if err != nil {
+
+	// the batch has its own timeout and doesn't stop when ctx is canceled, so we don't wait for it then
+	type result struct {
+		evt *nostr.Event
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		evt, err := thunk0()
+		done <- result{evt, err}
+	}()
+
+	var evt *nostr.Event
+	select {
+	case <-ctx.Done():
 		return nil
+	case res := <-done:
+		if res.err != nil {
+			return nil
+		}
+		evt = res.evt
 	}
 
 	pm, err := ParseMetadata(evt)
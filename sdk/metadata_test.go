@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchProfileMetadataCanceled(t *testing.T) {
+	sys := NewSystem(WithMetadataRelays(startTestRelays(t, 1)))
+	defer sys.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pm := sys.FetchProfileMetadata(ctx, pk)
+	require.Equal(t, pk, pm.PubKey)
+	require.Nil(t, pm.Event)
+
+	time.Sleep(100 * time.Millisecond)
+	_, cached := sys.MetadataCache.Get(pk)
+	require.False(t, cached, "result of a canceled fetch shouldn't be cached")
+	require.Zero(t, goroutinesRunning("(*System).FetchProfileMetadata"), "canceled fetch left goroutines behind")
+}
+
+func TestFetchSpecificEventCanceledProfilePrefetch(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(sk)
+
+	url := startFakeRelay(t, evt)
+
+	// profiles and relay lists are never found, so the prefetch only ends when ctx is canceled
+	silent := startSilentRelay(t)
+	sys := NewSystem(
+		WithFallbackRelays([]string{url}),
+		WithJustIDRelays([]string{url}),
+		WithMetadataRelays([]string{silent}),
+		WithRelayListRelays([]string{silent}),
+	)
+	defer sys.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, _, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt.ID, Relays: []string{url}}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+
+	// the prefetch is still in flight when we cancel
+	require.Eventually(t, func() bool {
+		return goroutinesRunning("(*System).FetchProfileMetadata") > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	require.Eventually(t, func() bool {
+		return goroutinesRunning("(*System).FetchProfileMetadata") == 0
+	}, time.Second, 10*time.Millisecond, "canceled prefetch didn't return")
+
+	// the batch it was waiting for has its own timeout, after which nothing is left behind either
+	require.Eventually(t, func() bool {
+		return goroutinesRunning("(*System).tryFetchMetadataFromNetwork") == 0
+	}, 10*time.Second, 50*time.Millisecond, "canceled prefetch left goroutines behind")
+
+	time.Sleep(100 * time.Millisecond)
+	_, cached := sys.MetadataCache.Get(pk)
+	require.False(t, cached, "result of a canceled prefetch shouldn't be cached")
+}
+
+// goroutinesRunning counts the goroutines that have fn somewhere in their stacks.
+func goroutinesRunning(fn string) int {
+	buf := make([]byte, 1<<20)
+	buf = buf[0:runtime.Stack(buf, true)]
+
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, fn) {
+			count++
+		}
+	}
+	return count
+}
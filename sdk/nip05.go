@@ -0,0 +1,46 @@
+package sdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
+)
+
+const nip05CacheTTL = time.Hour * 6
+
+// ResolveNIP05 turns a NIP-05 identifier like "name@domain" (or just "domain", for "_@domain") into a pubkey
+// and the relays listed for it, if any. Successful results are cached in NIP05Cache for some hours and the
+// relays are saved as hints for the pubkey.
+func (sys *System) ResolveNIP05(ctx context.Context, identifier string) (pubkey string, relays []string, err error) {
+	// cache keys are expected to be hex
+	hash := sha256.Sum256([]byte(strings.ToLower(nip05.NormalizeIdentifier(identifier))))
+	key := hex.EncodeToString(hash[:])
+	if pp, ok := sys.NIP05Cache.Get(key); ok {
+		return pp.PublicKey, pp.Relays, nil
+	}
+
+	pp, err := nip05.QueryIdentifier(ctx, identifier)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := nostr.Now()
+	batch := make([]hints.HintEntry, 0, len(pp.Relays))
+	for _, url := range pp.Relays {
+		if nostr.IsValidRelayURL(url) {
+			batch = append(batch, hints.HintEntry{PubKey: pp.PublicKey, Relay: nostr.NormalizeURL(url), Key: hints.LastInHint, When: now})
+		}
+	}
+	if len(batch) > 0 {
+		sys.Hints.SaveBatch(batch)
+	}
+	sys.NIP05Cache.SetWithTTL(key, *pp, nip05CacheTTL)
+
+	return pp.PublicKey, pp.Relays, nil
+}
@@ -0,0 +1,99 @@
+package sdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+// redirectTransport sends every request to target, so https://anything/.well-known/nostr.json
+// can be served by a local test server.
+type redirectTransport struct {
+	target *url.URL
+	next   http.RoundTripper
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return rt.next.RoundTrip(req)
+}
+
+func TestResolveNIP05(t *testing.T) {
+	bob, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	alice, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		require.Equal(t, "/.well-known/nostr.json", r.URL.Path)
+		w.Write(mustMarshal(map[string]any{
+			"names":  map[string]string{"bob": bob, "_": alice},
+			"relays": map[string][]string{bob: {"wss://bob.relay.com", "not a relay"}},
+		}))
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	original := http.DefaultTransport
+	http.DefaultTransport = redirectTransport{target, original}
+	t.Cleanup(func() { http.DefaultTransport = original })
+
+	sys := NewSystem()
+	defer sys.Close()
+	ctx := context.Background()
+
+	pubkey, relays, err := sys.ResolveNIP05(ctx, "bob@example.com")
+	require.NoError(t, err)
+	require.Equal(t, bob, pubkey)
+	require.Equal(t, []string{"wss://bob.relay.com", "not a relay"}, relays)
+	require.Equal(t, []string{"wss://bob.relay.com"}, sys.Hints.TopN(bob, 3))
+
+	pubkey, relays, err = sys.ResolveNIP05(ctx, "example.com")
+	require.NoError(t, err)
+	require.Equal(t, alice, pubkey)
+	require.Empty(t, relays)
+
+	_, _, err = sys.ResolveNIP05(ctx, "carol@example.com")
+	require.Error(t, err)
+	_, _, err = sys.ResolveNIP05(ctx, "not an identifier")
+	require.Error(t, err)
+	require.Equal(t, int32(3), requests.Load())
+
+	// successful results get cached (eventually, as the cache is asynchronous)
+	require.Eventually(t, func() bool {
+		before := requests.Load()
+		pubkey, _, err := sys.ResolveNIP05(ctx, "BOB@example.com")
+		return err == nil && pubkey == bob && requests.Load() == before
+	}, time.Second, 10*time.Millisecond)
+	before := requests.Load()
+	for range 3 {
+		pubkey, _, _ = sys.ResolveNIP05(ctx, "bob@example.com")
+		require.Equal(t, bob, pubkey)
+	}
+	require.Equal(t, before, requests.Load())
+
+	// concurrent first calls on a fresh system must be safe
+	fresh := NewSystem()
+	defer fresh.Close()
+	wg := sync.WaitGroup{}
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pubkey, _, err := fresh.ResolveNIP05(ctx, "bob@example.com")
+			require.NoError(t, err)
+			require.Equal(t, bob, pubkey)
+		}()
+	}
+	wg.Wait()
+}
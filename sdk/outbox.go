@@ -2,11 +2,19 @@ package sdk
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
+
+	"github.com/nbd-wtf/go-nostr"
 )
 
-var outboxShortTermCache = [256]ostcEntry{}
+var (
+	outboxShortTermCache   = [256]ostcEntry{}
+	outboxShortTermCacheMu sync.Mutex
+)
 
 type ostcEntry struct {
 	pubkey string
@@ -18,15 +26,46 @@ type ostcEntry struct {
 // NIP-05, past attempts at fetching data from a user from a given relay, including successes and failures, and
 // the "write" relays of kind:10002, in order to determine the best possible list of relays where a user might be
 // currently publishing their events to.
+//
+// At most n relays are returned. If the relay list can't be fetched within sys.OutboxFetchTimeout (or before ctx
+// is canceled) the hints we already have are used.
 func (sys *System) FetchOutboxRelays(ctx context.Context, pubkey string, n int) []string {
 	ostcIndex, _ := strconv.ParseUint(pubkey[12:14], 16, 8)
 	now := time.Now()
-	if entry := outboxShortTermCache[ostcIndex]; entry.pubkey == pubkey && entry.when.Add(time.Minute*2).After(now) {
-		return entry.relays
+	outboxShortTermCacheMu.Lock()
+	entry := outboxShortTermCache[ostcIndex]
+	outboxShortTermCacheMu.Unlock()
+	if entry.pubkey == pubkey && entry.when.Add(time.Minute*2).After(now) {
+		relays := entry.relays
+		if len(relays) > n {
+			relays = relays[0:n]
+		}
+		return slices.Clone(relays)
 	}
 
-	// if we have it cached that means we have at least tried to fetch recently and it won't be tried again
-	fetchGenericList(sys, ctx, pubkey, 10002, kind_10002, parseRelayFromKind10002, sys.RelayListCache)
+	// if we have it cached that means we have at least tried to fetch recently and it won't be tried again.
+	// the fetch goes through a dataloader that doesn't care about our context, so we may stop waiting for it
+	// earlier and let it finish in the background, which will still update the hints for the next time
+	// (ctx is passed untouched because the dataloader checks for contextForSub10002Query)
+	fetched := make(chan struct{})
+	go func() {
+		fetchGenericList(sys, ctx, pubkey, 10002, kind_10002, parseRelayFromKind10002, sys.RelayListCache)
+		close(fetched)
+	}()
+	var timeout <-chan time.Time
+	if sys.OutboxFetchTimeout > 0 {
+		timer := time.NewTimer(sys.OutboxFetchTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	complete := true
+	select {
+	case <-fetched:
+	case <-timeout:
+		complete = false
+	case <-ctx.Done():
+		complete = false
+	}
 
 	relays := sys.Hints.TopN(pubkey, 6)
 	if len(relays) == 0 {
@@ -34,10 +73,15 @@ func (sys *System) FetchOutboxRelays(ctx context.Context, pubkey string, n int)
 	}
 
 	// we save a copy of this slice to this cache (must be a copy otherwise
-	// we will have a reference to a thing that the caller to this function may change at will)
-	relaysCopy := make([]string, len(relays))
-	copy(relaysCopy, relays)
-	outboxShortTermCache[ostcIndex] = ostcEntry{pubkey, relaysCopy, now}
+	// we will have a reference to a thing that the caller to this function may change at will),
+	// but only if we got to look at the relay list
+	if complete {
+		relaysCopy := make([]string, len(relays))
+		copy(relaysCopy, relays)
+		outboxShortTermCacheMu.Lock()
+		outboxShortTermCache[ostcIndex] = ostcEntry{pubkey, relaysCopy, now}
+		outboxShortTermCacheMu.Unlock()
+	}
 
 	if len(relays) > n {
 		relays = relays[0:n]
@@ -84,3 +128,18 @@ func (sys *System) FetchWriteRelays(ctx context.Context, pubkey string, n int) [
 
 	return relays
 }
+
+// PublishToOutbox publishes evt to the "write" relays of its author, as given by FetchWriteRelays, and returns
+// a channel with the result from each relay, which is closed when all of them are done.
+func (sys *System) PublishToOutbox(ctx context.Context, evt nostr.Event) (<-chan nostr.PublishResult, error) {
+	if !nostr.IsValidPublicKey(evt.PubKey) {
+		return nil, fmt.Errorf("event has invalid pubkey '%s'", evt.PubKey)
+	}
+
+	relays := sys.FetchWriteRelays(ctx, evt.PubKey, 7)
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("no write relays found for %s", evt.PubKey)
+	}
+
+	return sys.Pool.PublishMany(ctx, relays, evt), nil
+}
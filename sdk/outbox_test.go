@@ -0,0 +1,104 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishToOutbox(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	relays := startTestRelays(t, 3)
+	write, both, read := relays[0], relays[1], relays[2]
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	relayList := nostr.Event{
+		Kind:      10002,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"r", write, "write"},
+			{"r", both},
+			{"r", read, "read"},
+		},
+	}
+	require.NoError(t, relayList.Sign(sk))
+
+	store := &slicestore.SliceStore{}
+	store.Init()
+	sys := NewSystem(WithStore(store))
+	defer sys.Close()
+	// the relay list is in our local store and was just refreshed, so it won't be fetched from the network
+	sys.StoreRelay.Publish(ctx, relayList)
+	sys.KVStore.Set(makeLastFetchKey(10002, pk), encodeTimestamp(nostr.Now()))
+
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello from my outbox"}
+	require.NoError(t, evt.Sign(sk))
+
+	results, err := sys.PublishToOutbox(ctx, evt)
+	require.NoError(t, err)
+
+	targeted := make([]string, 0, 2)
+	for res := range results {
+		require.NoError(t, res.Error)
+		targeted = append(targeted, res.RelayURL)
+	}
+	require.ElementsMatch(t, []string{nostr.NormalizeURL(write), nostr.NormalizeURL(both)}, targeted)
+
+	for _, url := range relays {
+		relay, err := nostr.RelayConnect(ctx, url)
+		require.NoError(t, err)
+		found, err := relay.QuerySync(ctx, nostr.Filter{IDs: []string{evt.ID}})
+		require.NoError(t, err)
+		require.Equal(t, url != read, len(found) == 1, "event presence on %s", url)
+		relay.Close()
+	}
+
+	_, err = sys.PublishToOutbox(ctx, nostr.Event{Kind: 1, PubKey: "invalid"})
+	require.Error(t, err)
+}
+
+func TestFetchOutboxRelaysCapAndTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// every relay that could have the relay list hangs
+	silent := make([]string, 5)
+	for i := range silent {
+		silent[i] = nostr.NormalizeURL(startSilentRelay(t))
+	}
+
+	sys := NewSystem(WithRelayListRelays(silent[0:1]))
+	defer sys.Close()
+	sys.OutboxRelayCount = 2
+	sys.OutboxFetchTimeout = 300 * time.Millisecond
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	for _, url := range silent {
+		sys.Hints.Save(pk, url, hints.LastInHint, nostr.Now())
+	}
+
+	start := time.Now()
+	relays := sys.FetchOutboxRelays(ctx, pk, sys.OutboxRelayCount)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Len(t, relays, 2)
+	require.Subset(t, silent, relays)
+
+	// the same applies when the caller's context ends earlier
+	sys.OutboxFetchTimeout = 0
+	shortCtx, shortCancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer shortCancel()
+	start = time.Now()
+	relays = sys.FetchOutboxRelays(shortCtx, pk, 4)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Len(t, relays, 4)
+}
@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// RelayPingResult is a nostr.PingResult plus the relay's NIP-11 document, if it could be fetched.
+type RelayPingResult struct {
+	nostr.PingResult
+	NIP11 *nip11.RelayInformationDocument
+}
+
+// PingRelays checks which of the given relays are alive with Pool.Ping and fetches their NIP-11 documents at the
+// same time. The results are keyed by normalized URL. Meant for "which of my relays are working" diagnostics
+// and relay pickers; dead relays are only reported, nothing is changed in the hints database.
+func (sys *System) PingRelays(ctx context.Context, urls []string) map[string]RelayPingResult {
+	// the NIP-11 fetches run tied to the pool, so they must end when it is shut down
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(sys.Pool.Context, cancel)()
+
+	infos := make(map[string]*nip11.RelayInformationDocument, len(urls))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	seen := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		nm := nostr.NormalizeURL(url)
+		if _, ok := seen[nm]; ok {
+			continue
+		}
+		seen[nm] = struct{}{}
+
+		wg.Add(1)
+		sys.Pool.Go(func() {
+			defer wg.Done()
+			info, err := nip11.Fetch(ctx, nm)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			infos[nm] = &info
+			mu.Unlock()
+		})
+	}
+
+	pings := sys.Pool.Ping(ctx, urls)
+	wg.Wait()
+
+	results := make(map[string]RelayPingResult, len(pings))
+	for url, ping := range pings {
+		results[url] = RelayPingResult{PingResult: ping, NIP11: infos[url]}
+	}
+	return results
+}
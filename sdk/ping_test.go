@@ -0,0 +1,41 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingRelays(t *testing.T) {
+	withInfo := startTestRelays(t, 1)[0]
+	withoutInfo := startSilentRelay(t)
+	dead := "ws://127.0.0.1:1"
+
+	sys := NewSystem()
+	defer sys.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results := sys.PingRelays(ctx, []string{withInfo, withoutInfo, dead})
+	require.Len(t, results, 3)
+
+	res := results[nostr.NormalizeURL(withInfo)]
+	require.NoError(t, res.Err)
+	require.True(t, res.Connected)
+	require.Greater(t, res.RTT, time.Duration(0))
+	require.NotNil(t, res.NIP11)
+
+	res = results[nostr.NormalizeURL(withoutInfo)]
+	require.NoError(t, res.Err)
+	require.True(t, res.Connected)
+	require.Nil(t, res.NIP11)
+
+	res = results[nostr.NormalizeURL(dead)]
+	require.Error(t, res.Err)
+	require.False(t, res.Connected)
+	require.Nil(t, res.NIP11)
+}
@@ -32,10 +32,10 @@ func fetchGenericSets[I TagItemWithValue](
 	lockIdx := (n + uint64(actualKind)) % 60
 	genericListMutexes[lockIdx].Lock()
 
-	if valueWasJustCached[lockIdx] {
+	if valueWasJustCached[lockIdx].Load() {
 		// this ensures the cache has had time to commit the values
 		// so we don't repeat a fetch immediately after the other
-		valueWasJustCached[lockIdx] = false
+		valueWasJustCached[lockIdx].Store(false)
 		time.Sleep(time.Millisecond * 10)
 	}
 
@@ -70,7 +70,7 @@ func fetchGenericSets[I TagItemWithValue](
 
 		// and finally save this to cache
 		cache.SetWithTTL(pubkey, v, time.Hour*6)
-		valueWasJustCached[lockIdx] = true
+		valueWasJustCached[lockIdx].Store(true)
 
 		return v, true
 	}
@@ -85,7 +85,7 @@ func fetchGenericSets[I TagItemWithValue](
 
 	// save cache even if we didn't get anything
 	cache.SetWithTTL(pubkey, v, time.Hour*6)
-	valueWasJustCached[lockIdx] = true
+	valueWasJustCached[lockIdx].Store(true)
 
 	return v, false
 }
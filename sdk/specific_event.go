@@ -1,6 +1,7 @@
 package sdk
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"slices"
@@ -20,6 +21,53 @@ type FetchSpecificEventParameters struct {
 	// SkipLocalStore indicates whether to skip checking the local store for the event
 	// and storing the result in the local store.
 	SkipLocalStore bool
+
+	// SkipProfilePrefetch prevents the profile metadata of the author of the event from being fetched in
+	// the background, which is wasted traffic for callers that won't display it.
+	SkipProfilePrefetch bool
+
+	// PreferConnectedRelays makes it query the relays the pool is already connected to first, only
+	// connecting to the other candidates if the event isn't found on those.
+	PreferConnectedRelays bool
+
+	// MaxSuccessRelays is the maximum number of relays returned as having the event (defaults to 10).
+	// when there are more than that, relays from the pointer or the author's outbox are preferred.
+	MaxSuccessRelays int
+
+	// ProbeKinds are the kinds searched for when an EntityPointer doesn't have a kind, in which case the
+	// newest event among all these kinds with the given author and "d" tag is returned (defaults to
+	// DefaultProbeKinds).
+	ProbeKinds []int
+
+	// RawEvent, if given, is set to the EVENT message in which the returned event came, exactly as the relay
+	// sent it, for when the event must be forwarded untouched. It is set to nil if the event came from a
+	// local store.
+	RawEvent *[]byte
+}
+
+// DefaultProbeKinds are the common addressable kinds FetchSpecificEvent looks for when it gets an
+// EntityPointer without a kind.
+var DefaultProbeKinds = []int{
+	nostr.KindArticle,
+	nostr.KindDraftArticle,
+	nostr.KindCategorizedPeopleList,
+	nostr.KindCategorizedBookmarksList,
+	nostr.KindRelaySets,
+	nostr.KindBookmarkSets,
+	nostr.KindCuratedSets,
+	nostr.KindCuratedVideoSets,
+	nostr.KindMuteSets,
+	nostr.KindProfileBadges,
+	nostr.KindBadgeDefinition,
+	nostr.KindInterestSets,
+	nostr.KindEmojiSets,
+	nostr.KindLiveEvent,
+	nostr.KindClassifiedListing,
+	nostr.KindWikiArticle,
+	nostr.KindDateCalendarEvent,
+	nostr.KindTimeCalendarEvent,
+	nostr.KindCalendar,
+	nostr.KindApplicationSpecificData,
 }
 
 // FetchSpecificEventFromInput tries to get a specific event from a NIP-19 code or event ID.
@@ -29,162 +77,540 @@ func (sys *System) FetchSpecificEventFromInput(
 	input string,
 	params FetchSpecificEventParameters,
 ) (event *nostr.Event, successRelays []string, err error) {
-	var pointer nostr.Pointer
+	pointer, err := inputToSpecificPointer(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sys.FetchSpecificEvent(ctx, pointer, params)
+}
+
+// FetchSpecificEventsParameters contains options for fetching many specific events at once.
+type FetchSpecificEventsParameters struct {
+	FetchSpecificEventParameters
+
+	// MaxConcurrentRelays is the maximum number of relay subscriptions that can be open at the same time
+	// across the whole batch (defaults to 20).
+	MaxConcurrentRelays int
+}
 
+// SpecificEventResult is the outcome of fetching one of the inputs given to FetchSpecificEventsFromInput.
+type SpecificEventResult struct {
+	Input         string
+	Event         *nostr.Event
+	SuccessRelays []string
+	Err           error
+}
+
+// FetchSpecificEventsFromInput is like FetchSpecificEventFromInput, but for many inputs at the same time,
+// without opening more than params.MaxConcurrentRelays relay subscriptions at once.
+//
+// Results are emitted as they are ready, one for each input, and the channel is closed after the last one.
+func (sys *System) FetchSpecificEventsFromInput(
+	ctx context.Context,
+	inputs []string,
+	params FetchSpecificEventsParameters,
+) chan SpecificEventResult {
+	maxConcurrentRelays := params.MaxConcurrentRelays
+	if maxConcurrentRelays <= 0 {
+		maxConcurrentRelays = 20
+	}
+	limiter := newRelayLimiter(maxConcurrentRelays)
+
+	results := make(chan SpecificEventResult, len(inputs))
+	wg := sync.WaitGroup{}
+	wg.Add(len(inputs))
+	for _, input := range inputs {
+		go func() {
+			defer wg.Done()
+
+			res := SpecificEventResult{Input: input}
+			if pointer, err := inputToSpecificPointer(input); err != nil {
+				res.Err = err
+			} else {
+				res.Event, res.SuccessRelays, res.Err = sys.fetchSpecificEvent(ctx, pointer, params.FetchSpecificEventParameters, limiter)
+			}
+			results <- res
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// inputToSpecificPointer decodes a nevent, naddr or note code or a hex event id into a Pointer.
+func inputToSpecificPointer(input string) (nostr.Pointer, error) {
 	prefix, data, err := nip19.Decode(input)
 	if err == nil {
 		switch prefix {
 		case "nevent":
-			pointer = data.(nostr.EventPointer)
+			return data.(nostr.EventPointer), nil
 		case "naddr":
-			pointer = data.(nostr.EntityPointer)
+			return data.(nostr.EntityPointer), nil
 		case "note":
-			pointer = nostr.EventPointer{ID: data.(string)}
+			return nostr.EventPointer{ID: data.(string)}, nil
 		default:
-			return nil, nil, fmt.Errorf("invalid code '%s'", input)
-		}
-	} else {
-		if nostr.IsValid32ByteHex(input) {
-			pointer = nostr.EventPointer{ID: input}
-		} else {
-			return nil, nil, fmt.Errorf("failed to decode '%s': %w", input, err)
+			return nil, fmt.Errorf("invalid code '%s'", input)
 		}
 	}
 
-	return sys.FetchSpecificEvent(ctx, pointer, params)
+	if nostr.IsValid32ByteHex(input) {
+		return nostr.EventPointer{ID: input}, nil
+	}
+	return nil, fmt.Errorf("failed to decode '%s': %w", input, err)
 }
 
 // FetchSpecificEvent tries to get a specific event using a Pointer (EventPointer or EntityPointer).
 // It first checks the local store, then queries relays associated with the event or author,
 // and finally falls back to general-purpose relays.
+//
+// If ctx ends before the fetch is complete the event found so far (if any) is still returned, along with
+// an error wrapping ctx.Err().
 func (sys *System) FetchSpecificEvent(
 	ctx context.Context,
 	pointer nostr.Pointer,
 	params FetchSpecificEventParameters,
+) (event *nostr.Event, successRelays []string, err error) {
+	return sys.fetchSpecificEvent(ctx, pointer, params, nil)
+}
+
+// fetchSpecificEvent is FetchSpecificEvent, but with a limiter that is shared between many calls so they
+// don't have too many relay subscriptions open at the same time (if limiter is nil there is no limit).
+func (sys *System) fetchSpecificEvent(
+	ctx context.Context,
+	pointer nostr.Pointer,
+	params FetchSpecificEventParameters,
+	limiter *relayLimiter,
 ) (event *nostr.Event, successRelays []string, err error) {
 	// this is for deciding what relays will go on nevent and nprofile later
 	priorityRelays := make([]string, 0, 8)
 
+	maxSuccessRelays := params.MaxSuccessRelays
+	if maxSuccessRelays <= 0 {
+		maxSuccessRelays = 10
+	}
+
+	subOpts := make([]nostr.SubscriptionOption, 0, 2)
+	if params.RawEvent != nil {
+		*params.RawEvent = nil
+		subOpts = append(subOpts, nostr.WithRawEnvelopes{})
+	}
+
 	var filter nostr.Filter
+	matches := pointer.MatchesEvent
+	probing := false
 	author := ""
-	relays := make([]string, 0, 10)
-	fallback := make([]string, 0, 10)
-	successRelays = make([]string, 0, 10)
+	sources := make([]RelaySource, 0, 3)
+	var fallback []string
+	successRelays = make([]string, 0, maxSuccessRelays)
 
 	switch v := pointer.(type) {
 	case nostr.EventPointer:
 		author = v.Author
 		filter.IDs = []string{v.ID}
-		relays = append(relays, v.Relays...)
-		relays = appendUnique(relays, sys.FallbackRelays.Next())
-		fallback = append(fallback, sys.JustIDRelays.URLs...)
-		fallback = appendUnique(fallback, sys.FallbackRelays.Next())
+		sources = append(sources, RelaySource{URLs: v.Relays, Priority: 2})
+		fallback = sys.fallbackRelays(sys.EventPointerFallback)
 		priorityRelays = append(priorityRelays, v.Relays...)
 	case nostr.EntityPointer:
 		author = v.PublicKey
 		filter.Authors = []string{v.PublicKey}
 		filter.Tags = nostr.TagMap{"d": []string{v.Identifier}}
 		filter.Kinds = []int{v.Kind}
-		relays = append(relays, v.Relays...)
-		relays = appendUnique(relays, sys.FallbackRelays.Next())
-		fallback = append(fallback, sys.FallbackRelays.Next(), sys.FallbackRelays.Next())
+		if v.Kind == 0 {
+			// a best-effort lookup for when we don't know the kind
+			probing = true
+			filter.Kinds = params.ProbeKinds
+			if len(filter.Kinds) == 0 {
+				filter.Kinds = DefaultProbeKinds
+			}
+			matches = func(evt nostr.Event) bool { return filter.Matches(&evt) }
+		}
+		sources = append(sources, RelaySource{URLs: v.Relays, Priority: 2})
+		fallback = sys.fallbackRelays(sys.EntityPointerFallback)
 		priorityRelays = append(priorityRelays, v.Relays...)
 	}
+	sources = append(sources, RelaySource{URLs: []string{sys.FallbackRelays.Next()}})
 
-	// try to fetch in our internal eventstore first
+	// try to fetch in our internal eventstores first
 	if !params.SkipLocalStore {
-		if res, _ := sys.StoreRelay.QuerySync(ctx, filter); len(res) != 0 {
-			evt := res[0]
-			return evt, nil, nil
+		if evt := sys.queryLocalStores(ctx, filter); evt != nil {
+			if params.WithRelays {
+				successRelays = sys.knownEventRelays(evt, maxSuccessRelays)
+			}
+			return evt, successRelays, nil
 		}
 	}
 
 	if author != "" {
 		// fetch relays for author
-		authorRelays := sys.FetchOutboxRelays(ctx, author, 3)
+		authorRelays := sys.FetchOutboxRelays(ctx, author, sys.OutboxRelayCount)
 
 		// after that we register these hints as associated with author
 		// (we do this after fetching author outbox relays because we are already going to prioritize these hints)
 		now := nostr.Now()
-		for _, relay := range priorityRelays {
-			sys.Hints.Save(author, nostr.NormalizeURL(relay), hints.LastInHint, now)
+		relayHints := sys.withoutBlockedRelays(priorityRelays)
+		batch := make([]hints.HintEntry, len(relayHints))
+		for i, relay := range relayHints {
+			batch[i] = hints.HintEntry{PubKey: author, Relay: nostr.NormalizeURL(relay), Key: hints.LastInHint, When: now}
 		}
+		sys.Hints.SaveBatch(batch)
 
 		// arrange these
-		relays = appendUnique(relays, authorRelays...)
+		sources = append(sources, RelaySource{URLs: authorRelays, Priority: 1})
 		priorityRelays = appendUnique(priorityRelays, authorRelays...)
 	}
 
-	var result *nostr.Event
-	fetchProfileOnce := sync.Once{}
+	relays := mergeRelaySources(sources...)
 
-attempts:
-	for _, attempt := range []struct {
+	type attempt struct {
 		label          string
 		relays         []string
 		slowWithRelays bool
-	}{
-		{
+	}
+	attempts := make([]attempt, 0, 3)
+	if params.PreferConnectedRelays {
+		// try the relays we're already connected to first and only connect to new ones if needed
+		connected, rest := splitConnected(relays, sys.Pool.ConnectedRelays())
+		if len(connected) > 0 && len(rest) > 0 {
+			attempts = append(attempts, attempt{
+				label:          "fetchspecific",
+				relays:         connected,
+				slowWithRelays: params.WithRelays || probing,
+			})
+			relays = rest
+		}
+	}
+	attempts = append(attempts,
+		attempt{
 			label:  "fetchspecific",
 			relays: relays,
 			// set this to true if the caller wants relays, so we won't return immediately
 			//   but will instead wait a little while to see if more relays respond
-			slowWithRelays: params.WithRelays,
+			// (same thing when probing kinds, since then we want the newest among all that match)
+			slowWithRelays: params.WithRelays || probing,
 		},
-		{
+		attempt{
 			label:          "fetchspecific",
 			relays:         fallback,
-			slowWithRelays: false,
+			slowWithRelays: probing,
 		},
-	} {
+	)
+
+	// the hints may come from anywhere, so this is the last chance to keep blocked relays out
+	for i := range attempts {
+		attempts[i].relays = sys.withoutBlockedRelays(attempts[i].relays)
+	}
+
+	if limiter != nil {
+		// an attempt can't use more relays at once than the limiter allows, so bigger ones are done in batches
+		batched := make([]attempt, 0, len(attempts))
+		for _, a := range attempts {
+			for chunk := range slices.Chunk(a.relays, limiter.size()) {
+				batched = append(batched, attempt{label: a.label, relays: chunk, slowWithRelays: a.slowWithRelays})
+			}
+		}
+		attempts = batched
+	}
+
+	var result *nostr.Event
+	var resultRaw []byte
+	fetchProfileOnce := sync.Once{}
+
+	for _, attempt := range attempts {
 		// actually fetch the event here
 		countdown := 6.0
-		subManyCtx := ctx
+		subManyCtx, cancel := context.WithCancel(ctx)
+		held, err := limiter.acquire(subManyCtx, len(attempt.relays))
+		if err != nil {
+			cancel()
+			break
+		}
 
-		for ie := range sys.Pool.FetchMany(
+		done := false
+		hadIt := make(map[string]struct{}, len(attempt.relays))
+		endedMu := sync.Mutex{}
+		ended := make([]string, 0, len(attempt.relays))
+		results := sys.Pool.FetchMany(
 			subManyCtx,
 			attempt.relays,
 			filter,
-			nostr.WithLabel(attempt.label),
-		) {
+			append(subOpts,
+				nostr.WithLabel(attempt.label),
+				nostr.WithEOSEHandler(func(url string) {
+					endedMu.Lock()
+					ended = append(ended, url)
+					endedMu.Unlock()
+				}),
+			)...,
+		)
+		for ie := range results {
+			// a buggy relay could send us something else
+			if !matches(*ie.Event) {
+				continue
+			}
+
 			fetchProfileOnce.Do(func() {
-				go sys.FetchProfileMetadata(ctx, ie.PubKey)
+				// this goroutine is bound to ctx, so don't even start it if we're already done
+				if !params.SkipProfilePrefetch && ctx.Err() == nil {
+					pubkey := ie.PubKey
+					sys.Pool.Go(func() { sys.FetchProfileMetadata(ctx, pubkey) })
+				}
 			})
 
-			successRelays = append(successRelays, ie.Relay.URL)
+			hadIt[ie.Relay.URL] = struct{}{}
+			successRelays = addSuccessRelay(successRelays, ie.Relay.URL, priorityRelays, maxSuccessRelays)
 			if result == nil || ie.CreatedAt > result.CreatedAt {
 				result = ie.Event
+				resultRaw = ie.RawEnvelope
 			}
 
 			if !attempt.slowWithRelays {
-				break attempts
+				done = true
+				break
 			}
 
 			countdown = min(countdown-0.5, 1)
 		}
+
+		// if we went all the way to the end then every relay from the author's hints that got to the end of its
+		// stored events without sending the event didn't have it (relays that failed don't tell us anything)
+		cancel()
+		if !done && author != "" {
+			var known []string
+			if result != nil {
+				// these may have sent it too, but it was ignored for being a duplicate
+				known, _ = sys.GetEventRelays(result.ID)
+			}
+
+			now := nostr.Now()
+			endedMu.Lock()
+			batch := make([]hints.HintEntry, 0, len(ended))
+			for _, url := range ended {
+				if _, ok := hadIt[url]; ok || slices.Contains(known, url) {
+					continue
+				}
+				if !slices.ContainsFunc(priorityRelays, func(r string) bool { return nostr.NormalizeURL(r) == url }) {
+					continue
+				}
+				batch = append(batch, hints.HintEntry{PubKey: author, Relay: url, Key: hints.NegativeHint, When: now})
+			}
+			endedMu.Unlock()
+			sys.Hints.SaveBatch(batch)
+		}
+		if limiter != nil {
+			// wait for the subscriptions to be actually closed before letting others open new ones
+			for range results {
+			}
+			limiter.release(held)
+		}
+
+		if done {
+			break
+		}
 	}
 
 	if result == nil {
-		return nil, nil, fmt.Errorf("couldn't find this %v", pointer)
+		if err := ctx.Err(); err != nil {
+			return nil, nil, fmt.Errorf("couldn't find this %s: %w", pointer.AsTagReference(), err)
+		}
+		return nil, nil, fmt.Errorf("couldn't find this %s", pointer.AsTagReference())
 	}
 
-	// save stuff in cache and in internal store
-	if !params.SkipLocalStore {
+	// save stuff in cache and in internal store (ephemeral events are not supposed to be stored)
+	if !params.SkipLocalStore && !nostr.IsEphemeralKind(result.Kind) {
 		sys.StoreRelay.Publish(ctx, *result)
 	}
 
-	// put priority relays first so they get used in nevent and nprofile
-	slices.SortFunc(successRelays, func(a, b string) int {
-		vpa := slices.Contains(priorityRelays, a)
-		vpb := slices.Contains(priorityRelays, b)
-		if vpa == vpb {
-			return 1
+	// put the best relays first so they get used in nevent and nprofile
+	sys.sortSuccessRelays(successRelays, priorityRelays, author)
+
+	if params.RawEvent != nil {
+		*params.RawEvent = resultRaw
+	}
+
+	// we have a result, but if the context ended before we were done it may be incomplete (not the newest
+	// version, or missing relays) -- still return it so callers can have some best-effort data
+	if err := ctx.Err(); err != nil {
+		return result, successRelays, fmt.Errorf("interrupted while fetching %s: %w", pointer.AsTagReference(), err)
+	}
+
+	return result, successRelays, nil
+}
+
+// sortSuccessRelays puts the relays that were prioritized for this fetch (from the pointer or the author's
+// outbox) before the others and, within each of these groups, the ones our hints rank higher for author.
+// Relays that can't be told apart keep the order in which they gave us the event.
+func (sys *System) sortSuccessRelays(relays []string, priorityRelays []string, author string) {
+	var ranking []string
+	if author != "" {
+		ranking = sys.Hints.TopN(author, 100)
+	}
+	rank := func(url string) int {
+		if idx := slices.Index(ranking, url); idx != -1 {
+			return idx
 		}
-		if vpa && !vpb {
+		return len(ranking)
+	}
+
+	slices.SortStableFunc(relays, func(a, b string) int {
+		pa := slices.Contains(priorityRelays, a)
+		pb := slices.Contains(priorityRelays, b)
+		if pa && !pb {
+			return -1
+		}
+		if !pa && pb {
 			return 1
 		}
-		return -1
+		return cmp.Compare(rank(a), rank(b))
 	})
+}
 
-	return result, successRelays, nil
+// knownEventRelays returns the relays we have seen evt on or, if we don't know any, the relays its
+// author is most likely to be found on, according to our hints.
+func (sys *System) knownEventRelays(evt *nostr.Event, max int) []string {
+	relays, _ := sys.GetEventRelays(evt.ID)
+	if len(relays) == 0 {
+		relays = sys.Hints.TopN(evt.PubKey, max)
+	}
+	if len(relays) > max {
+		relays = relays[0:max]
+	}
+	return relays
+}
+
+// withoutBlockedRelays returns relays without the ones in BlockedRelays.
+func (sys *System) withoutBlockedRelays(relays []string) []string {
+	if len(sys.BlockedRelays) == 0 {
+		return relays
+	}
+	return slices.DeleteFunc(slices.Clone(relays), func(url string) bool {
+		_, blocked := sys.BlockedRelays[nostr.NormalizeURL(url)]
+		return blocked
+	})
+}
+
+// splitConnected separates relays into the ones that are in connected and the others, keeping their order.
+func splitConnected(relays []string, connected []string) (first []string, rest []string) {
+	first = make([]string, 0, len(relays))
+	rest = make([]string, 0, len(relays))
+	for _, url := range relays {
+		if slices.Contains(connected, url) {
+			first = append(first, url)
+		} else {
+			rest = append(rest, url)
+		}
+	}
+	return first, rest
+}
+
+// addSuccessRelay appends url to relays unless it's already there or the list is full, in which case url can
+// still take the place of a relay that isn't a priority one if it is.
+func addSuccessRelay(relays []string, url string, priorityRelays []string, max int) []string {
+	if slices.Contains(relays, url) {
+		return relays
+	}
+	if len(relays) < max {
+		return append(relays, url)
+	}
+
+	if slices.Contains(priorityRelays, url) {
+		if idx := slices.IndexFunc(relays, func(r string) bool { return !slices.Contains(priorityRelays, r) }); idx != -1 {
+			relays[idx] = url
+		}
+	}
+	return relays
+}
+
+// fallbackRelays builds the list of relays to be tried last according to fc.
+func (sys *System) fallbackRelays(fc FallbackComposition) []string {
+	justID := RelaySource{Priority: 1}
+	if fc.UseJustIDRelays {
+		justID.URLs = sys.JustIDRelays.URLs
+	}
+	fallback := RelaySource{URLs: make([]string, fc.FallbackRelayCount)}
+	for i := range fallback.URLs {
+		fallback.URLs[i] = sys.FallbackRelays.Next()
+	}
+	if fc.FallbackRelaysFirst {
+		fallback.Priority = 2
+	}
+
+	return mergeRelaySources(justID, fallback)
+}
+
+// queryLocalStores checks StoreRelay and then each of the LocalStores in order, returning the
+// first event found.
+func (sys *System) queryLocalStores(ctx context.Context, filter nostr.Filter) *nostr.Event {
+	stores := make([]nostr.RelayStore, 0, 1+len(sys.LocalStores))
+	stores = append(stores, sys.StoreRelay)
+	stores = append(stores, sys.LocalStores...)
+
+	for i, store := range stores {
+		res, _ := store.QuerySync(ctx, filter)
+		if len(res) == 0 {
+			continue
+		}
+
+		evt := res[0]
+		if sys.BackfillLocalStores {
+			for _, faster := range stores[0:i] {
+				faster.Publish(ctx, *evt)
+			}
+		}
+		return evt
+	}
+
+	return nil
+}
+
+// relayLimiter bounds the number of relay subscriptions open at the same time across many fetches.
+type relayLimiter struct {
+	tokens chan struct{}
+
+	// only one caller at a time can be collecting tokens, otherwise two of them could each hold some and
+	// wait forever for the others
+	mu sync.Mutex
+}
+
+func newRelayLimiter(max int) *relayLimiter {
+	return &relayLimiter{tokens: make(chan struct{}, max)}
+}
+
+// size is the maximum number of relay subscriptions that can be open at the same time.
+func (l *relayLimiter) size() int {
+	return cap(l.tokens)
+}
+
+// acquire waits until n relay subscriptions can be opened and returns how many were reserved, which must be
+// given back to release later. n can't be more than size().
+func (l *relayLimiter) acquire(ctx context.Context, n int) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	if n > l.size() {
+		return 0, fmt.Errorf("can't hold %d relays at once, the limit is %d", n, l.size())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		select {
+		case l.tokens <- struct{}{}:
+		case <-ctx.Done():
+			l.release(i)
+			return 0, ctx.Err()
+		}
+	}
+	return n, nil
+}
+
+func (l *relayLimiter) release(n int) {
+	for i := 0; i < n; i++ {
+		<-l.tokens
+	}
 }
@@ -0,0 +1,797 @@
+package sdk
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk/hints"
+	"github.com/nbd-wtf/go-nostr/sdk/hints/memoryh"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type mockStore struct {
+	events  []*nostr.Event
+	queries int
+}
+
+func (m *mockStore) Publish(_ context.Context, evt nostr.Event) error {
+	m.events = append(m.events, &evt)
+	return nil
+}
+
+func (m *mockStore) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	res, _ := m.QuerySync(ctx, filter)
+	ch := make(chan *nostr.Event, len(res))
+	for _, evt := range res {
+		ch <- evt
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (m *mockStore) QuerySync(_ context.Context, filter nostr.Filter) ([]*nostr.Event, error) {
+	m.queries++
+	res := make([]*nostr.Event, 0, 1)
+	for _, evt := range m.events {
+		if filter.Matches(evt) {
+			res = append(res, evt)
+		}
+	}
+	return res, nil
+}
+
+func TestFetchSpecificEventLocalStores(t *testing.T) {
+	ctx := context.Background()
+
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "stored"}
+	evt.Sign(sk)
+
+	fast := &mockStore{}
+	slow := &mockStore{events: []*nostr.Event{&evt}}
+
+	sys := NewSystem(WithLocalStores(fast, slow))
+	defer sys.Close()
+
+	res, _, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt.ID}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+	require.Equal(t, 1, fast.queries)
+	require.Equal(t, 1, slow.queries)
+	require.Empty(t, fast.events, "shouldn't backfill unless asked to")
+
+	sys.BackfillLocalStores = true
+	res, _, err = sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt.ID}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+	require.Len(t, fast.events, 1, "event should have been copied to the faster store")
+
+	// now the fast store has it so the slow one isn't even checked
+	res, _, err = sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt.ID}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+	require.Equal(t, 2, slow.queries)
+}
+
+func TestFetchSpecificEventDoesntStoreEphemeral(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	ephemeral := nostr.Event{Kind: 20001, CreatedAt: nostr.Now(), Content: "gone soon"}
+	ephemeral.Sign(sk)
+	regular := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "here to stay"}
+	regular.Sign(sk)
+
+	url := startFakeRelay(t, ephemeral, regular)
+
+	store := &slicestore.SliceStore{}
+	store.Init()
+	defer store.Close()
+	sys := NewSystem(
+		WithStore(store),
+		WithFallbackRelays([]string{url}),
+		WithJustIDRelays([]string{url}),
+	)
+	defer sys.Close()
+
+	// the profile prefetch would query the store while the fetch is writing to it
+	for _, evt := range []nostr.Event{ephemeral, regular} {
+		res, _, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt.ID, Relays: []string{url}}, FetchSpecificEventParameters{SkipProfilePrefetch: true})
+		require.NoError(t, err)
+		require.Equal(t, evt.ID, res.ID)
+	}
+
+	stored, err := sys.StoreRelay.QuerySync(ctx, nostr.Filter{IDs: []string{ephemeral.ID}})
+	require.NoError(t, err)
+	require.Empty(t, stored, "ephemeral event shouldn't have been stored")
+
+	stored, err = sys.StoreRelay.QuerySync(ctx, nostr.Filter{IDs: []string{regular.ID}})
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+}
+
+func TestFetchSpecificEventPartialResultOnTimeout(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "found it"}
+	evt.Sign(sk)
+
+	fast := startFakeRelay(t, evt)
+	slow := startSilentRelay(t)
+
+	sys := NewSystem(
+		WithFallbackRelays([]string{fast}),
+		WithJustIDRelays([]string{fast}),
+	)
+	defer sys.Close()
+
+	// the fast relay answers immediately, but since we want all the relays we'll keep waiting on the
+	// slow one until the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	res, relays, err := sys.FetchSpecificEvent(ctx,
+		nostr.EventPointer{ID: evt.ID, Relays: []string{fast, slow}},
+		FetchSpecificEventParameters{WithRelays: true, SkipLocalStore: true},
+	)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NotNil(t, res)
+	require.Equal(t, evt.ID, res.ID)
+	require.Contains(t, relays, nostr.NormalizeURL(fast))
+
+	// nothing to return at all
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, _, err = sys.FetchSpecificEvent(ctx,
+		nostr.EventPointer{ID: "a3e9c9d3f8d26a9d0e82e5e1f0c8d6e93a3a5ac8b1b45e7f7d3c2ee1b6b7c9d0", Relays: []string{slow}},
+		FetchSpecificEventParameters{SkipLocalStore: true},
+	)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFetchSpecificEventMaxSuccessRelays(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	// every relay has a different version of the same article, so all of them respond with something
+	relays := make([]string, 50)
+	for i := range relays {
+		evt := nostr.Event{
+			Kind:      30023,
+			CreatedAt: nostr.Now() - nostr.Timestamp(i),
+			Tags:      nostr.Tags{{"d", "article"}},
+			Content:   "version",
+		}
+		evt.Sign(sk)
+		relays[i] = startFakeRelay(t, evt)
+	}
+
+	sys := NewSystem(WithFallbackRelays([]string{relays[0]}))
+	defer sys.Close()
+
+	pointer := nostr.EntityPointer{PublicKey: pk, Kind: 30023, Identifier: "article", Relays: relays}
+
+	res, successRelays, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{WithRelays: true, SkipLocalStore: true})
+	require.NoError(t, err)
+	require.Equal(t, "version", res.Content)
+	require.Len(t, successRelays, 10)
+
+	_, successRelays, err = sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{WithRelays: true, SkipLocalStore: true, MaxSuccessRelays: 3})
+	require.NoError(t, err)
+	require.Len(t, successRelays, 3)
+}
+
+func TestAddSuccessRelay(t *testing.T) {
+	priority := []string{"wss://p1", "wss://p2"}
+
+	relays := make([]string, 0, 2)
+	relays = addSuccessRelay(relays, "wss://a", priority, 2)
+	relays = addSuccessRelay(relays, "wss://a", priority, 2)
+	relays = addSuccessRelay(relays, "wss://b", priority, 2)
+	require.Equal(t, []string{"wss://a", "wss://b"}, relays)
+
+	// full, normal relays are ignored
+	relays = addSuccessRelay(relays, "wss://c", priority, 2)
+	require.Equal(t, []string{"wss://a", "wss://b"}, relays)
+
+	// but priority relays take the place of normal ones
+	relays = addSuccessRelay(relays, "wss://p1", priority, 2)
+	relays = addSuccessRelay(relays, "wss://p2", priority, 2)
+	require.Equal(t, []string{"wss://p1", "wss://p2"}, relays)
+
+	relays = addSuccessRelay(relays, "wss://d", priority, 2)
+	require.Equal(t, []string{"wss://p1", "wss://p2"}, relays)
+}
+
+func TestFetchSpecificEventDiscardsMismatchedEntity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	wrongD := nostr.Event{Kind: 30023, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"d", "other"}}, Content: "wrong"}
+	wrongD.Sign(sk)
+	wrongKind := nostr.Event{Kind: 30024, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"d", "article"}}, Content: "wrong"}
+	wrongKind.Sign(sk)
+	right := nostr.Event{Kind: 30023, CreatedAt: nostr.Now() - 10, Tags: nostr.Tags{{"d", "article"}}, Content: "right"}
+	right.Sign(sk)
+
+	liar := startLyingRelay(t, wrongD, wrongKind)
+	honest := startFakeRelay(t, right)
+
+	sys := NewSystem(WithFallbackRelays([]string{liar}))
+	defer sys.Close()
+
+	pointer := nostr.EntityPointer{PublicKey: pk, Kind: 30023, Identifier: "article", Relays: []string{liar, honest}}
+	// no profile prefetch, it would race with the fetch on the store
+	res, relays, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{WithRelays: true, SkipLocalStore: true, SkipProfilePrefetch: true})
+	require.NoError(t, err)
+	require.Equal(t, right.ID, res.ID)
+	require.Equal(t, []string{nostr.NormalizeURL(honest)}, relays)
+
+	// nothing that matches this one exists anywhere
+	pointer.Identifier = "nothing"
+	_, _, err = sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{SkipLocalStore: true, SkipProfilePrefetch: true})
+	require.Error(t, err)
+}
+
+func TestFetchSpecificEventPreferConnectedRelays(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(sk)
+
+	connected := nostr.NormalizeURL(startFakeRelay(t, evt))
+	other := nostr.NormalizeURL(startSilentRelay(t))
+
+	first, rest := splitConnected([]string{other, connected, "wss://x.com"}, []string{connected})
+	require.Equal(t, []string{connected}, first)
+	require.Equal(t, []string{other, "wss://x.com"}, rest)
+
+	sys := NewSystem(WithFallbackRelays([]string{other}), WithJustIDRelays([]string{other}))
+	defer sys.Close()
+	_, err := sys.Pool.EnsureRelay(connected)
+	require.NoError(t, err)
+
+	res, _, err := sys.FetchSpecificEvent(ctx,
+		nostr.EventPointer{ID: evt.ID, Relays: []string{other, connected}},
+		FetchSpecificEventParameters{PreferConnectedRelays: true, SkipLocalStore: true},
+	)
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+
+	// the event was found on the relay we were already connected to, so we didn't connect to the other
+	require.Equal(t, []string{connected}, sys.Pool.ConnectedRelays())
+}
+
+func TestFetchSpecificEventStoreHitWithRelays(t *testing.T) {
+	ctx := context.Background()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	tracked := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "tracked"}
+	tracked.Sign(sk)
+	untracked := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "untracked"}
+	untracked.Sign(sk)
+
+	sys := NewSystem(WithLocalStores(&mockStore{events: []*nostr.Event{&tracked, &untracked}}))
+	defer sys.Close()
+
+	sys.trackEventRelay(tracked.ID, "wss://seen.relay", false)
+	sys.Hints.Save(pk, "wss://author.relay", hints.LastInRelayList, nostr.Now())
+
+	// without asking for relays we get none
+	res, relays, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: tracked.ID}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Equal(t, tracked.ID, res.ID)
+	require.Empty(t, relays)
+
+	// the relays where we've seen the event
+	res, relays, err = sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: tracked.ID}, FetchSpecificEventParameters{WithRelays: true})
+	require.NoError(t, err)
+	require.Equal(t, tracked.ID, res.ID)
+	require.Equal(t, []string{"wss://seen.relay"}, relays)
+
+	// when we haven't seen it anywhere we use the hints for the author
+	res, relays, err = sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: untracked.ID}, FetchSpecificEventParameters{WithRelays: true})
+	require.NoError(t, err)
+	require.Equal(t, untracked.ID, res.ID)
+	require.Equal(t, []string{"wss://author.relay"}, relays)
+}
+
+func TestFetchSpecificEventSkipProfilePrefetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	evt1 := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "one"}
+	evt1.Sign(sk)
+	evt2 := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "two"}
+	evt2.Sign(sk)
+
+	requestedProfile := func(filters []nostr.Filter) bool {
+		for _, filter := range filters {
+			if slices.Contains(filter.Kinds, 0) {
+				return true
+			}
+		}
+		return false
+	}
+
+	url, received := startRecordingRelay(t, evt1, evt2)
+	relays := []string{url}
+	newSystem := func() *System {
+		return NewSystem(
+			WithFallbackRelays(relays),
+			WithJustIDRelays(relays),
+			WithMetadataRelays(relays),
+			WithRelayListRelays(relays),
+		)
+	}
+
+	sys := newSystem()
+	defer sys.Close()
+	_, _, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt1.ID, Relays: relays}, FetchSpecificEventParameters{SkipProfilePrefetch: true})
+	require.NoError(t, err)
+	time.Sleep(1500 * time.Millisecond)
+	require.False(t, requestedProfile(received()), "shouldn't have fetched the profile")
+
+	// without the flag the profile is fetched in the background
+	sys = newSystem()
+	defer sys.Close()
+	_, _, err = sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: evt2.ID, Relays: relays}, FetchSpecificEventParameters{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return requestedProfile(received()) }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestFetchSpecificEventProbesKinds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	older := nostr.Event{Kind: 30000, CreatedAt: nostr.Now() - 100, Tags: nostr.Tags{{"d", "thing"}}, Content: "list"}
+	older.Sign(sk)
+	newer := nostr.Event{Kind: 30023, CreatedAt: nostr.Now() - 10, Tags: nostr.Tags{{"d", "thing"}}, Content: "article"}
+	newer.Sign(sk)
+	otherD := nostr.Event{Kind: 30023, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"d", "other"}}, Content: "other"}
+	otherD.Sign(sk)
+
+	// the relay sends the older one first, but we should still get the newest
+	relay, received := startRecordingRelay(t, older, newer, otherD)
+
+	sys := NewSystem(WithFallbackRelays([]string{relay}))
+	defer sys.Close()
+
+	pointer := nostr.EntityPointer{PublicKey: pk, Identifier: "thing", Relays: []string{relay}}
+	res, _, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{SkipLocalStore: true})
+	require.NoError(t, err)
+	require.Equal(t, newer.ID, res.ID)
+
+	// all kinds were probed in a single filter
+	filters := received()
+	require.NotEmpty(t, filters)
+	require.ElementsMatch(t, DefaultProbeKinds, filters[0].Kinds)
+
+	// with a custom set of kinds
+	res, _, err = sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{SkipLocalStore: true, ProbeKinds: []int{30000}})
+	require.NoError(t, err)
+	require.Equal(t, older.ID, res.ID)
+}
+
+func TestFetchSpecificEventsFromInputMaxConcurrentRelays(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+
+	// counts how many REQs are being handled at the same time across all relays. relays that have the
+	// event answer later than the ones that don't, so when a fetch stops at the first result (and lets
+	// another one start) the other relays it asked are already done
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	onReq := func(evt nostr.Event) func(nostr.Filters) {
+		return func(filters nostr.Filters) {
+			mu.Lock()
+			active++
+			maxActive = max(maxActive, active)
+			mu.Unlock()
+
+			if filters.Match(&evt) {
+				time.Sleep(100 * time.Millisecond)
+			} else {
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}
+	}
+
+	events := make([]nostr.Event, 8)
+	relays := make([]string, len(events))
+	for i := range events {
+		events[i] = nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: strconv.Itoa(i)}
+		events[i].Sign(sk)
+		relays[i] = startFakeRelayWithMatcher(t, nostr.Filters.Match, onReq(events[i]), events[i])
+	}
+
+	inputs := make([]string, len(events))
+	for i, evt := range events {
+		inputs[i], _ = nip19.EncodeEvent(evt.ID, []string{relays[i], relays[(i+1)%len(relays)]}, "")
+	}
+	inputs = append(inputs, "invalid")
+
+	sys := NewSystem(WithFallbackRelays(relays), WithJustIDRelays(relays))
+	defer sys.Close()
+
+	found := make([]string, 0, len(events))
+	for res := range sys.FetchSpecificEventsFromInput(ctx, inputs, FetchSpecificEventsParameters{
+		FetchSpecificEventParameters: FetchSpecificEventParameters{SkipLocalStore: true, SkipProfilePrefetch: true},
+		MaxConcurrentRelays:          3,
+	}) {
+		if res.Input == "invalid" {
+			require.Error(t, res.Err)
+			continue
+		}
+		require.NoError(t, res.Err)
+		found = append(found, res.Event.ID)
+	}
+
+	ids := make([]string, len(events))
+	for i, evt := range events {
+		ids[i] = evt.ID
+	}
+	require.ElementsMatch(t, ids, found)
+	require.LessOrEqual(t, maxActive, 3)
+	require.Greater(t, maxActive, 0)
+}
+
+func TestFetchSpecificEventsFromInputBatchesRelays(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	// counts how many REQs are being handled at the same time across all relays
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	onReq := func(nostr.Filters) {
+		mu.Lock()
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}
+
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "at the end"}
+	evt.Sign(nostr.GeneratePrivateKey())
+
+	// a single fetch that has to go through more relays than the limit, the last one has the event
+	relays := make([]string, 8)
+	for i := range relays {
+		if i == len(relays)-1 {
+			relays[i] = startFakeRelayWithMatcher(t, nostr.Filters.Match, onReq, evt)
+		} else {
+			relays[i] = startFakeRelayWithMatcher(t, nostr.Filters.Match, onReq)
+		}
+	}
+
+	sys := NewSystem(WithFallbackRelays(relays[0:1]), WithJustIDRelays(relays))
+	defer sys.Close()
+
+	found := 0
+	for res := range sys.FetchSpecificEventsFromInput(ctx, []string{evt.ID}, FetchSpecificEventsParameters{
+		FetchSpecificEventParameters: FetchSpecificEventParameters{SkipLocalStore: true, SkipProfilePrefetch: true},
+		MaxConcurrentRelays:          3,
+	}) {
+		require.NoError(t, res.Err)
+		require.Equal(t, evt.ID, res.Event.ID)
+		found++
+	}
+
+	require.Equal(t, 1, found)
+	require.LessOrEqual(t, maxActive, 3)
+	require.Greater(t, maxActive, 1)
+}
+
+func TestFetchSpecificEventSkipsBlockedRelays(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(nostr.GeneratePrivateKey())
+
+	blocked, blockedReceived := startRecordingRelay(t, evt)
+	good := startFakeRelay(t, evt)
+
+	sys := NewSystem(
+		WithFallbackRelays([]string{blocked}),
+		WithJustIDRelays([]string{blocked}),
+		WithBlockedRelays(blocked+"/"),
+	)
+	defer sys.Close()
+
+	res, relays, err := sys.FetchSpecificEvent(ctx,
+		nostr.EventPointer{ID: evt.ID, Relays: []string{blocked, good}},
+		FetchSpecificEventParameters{SkipLocalStore: true, WithRelays: true})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+	require.Equal(t, []string{nostr.NormalizeURL(good)}, relays)
+
+	// when the event is only on the blocked relay we don't find it
+	_, _, err = sys.FetchSpecificEvent(ctx,
+		nostr.EventPointer{ID: evt.ID, Relays: []string{blocked}},
+		FetchSpecificEventParameters{SkipLocalStore: true})
+	require.Error(t, err)
+
+	require.Empty(t, blockedReceived())
+}
+
+func TestFetchSpecificEventSavesNegativeHints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(sk)
+
+	good := startFakeRelay(t, evt)
+	empty := startFakeRelay(t)
+
+	hdb := memoryh.NewHintDB()
+	sys := NewSystem(
+		WithHintsDB(hdb),
+		WithFallbackRelays([]string{empty}),
+		WithJustIDRelays([]string{empty}),
+		WithRelayListRelays([]string{empty}),
+	)
+	defer sys.Close()
+
+	score := func(url string) int64 {
+		hdb.Lock()
+		defer hdb.Unlock()
+		for _, entry := range hdb.OrderedRelaysByPubKey[pk].Entries {
+			if hdb.RelayBySerial[entry.Relay] == nostr.NormalizeURL(url) {
+				return entry.Sum()
+			}
+		}
+		return 0
+	}
+
+	// this relay was hinted for this author some time ago
+	sys.Hints.Save(pk, nostr.NormalizeURL(empty), hints.LastInHint, nostr.Now()-60*60)
+	initial := score(empty)
+	require.Greater(t, initial, int64(0))
+
+	pointer := nostr.EventPointer{ID: evt.ID, Author: pk, Relays: []string{good}}
+	previous := initial
+	for range 3 {
+		res, _, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{SkipLocalStore: true, WithRelays: true})
+		require.NoError(t, err)
+		require.Equal(t, evt.ID, res.ID)
+
+		current := score(empty)
+		require.LessOrEqual(t, current, previous)
+		previous = current
+		time.Sleep(1100 * time.Millisecond)
+	}
+	require.Less(t, previous, initial)
+	require.Less(t, previous, int64(0))
+	require.Equal(t, nostr.NormalizeURL(good), sys.Hints.TopN(pk, 1)[0])
+}
+
+// negativeHintsRecorder is a HintsDB that also keeps the relays it was given negative hints for.
+type negativeHintsRecorder struct {
+	*memoryh.HintDB
+
+	mu       sync.Mutex
+	negative []string
+}
+
+func (r *negativeHintsRecorder) SaveBatch(entries []hints.HintEntry) {
+	r.mu.Lock()
+	for _, entry := range entries {
+		if entry.Key == hints.NegativeHint {
+			r.negative = append(r.negative, entry.Relay)
+		}
+	}
+	r.mu.Unlock()
+	r.HintDB.SaveBatch(entries)
+}
+
+func TestFetchSpecificEventNegativeHintsOnlyForRelaysThatAnswered(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Content: "hello"}
+	evt.Sign(sk)
+
+	good := startFakeRelay(t, evt)
+	empty := startFakeRelay(t)
+	fallback := startFakeRelay(t)
+	unreachable := "ws://127.0.0.1:1"
+
+	hdb := &negativeHintsRecorder{HintDB: memoryh.NewHintDB()}
+	sys := NewSystem(
+		WithHintsDB(hdb),
+		WithFallbackRelays([]string{fallback}),
+		WithJustIDRelays([]string{fallback}),
+		WithRelayListRelays([]string{fallback}),
+	)
+	defer sys.Close()
+
+	pointer := nostr.EventPointer{ID: evt.ID, Author: pk, Relays: []string{unreachable, good, empty}}
+	res, _, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{SkipLocalStore: true, WithRelays: true})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+
+	// the unreachable relay tells us nothing and the fallback one was never hinted for this author
+	hdb.mu.Lock()
+	defer hdb.mu.Unlock()
+	require.Equal(t, []string{nostr.NormalizeURL(empty)}, hdb.negative)
+}
+
+func TestSortSuccessRelaysByHints(t *testing.T) {
+	sys := NewSystem()
+	defer sys.Close()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	now := nostr.Now()
+	sys.Hints.Save(pk, "wss://low.example.com", hints.LastInHint, now)
+	sys.Hints.Save(pk, "wss://high.example.com", hints.LastInRelayList, now)
+	sys.Hints.Save(pk, "wss://other-high.example.com", hints.LastInRelayList, now)
+
+	relays := []string{"wss://other-high.example.com", "wss://unranked.example.com", "wss://low.example.com", "wss://high.example.com"}
+	priority := []string{"wss://low.example.com", "wss://high.example.com", "wss://unranked.example.com"}
+	sys.sortSuccessRelays(relays, priority, pk)
+
+	// priority relays come first, the higher scored first among them, and the unknown one at the end
+	require.Equal(t, []string{"wss://high.example.com", "wss://low.example.com", "wss://unranked.example.com", "wss://other-high.example.com"}, relays)
+}
+
+func TestSortSuccessRelaysIsStable(t *testing.T) {
+	sys := NewSystem()
+	defer sys.Close()
+
+	priority := []string{"wss://p1.example.com", "wss://p2.example.com", "wss://p3.example.com"}
+	expected := []string{
+		"wss://p2.example.com", "wss://p1.example.com", "wss://p3.example.com",
+		"wss://n3.example.com", "wss://n1.example.com", "wss://n2.example.com",
+	}
+	for range 20 {
+		relays := []string{
+			"wss://n3.example.com", "wss://p2.example.com", "wss://n1.example.com",
+			"wss://p1.example.com", "wss://n2.example.com", "wss://p3.example.com",
+		}
+		sys.sortSuccessRelays(relays, priority, "")
+		require.Equal(t, expected, relays)
+	}
+}
+
+func TestFallbackComposition(t *testing.T) {
+	sys := NewSystem(WithJustIDRelays([]string{"wss://id1.example.com", "wss://id2.example.com"}))
+	defer sys.Close()
+
+	// a fresh stream always starts rotating from the second url
+	resetFallback := func() {
+		sys.FallbackRelays = &RelayStream{URLs: []string{"wss://f1.example.com", "wss://f2.example.com", "wss://f3.example.com"}}
+	}
+
+	// by default events are looked for by id on the JustIDRelays and then on one fallback relay
+	resetFallback()
+	require.Equal(t,
+		[]string{"wss://id1.example.com", "wss://id2.example.com", "wss://f2.example.com"},
+		sys.fallbackRelays(sys.EventPointerFallback))
+
+	// while addresses only go to two fallback relays
+	resetFallback()
+	require.Equal(t,
+		[]string{"wss://f2.example.com", "wss://f3.example.com"},
+		sys.fallbackRelays(sys.EntityPointerFallback))
+
+	// both can be changed
+	sys.EventPointerFallback = FallbackComposition{UseJustIDRelays: true, FallbackRelayCount: 2, FallbackRelaysFirst: true}
+	sys.EntityPointerFallback = FallbackComposition{UseJustIDRelays: true}
+
+	resetFallback()
+	require.Equal(t,
+		[]string{"wss://f2.example.com", "wss://f3.example.com", "wss://id1.example.com", "wss://id2.example.com"},
+		sys.fallbackRelays(sys.EventPointerFallback))
+
+	resetFallback()
+	require.Equal(t,
+		[]string{"wss://id1.example.com", "wss://id2.example.com"},
+		sys.fallbackRelays(sys.EntityPointerFallback))
+}
+
+func TestFetchSpecificEventRawEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "forward me as I am"}
+	require.NoError(t, evt.Sign(sk))
+
+	// fields in an unusual order and an unknown one, so a re-serialized event wouldn't be the same
+	object := fmt.Sprintf(`{"content":"%s","sig":"%s","extra":[1,2],"tags":[],"kind":1,"created_at":%d,"pubkey":"%s","id":"%s"}`,
+		evt.Content, evt.Sig, evt.CreatedAt, evt.PubKey, evt.ID)
+
+	var mu sync.Mutex
+	var sent []string
+	server := httptest.NewServer(&websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			for {
+				var raw []stdjson.RawMessage
+				if err := websocket.JSON.Receive(conn, &raw); err != nil {
+					return
+				}
+				if len(raw) < 3 || string(raw[0]) != `"REQ"` {
+					continue
+				}
+				frame := `["EVENT",` + string(raw[1]) + `,` + object + `]`
+				mu.Lock()
+				sent = append(sent, frame)
+				mu.Unlock()
+				websocket.Message.Send(conn, frame)
+				websocket.Message.Send(conn, `["EOSE",`+string(raw[1])+`]`)
+			}
+		},
+	})
+	defer server.Close()
+	url := "ws" + server.URL[len("http"):]
+
+	store := &slicestore.SliceStore{}
+	store.Init()
+	sys := NewSystem(
+		WithStore(store),
+		WithFallbackRelays([]string{url}),
+		WithJustIDRelays([]string{url}),
+		WithRelayListRelays([]string{url}),
+	)
+	defer sys.Close()
+
+	var raw []byte
+	pointer := nostr.EventPointer{ID: evt.ID, Relays: []string{url}}
+	res, _, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{RawEvent: &raw, SkipProfilePrefetch: true})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+
+	mu.Lock()
+	require.Contains(t, sent, string(raw))
+	mu.Unlock()
+	require.Contains(t, string(raw), `"extra":[1,2]`)
+
+	// now it comes from the local store, so there is no raw message
+	res, _, err = sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{RawEvent: &raw, SkipProfilePrefetch: true})
+	require.NoError(t, err)
+	require.Equal(t, evt.ID, res.ID)
+	require.Nil(t, raw)
+}
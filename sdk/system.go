@@ -3,6 +3,8 @@ package sdk
 import (
 	"context"
 	"math/rand/v2"
+	"sync/atomic"
+	"time"
 
 	"github.com/fiatjaf/eventstore"
 	"github.com/fiatjaf/eventstore/nullstore"
@@ -40,6 +42,7 @@ type System struct {
 	RelaySetsCache        cache.Cache32[GenericSets[RelayURL]]
 	FollowSetsCache       cache.Cache32[GenericSets[ProfileRef]]
 	TopicSetsCache        cache.Cache32[GenericSets[Topic]]
+	NIP05Cache            cache.Cache32[nostr.ProfilePointer]
 	Hints                 hints.HintsDB
 	Pool                  *nostr.SimplePool
 	RelayListRelays       *RelayStream
@@ -53,6 +56,34 @@ type System struct {
 
 	StoreRelay nostr.RelayStore
 
+	// LocalStores are extra local stores, ordered from the fastest to the slowest, that are checked after
+	// StoreRelay and before going to the network. BackfillLocalStores makes an event found in one of them
+	// also be saved to StoreRelay and to the faster ones.
+	LocalStores         []nostr.RelayStore
+	BackfillLocalStores bool
+
+	// BlockedRelays are normalized relay URLs that are never contacted when looking for events, even if
+	// they show up in pointer hints, outbox lists or among the fallback relays. It should be set up before
+	// the System is used (see WithBlockedRelays), as it is not safe for concurrent modification.
+	BlockedRelays map[string]struct{}
+
+	// OutboxRelayCount is how many outbox relays of an author are used when looking for an event by them
+	// in FetchSpecificEvent and FetchReplaceableHistory.
+	OutboxRelayCount int
+
+	// OutboxFetchTimeout bounds how long FetchOutboxRelays waits for the author's relay list to be fetched
+	// before falling back to the hints it already has. Zero means it only waits for the given context.
+	OutboxFetchTimeout time.Duration
+
+	// EventPointerFallback and EntityPointerFallback determine the relays FetchSpecificEvent falls back to
+	// when looking for an event by id or by address, respectively.
+	EventPointerFallback  FallbackComposition
+	EntityPointerFallback FallbackComposition
+
+	// OnRelayMoved, if set, is called when a relay tells us with a NOTICE like "moved: wss://new.relay"
+	// that it is now at another URL, so the application can update its relay lists, hints and connections.
+	OnRelayMoved func(old, new string)
+
 	replaceableLoaders []*dataloader.Loader[string, *nostr.Event]
 	addressableLoaders []*dataloader.Loader[string, []*nostr.Event]
 }
@@ -63,20 +94,80 @@ type SystemModifier func(sys *System)
 
 // RelayStream provides a rotating list of relay URLs.
 // It's used to distribute requests across multiple relays.
+//
+// Next() is safe to be called concurrently.
 type RelayStream struct {
-	URLs   []string
-	serial int
+	URLs     []string
+	Strategy RelayStreamStrategy
+
+	// Weights are only used by StrategyWeighted, each item is the weight of the URL at the same
+	// index (URLs without a corresponding weight get 1).
+	Weights []int
+
+	serial atomic.Uint64
 }
 
+// FallbackComposition describes the relays used as a last resort when looking for a specific event: all the
+// JustIDRelays (if UseJustIDRelays is set) and then FallbackRelayCount relays taken in turn from FallbackRelays,
+// or the other way around if FallbackRelaysFirst is set.
+type FallbackComposition struct {
+	UseJustIDRelays     bool
+	FallbackRelayCount  int
+	FallbackRelaysFirst bool
+}
+
+// RelayStreamStrategy determines how RelayStream.Next() picks URLs.
+type RelayStreamStrategy int
+
+const (
+	// StrategyRoundRobin goes through all URLs in order, this is the default.
+	StrategyRoundRobin RelayStreamStrategy = iota
+
+	// StrategyRandom picks a random URL every time.
+	StrategyRandom
+
+	// StrategyWeighted picks a random URL every time, with probabilities proportional to their Weights.
+	StrategyWeighted
+)
+
 // NewRelayStream creates a new RelayStream with the provided URLs.
 func NewRelayStream(urls ...string) *RelayStream {
-	return &RelayStream{URLs: urls, serial: rand.Int()}
+	rs := &RelayStream{URLs: urls}
+	rs.serial.Store(rand.Uint64())
+	return rs
 }
 
 // Next returns the next URL in the rotation.
 func (rs *RelayStream) Next() string {
-	rs.serial++
-	return rs.URLs[rs.serial%len(rs.URLs)]
+	urls := rs.URLs
+	switch rs.Strategy {
+	case StrategyRandom:
+		return urls[rand.IntN(len(urls))]
+	case StrategyWeighted:
+		total := 0
+		for i := range urls {
+			total += rs.weight(i)
+		}
+		if total > 0 {
+			pick := rand.IntN(total)
+			for i, url := range urls {
+				pick -= rs.weight(i)
+				if pick < 0 {
+					return url
+				}
+			}
+		}
+		return urls[rand.IntN(len(urls))]
+	default:
+		return urls[rs.serial.Add(1)%uint64(len(urls))]
+	}
+}
+
+func (rs *RelayStream) weight(i int) int {
+	if i < len(rs.Weights) {
+		return max(rs.Weights[i], 0)
+	}
+	return 1
 }
 
 // NewSystem creates a new System with default configuration,
@@ -113,13 +204,23 @@ func NewSystem(mods ...SystemModifier) *System {
 			"wss://relay.nostr.band",
 			"wss://search.nos.today",
 		),
-		Hints: memoryh.NewHintDB(),
+		Hints:              memoryh.NewHintDB(),
+		OutboxRelayCount:   3,
+		OutboxFetchTimeout: time.Second * 4,
+		EventPointerFallback: FallbackComposition{
+			UseJustIDRelays:    true,
+			FallbackRelayCount: 1,
+		},
+		EntityPointerFallback: FallbackComposition{
+			FallbackRelayCount: 2,
+		},
 	}
 
 	sys.Pool = nostr.NewSimplePool(context.Background(),
 		nostr.WithAuthorKindQueryMiddleware(sys.TrackQueryAttempts),
 		nostr.WithEventMiddleware(sys.TrackEventHintsAndRelays),
 		nostr.WithDuplicateMiddleware(sys.TrackEventRelaysD),
+		nostr.WithNoticeMiddleware(sys.TrackRelayMoves),
 		nostr.WithPenaltyBox(),
 	)
 
@@ -133,6 +234,9 @@ func NewSystem(mods ...SystemModifier) *System {
 	if sys.RelayListCache == nil {
 		sys.RelayListCache = cache_memory.New32[GenericList[Relay]](8000)
 	}
+	if sys.NIP05Cache == nil {
+		sys.NIP05Cache = cache_memory.New32[nostr.ProfilePointer](1000)
+	}
 
 	if sys.Store == nil {
 		sys.Store = &nullstore.NullStore{}
@@ -148,12 +252,15 @@ func NewSystem(mods ...SystemModifier) *System {
 
 // Close releases resources held by the System.
 func (sys *System) Close() {
+	// stop everything that may still be using the stores first
+	if sys.Pool != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sys.Pool.Shutdown(ctx)
+	}
 	if sys.KVStore != nil {
 		sys.KVStore.Close()
 	}
-	if sys.Pool != nil {
-		sys.Pool.Close("sdk.System closed")
-	}
 }
 
 // WithHintsDB returns a SystemModifier that sets the HintsDB.
@@ -219,6 +326,23 @@ func WithStore(store eventstore.Store) SystemModifier {
 	}
 }
 
+// WithLocalStores returns a SystemModifier that sets the LocalStores, ordered from the fastest to the slowest.
+func WithLocalStores(stores ...nostr.RelayStore) SystemModifier {
+	return func(sys *System) {
+		sys.LocalStores = stores
+	}
+}
+
+// WithBlockedRelays returns a SystemModifier that sets the BlockedRelays.
+func WithBlockedRelays(urls ...string) SystemModifier {
+	return func(sys *System) {
+		sys.BlockedRelays = make(map[string]struct{}, len(urls))
+		for _, url := range urls {
+			sys.BlockedRelays[nostr.NormalizeURL(url)] = struct{}{}
+		}
+	}
+}
+
 // WithRelayListCache returns a SystemModifier that sets the RelayListCache.
 func WithRelayListCache(cache cache.Cache32[GenericList[Relay]]) SystemModifier {
 	return func(sys *System) {
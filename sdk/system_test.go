@@ -0,0 +1,65 @@
+package sdk
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayStreamRoundRobin(t *testing.T) {
+	rs := NewRelayStream("wss://a.com", "wss://b.com", "wss://c.com")
+
+	prev := rs.Next()
+	for range 100 {
+		next := rs.Next()
+		require.NotEqual(t, prev, next, "round-robin picked the same relay twice in a row")
+		prev = next
+	}
+
+	counts := make(map[string]int)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for range 30 {
+				local[rs.Next()]++
+			}
+			mu.Lock()
+			for url, n := range local {
+				counts[url] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, map[string]int{"wss://a.com": 1000, "wss://b.com": 1000, "wss://c.com": 1000}, counts)
+}
+
+func TestRelayStreamStrategies(t *testing.T) {
+	rs := NewRelayStream("wss://a.com", "wss://b.com", "wss://c.com")
+
+	rs.Strategy = StrategyRandom
+	counts := make(map[string]int)
+	for range 3000 {
+		counts[rs.Next()]++
+	}
+	require.Len(t, counts, 3)
+	for url, n := range counts {
+		require.InDelta(t, 1000, n, 200, "%s was picked %d times", url, n)
+	}
+
+	rs.Strategy = StrategyWeighted
+	rs.Weights = []int{8, 0}
+	counts = make(map[string]int)
+	for range 3000 {
+		counts[rs.Next()]++
+	}
+	require.Zero(t, counts["wss://b.com"], "relay with weight 0 shouldn't be picked")
+	require.InDelta(t, 3000*8/9, counts["wss://a.com"], 200)
+	require.InDelta(t, 3000*1/9, counts["wss://c.com"], 200)
+}
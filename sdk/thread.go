@@ -1,6 +1,9 @@
 package sdk
 
 import (
+	"context"
+	"slices"
+
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip10"
 	"github.com/nbd-wtf/go-nostr/nip22"
@@ -21,3 +24,67 @@ func GetImmediateReply(evt *nostr.Event) *nostr.Tag {
 		return nip10.GetImmediateReply(evt.Tags)
 	}
 }
+
+// FetchThread fetches the event with the given id and then walks up its chain of parents (as indicated by
+// its "e" tags) using FetchSpecificEvent and the relay hints found in the tags.
+//
+// The result is ordered from the topmost ancestor that could be found down to the event itself and contains
+// at most maxDepth ancestors (no limit if maxDepth is 0). If a parent can't be found the thread root is still
+// attempted. The walk stops if a cycle is detected.
+func (sys *System) FetchThread(ctx context.Context, eventID string, maxDepth int) ([]*nostr.Event, error) {
+	evt, _, err := sys.FetchSpecificEvent(ctx, nostr.EventPointer{ID: eventID}, FetchSpecificEventParameters{})
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []*nostr.Event{evt}
+	seen := map[string]struct{}{evt.ID: {}}
+
+	for current := evt; maxDepth <= 0 || len(chain) <= maxDepth; {
+		pointer, ok := threadTagPointer(GetImmediateReply(current))
+		if !ok {
+			break
+		}
+		if _, isCycle := seen[pointer.ID]; isCycle {
+			break
+		}
+
+		parent, _, err := sys.FetchSpecificEvent(ctx, pointer, FetchSpecificEventParameters{})
+		if err != nil {
+			// we couldn't find the parent, but maybe we can still find the root
+			if root, ok := threadTagPointer(GetThreadRoot(current)); ok {
+				if _, isKnown := seen[root.ID]; !isKnown && root.ID != pointer.ID {
+					if evt, _, err := sys.FetchSpecificEvent(ctx, root, FetchSpecificEventParameters{}); err == nil {
+						chain = append(chain, evt)
+					}
+				}
+			}
+			break
+		}
+
+		seen[parent.ID] = struct{}{}
+		chain = append(chain, parent)
+		current = parent
+	}
+
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+// threadTagPointer turns an "e" or "E" tag into an EventPointer, also taking the author from the
+// NIP-10 position (after the marker) if it's there.
+func threadTagPointer(tag *nostr.Tag) (nostr.EventPointer, bool) {
+	if tag == nil || len(*tag) < 2 || ((*tag)[0] != "e" && (*tag)[0] != "E") {
+		return nostr.EventPointer{}, false
+	}
+
+	pointer, err := nostr.EventPointerFromTag(*tag)
+	if err != nil {
+		return nostr.EventPointer{}, false
+	}
+	if pointer.Author == "" && len(*tag) > 4 && nostr.IsValidPublicKey((*tag)[4]) {
+		pointer.Author = (*tag)[4]
+	}
+
+	return pointer, true
+}
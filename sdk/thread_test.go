@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchThread(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	relays := startTestRelays(t, 1)
+	sk := nostr.GeneratePrivateKey()
+
+	root := nostr.Event{Kind: 1, CreatedAt: nostr.Now() - 30, Content: "root"}
+	root.Sign(sk)
+	reply := nostr.Event{
+		Kind:      1,
+		CreatedAt: nostr.Now() - 20,
+		Content:   "reply",
+		Tags:      nostr.Tags{{"e", root.ID, relays[0], "root"}},
+	}
+	reply.Sign(sk)
+	deeper := nostr.Event{
+		Kind:      1,
+		CreatedAt: nostr.Now() - 10,
+		Content:   "reply to reply",
+		Tags: nostr.Tags{
+			{"e", root.ID, relays[0], "root"},
+			{"e", reply.ID, relays[0], "reply"},
+		},
+	}
+	deeper.Sign(sk)
+	publishTo(t, ctx, relays[0], root, reply, deeper)
+
+	sys := NewSystem(WithFallbackRelays(relays))
+	defer sys.Close()
+
+	thread, err := sys.FetchThread(ctx, deeper.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, thread, 3)
+	require.Equal(t, root.ID, thread[0].ID)
+	require.Equal(t, reply.ID, thread[1].ID)
+	require.Equal(t, deeper.ID, thread[2].ID)
+
+	thread, err = sys.FetchThread(ctx, deeper.ID, 1)
+	require.NoError(t, err)
+	require.Len(t, thread, 2)
+	require.Equal(t, reply.ID, thread[0].ID)
+	require.Equal(t, deeper.ID, thread[1].ID)
+
+	thread, err = sys.FetchThread(ctx, root.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, thread, 1)
+	require.Equal(t, root.ID, thread[0].ID)
+}
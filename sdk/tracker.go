@@ -2,6 +2,7 @@ package sdk
 
 import (
 	"net/url"
+	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip27"
@@ -52,6 +53,14 @@ func (sys *System) TrackEventHints(ie nostr.RelayEvent) {
 }
 
 func (sys *System) trackEventHints(ie nostr.RelayEvent) {
+	// events like follow lists can have many hints, so we save them all at once
+	var batch []hints.HintEntry
+	defer func() {
+		if len(batch) > 0 {
+			sys.Hints.SaveBatch(batch)
+		}
+	}()
+
 	switch ie.Kind {
 	case nostr.KindProfileMetadata:
 		// this could be anywhere so it doesn't count
@@ -67,41 +76,31 @@ func (sys *System) trackEventHints(ie nostr.RelayEvent) {
 				continue
 			}
 			if len(tag) == 2 || (tag[2] == "" || tag[2] == "write") {
-				sys.Hints.Save(ie.PubKey, nostr.NormalizeURL(tag[1]), hints.LastInRelayList, ie.CreatedAt)
+				batch = append(batch, hints.HintEntry{PubKey: ie.PubKey, Relay: nostr.NormalizeURL(tag[1]), Key: hints.LastInRelayList, When: ie.CreatedAt})
 			}
 		}
 	case nostr.KindFollowList:
 		// this is special, we only use it to check if there are hints for the contacts
 		for _, tag := range ie.Tags {
-			if len(tag) < 3 {
-				continue
-			}
-			if IsVirtualRelay(tag[2]) {
-				continue
-			}
-			if p, err := url.Parse(tag[2]); err != nil || (p.Scheme != "wss" && p.Scheme != "ws") {
+			if len(tag) < 2 || tag[0] != "p" || !nostr.IsValidPublicKey(tag[1]) {
 				continue
 			}
-			if tag[0] == "p" && nostr.IsValidPublicKey(tag[1]) {
-				sys.Hints.Save(tag[1], nostr.NormalizeURL(tag[2]), hints.LastInHint, ie.CreatedAt)
+			if relay, ok := tag.RelayHint(); ok && !IsVirtualRelay(relay) {
+				batch = append(batch, hints.HintEntry{PubKey: tag[1], Relay: relay, Key: hints.LastInHint, When: ie.CreatedAt})
 			}
 		}
 	default:
 		// everything else we track by relays and also check for hints
-		sys.Hints.Save(ie.PubKey, ie.Relay.URL, hints.MostRecentEventFetched, ie.CreatedAt)
+		if ie.Relay != nil {
+			batch = append(batch, hints.HintEntry{PubKey: ie.PubKey, Relay: ie.Relay.URL, Key: hints.MostRecentEventFetched, When: ie.CreatedAt})
+		}
 
 		for _, tag := range ie.Tags {
-			if len(tag) < 3 {
-				continue
-			}
-			if IsVirtualRelay(tag[2]) {
+			if len(tag) < 2 || tag[0] != "p" || !nostr.IsValidPublicKey(tag[1]) {
 				continue
 			}
-			if p, err := url.Parse(tag[2]); err != nil || (p.Scheme != "wss" && p.Scheme != "ws") {
-				continue
-			}
-			if tag[0] == "p" && nostr.IsValidPublicKey(tag[1]) {
-				sys.Hints.Save(tag[1], nostr.NormalizeURL(tag[2]), hints.LastInHint, ie.CreatedAt)
+			if relay, ok := tag.RelayHint(); ok && !IsVirtualRelay(relay) {
+				batch = append(batch, hints.HintEntry{PubKey: tag[1], Relay: relay, Key: hints.LastInHint, When: ie.CreatedAt})
 			}
 		}
 
@@ -115,7 +114,7 @@ func (sys *System) trackEventHints(ie nostr.RelayEvent) {
 						continue
 					}
 					if nostr.IsValidPublicKey(ref.Profile.PublicKey) {
-						sys.Hints.Save(ref.Profile.PublicKey, nostr.NormalizeURL(relay), hints.LastInHint, ie.CreatedAt)
+						batch = append(batch, hints.HintEntry{PubKey: ref.Profile.PublicKey, Relay: nostr.NormalizeURL(relay), Key: hints.LastInHint, When: ie.CreatedAt})
 					}
 				}
 			} else if ref.Event != nil && nostr.IsValidPublicKey(ref.Event.Author) {
@@ -126,7 +125,7 @@ func (sys *System) trackEventHints(ie nostr.RelayEvent) {
 					if p, err := url.Parse(relay); err != nil || (p.Scheme != "wss" && p.Scheme != "ws") {
 						continue
 					}
-					sys.Hints.Save(ref.Event.Author, nostr.NormalizeURL(relay), hints.LastInHint, ie.CreatedAt)
+					batch = append(batch, hints.HintEntry{PubKey: ref.Event.Author, Relay: nostr.NormalizeURL(relay), Key: hints.LastInHint, When: ie.CreatedAt})
 				}
 			}
 		}
@@ -140,3 +139,33 @@ func (sys *System) TrackEventRelaysD(relay, id string) {
 	}
 	sys.trackEventRelay(id, relay, true /* we pass this flag so we'll skip creating entries for events that didn't pass the checks on the function above -- i.e. ephemeral events */)
 }
+
+// TrackRelayMoves is meant to be used as an argument to WithNoticeMiddleware(), it calls OnRelayMoved when
+// a relay says it has moved to another URL.
+func (sys *System) TrackRelayMoves(relay string, notice string) {
+	if sys.OnRelayMoved == nil {
+		return
+	}
+	if newURL, ok := parseMovedNotice(notice); ok && newURL != relay {
+		sys.OnRelayMoved(relay, newURL)
+	}
+}
+
+// parseMovedNotice reads the new URL from a NOTICE like "moved: wss://new.relay", which may also have
+// some text around the URL.
+func parseMovedNotice(notice string) (string, bool) {
+	rest, ok := strings.CutPrefix(notice, "moved:")
+	if !ok {
+		return "", false
+	}
+
+	for _, word := range strings.Fields(rest) {
+		word = strings.TrimRight(word, ".,;:!)")
+		if nostr.IsValidRelayURL(word) {
+			if u := nostr.NormalizeURL(word); u != "" {
+				return u, true
+			}
+		}
+	}
+	return "", false
+}
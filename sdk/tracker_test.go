@@ -0,0 +1,76 @@
+package sdk
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestParseMovedNotice(t *testing.T) {
+	for notice, expected := range map[string]string{
+		"moved: wss://new.relay.com":                       "wss://new.relay.com",
+		"moved:wss://new.relay.com/":                       "wss://new.relay.com",
+		"moved: we are now at wss://new.relay.com, bye":    "wss://new.relay.com",
+		"moved: we are now at wss://new.relay.com bye":     "wss://new.relay.com",
+		"moved: https://new.relay.com":                     "",
+		"moved: nowhere":                                   "",
+		"error: moved: wss://new.relay.com":                "",
+		"this relay has moved to wss://new.relay.com":      "",
+		"moved: ws://127.0.0.1:1234 (please update lists)": "ws://127.0.0.1:1234",
+	} {
+		url, ok := parseMovedNotice(notice)
+		require.Equal(t, expected != "", ok, notice)
+		require.Equal(t, expected, url, notice)
+	}
+}
+
+func TestOnRelayMoved(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a relay that answers every REQ with a notice saying it has moved
+	server := httptest.NewServer(&websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			for {
+				var raw []stdjson.RawMessage
+				if err := websocket.JSON.Receive(conn, &raw); err != nil {
+					return
+				}
+				var req nostr.ReqEnvelope
+				if err := req.UnmarshalJSON(mustMarshal(raw)); err != nil {
+					continue
+				}
+				websocket.JSON.Send(conn, []any{"NOTICE", "moved: wss://new.relay.com/"})
+				websocket.JSON.Send(conn, []any{"EOSE", req.SubscriptionID})
+			}
+		},
+	})
+	defer server.Close()
+	oldURL := "ws" + server.URL[len("http"):]
+
+	sys := NewSystem()
+	defer sys.Close()
+
+	type move struct{ old, new string }
+	moves := make(chan move, 1)
+	sys.OnRelayMoved = func(old, new string) { moves <- move{old, new} }
+
+	for range sys.Pool.FetchMany(ctx, []string{oldURL}, nostr.Filter{Kinds: []int{1}}) {
+	}
+
+	select {
+	case m := <-moves:
+		require.Equal(t, nostr.NormalizeURL(oldURL), m.old)
+		require.Equal(t, "wss://new.relay.com", m.new)
+	case <-ctx.Done():
+		t.Fatal("OnRelayMoved wasn't called")
+	}
+}
@@ -0,0 +1,224 @@
+package nostr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sharedSubSeenSize is how many event ids each SharedSub consumer remembers in order to ignore the
+// stored events that come again when the REQ is replaced.
+const sharedSubSeenSize = 1000
+
+// SharedSub multiplexes many filters onto a single REQ to one relay.
+//
+// When a filter is added the REQ is replaced by a new one containing all the current filters (so the
+// relay sends the stored events for it), and incoming events are dispatched only to the consumers
+// whose filter matches them. Removed filters stay in the REQ until it is replaced again (or until no
+// consumers are left), as events matching only them are just ignored.
+type SharedSub struct {
+	Relay *Relay
+
+	pool   *SimplePool
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	opts   []SubscriptionOption
+
+	mu        sync.Mutex
+	serial    int
+	consumers map[int]*sharedSubConsumer
+	current   *Subscription
+}
+
+type sharedSubConsumer struct {
+	filter Filter
+	ctx    context.Context
+
+	// dispatch sends to queue following the pool's drop policy, the consumer's own goroutine
+	// takes from it and sends to events, which only that goroutine ever touches
+	queue  chan RelayEvent
+	events chan RelayEvent
+
+	// a fixed-size ring of the last ids emitted, only used by the consumer's goroutine
+	seen     map[string]struct{}
+	seenRing []string
+	seenNext int
+}
+
+// SharedSub connects to the given relay (or reuses an existing connection) and returns a SharedSub
+// to which filters can be added incrementally with SharedSub.Add.
+//
+// Everything ends when ctx is canceled or SharedSub.Close is called.
+func (pool *SimplePool) SharedSub(ctx context.Context, url string, opts ...SubscriptionOption) (*SharedSub, error) {
+	relay, err := pool.EnsureRelay(url)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &SharedSub{
+		Relay:     relay,
+		pool:      pool,
+		ctx:       ctx,
+		cancel:    cancel,
+		opts:      opts,
+		consumers: make(map[int]*sharedSubConsumer),
+	}, nil
+}
+
+// Add includes filter in the shared REQ and returns a channel that emits only the events that match it.
+// The channel is closed when ctx is canceled, in which case the filter is also removed from the REQ.
+func (ss *SharedSub) Add(ctx context.Context, filter Filter) (chan RelayEvent, error) {
+	if err := ss.ctx.Err(); err != nil {
+		return nil, fmt.Errorf("shared subscription already closed: %w", context.Cause(ss.ctx))
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(ss.ctx, func() { cancel(context.Cause(ss.ctx)) })
+
+	c := &sharedSubConsumer{
+		filter:   filter,
+		ctx:      ctx,
+		queue:    ss.pool.makeEventsChan(),
+		events:   make(chan RelayEvent),
+		seen:     make(map[string]struct{}, sharedSubSeenSize),
+		seenRing: make([]string, sharedSubSeenSize),
+	}
+
+	ss.mu.Lock()
+	id := ss.serial
+	ss.serial++
+	ss.consumers[id] = c
+	err := ss.resubscribe()
+	ss.mu.Unlock()
+
+	if err != nil {
+		stop()
+		cancel(err)
+		ss.mu.Lock()
+		delete(ss.consumers, id)
+		ss.mu.Unlock()
+		return nil, err
+	}
+
+	ss.pool.Go(func() {
+		defer close(c.events)
+
+		for {
+			select {
+			case ie := <-c.queue:
+				// after a resubscription the relay will send us the same stored events again
+				if c.markSeen(ie.ID) {
+					continue
+				}
+
+				select {
+				case c.events <- ie:
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+				stop()
+
+				ss.mu.Lock()
+				delete(ss.consumers, id)
+				if len(ss.consumers) == 0 && ss.ctx.Err() == nil {
+					// this will just close the REQ
+					ss.resubscribe()
+				}
+				ss.mu.Unlock()
+				return
+			}
+		}
+	})
+
+	return c.events, nil
+}
+
+// markSeen returns true if id was already seen, otherwise stores it, forgetting the oldest one.
+func (c *sharedSubConsumer) markSeen(id string) bool {
+	if _, seen := c.seen[id]; seen {
+		return true
+	}
+
+	if old := c.seenRing[c.seenNext]; old != "" {
+		delete(c.seen, old)
+	}
+	c.seenRing[c.seenNext] = id
+	c.seenNext = (c.seenNext + 1) % len(c.seenRing)
+	c.seen[id] = struct{}{}
+	return false
+}
+
+// Close ends the shared subscription and closes all the channels returned by SharedSub.Add.
+func (ss *SharedSub) Close() {
+	ss.cancel(errors.New("SharedSub.Close() called"))
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.current != nil {
+		ss.current.unsub(errors.New("SharedSub closed"))
+		ss.current = nil
+	}
+}
+
+// resubscribe replaces the current REQ with one containing the filters of all consumers.
+// must be called with ss.mu held.
+func (ss *SharedSub) resubscribe() error {
+	if ss.current != nil {
+		ss.current.unsub(errors.New("SharedSub filters changed"))
+		ss.current = nil
+	}
+
+	if len(ss.consumers) == 0 {
+		return nil
+	}
+
+	filters := make(Filters, 0, len(ss.consumers))
+	for _, c := range ss.consumers {
+		if !slices.ContainsFunc(filters, func(f Filter) bool { return FilterEqual(f, c.filter) }) {
+			filters = append(filters, c.filter)
+		}
+	}
+
+	sub, err := ss.Relay.Subscribe(ss.ctx, filters, ss.opts...)
+	if err != nil {
+		return err
+	}
+	ss.current = sub
+
+	ss.pool.Go(func() { ss.dispatch(sub) })
+	return nil
+}
+
+func (ss *SharedSub) dispatch(sub *Subscription) {
+	targets := make([]*sharedSubConsumer, 0, 4)
+
+	for evt := range sub.Events {
+		receivedAt := time.Now()
+		targets = targets[:0]
+
+		ss.mu.Lock()
+		for _, c := range ss.consumers {
+			if c.filter.Matches(evt) {
+				targets = append(targets, c)
+			}
+		}
+		ss.mu.Unlock()
+
+		for _, c := range targets {
+			ss.pool.deliver(c.ctx, c.queue, RelayEvent{Event: evt, Relay: ss.Relay, ReceivedAt: receivedAt})
+		}
+	}
+
+	// if this subscription wasn't replaced by us it means it was closed by the relay
+	// or the connection died, so there is nothing else to do
+	ss.mu.Lock()
+	replaced := ss.current != sub
+	ss.mu.Unlock()
+	if !replaced {
+		ss.cancel(fmt.Errorf("subscription ended: %w", context.Cause(sub.Context)))
+	}
+}
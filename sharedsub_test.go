@@ -0,0 +1,128 @@
+//go:build !js
+
+package nostr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestSharedSubOverlappingFilters(t *testing.T) {
+	privA, pubA := makeKeyPair(t)
+	privB, _ := makeKeyPair(t)
+
+	noteA := Event{Kind: KindTextNote, Content: "from a", CreatedAt: Now()}
+	require.NoError(t, noteA.Sign(privA))
+	noteB := Event{Kind: KindTextNote, Content: "from b", CreatedAt: Now()}
+	require.NoError(t, noteB.Sign(privB))
+	stored := []Event{noteA, noteB}
+
+	reqs := make(chan Filters, 10)
+	ws := newRelayServer(func(conn *websocket.Conn, subid string, filters Filters) {
+		reqs <- filters
+		for _, evt := range stored {
+			if filters.Match(&evt) {
+				websocket.JSON.Send(conn, []any{"EVENT", subid, evt})
+			}
+		}
+		websocket.JSON.Send(conn, []any{"EOSE", subid})
+	})
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx)
+	ss, err := pool.SharedSub(ctx, ws.URL)
+	require.NoError(t, err)
+	defer ss.Close()
+
+	allNotes, err := ss.Add(ctx, Filter{Kinds: []int{KindTextNote}})
+	require.NoError(t, err)
+	require.Len(t, <-reqs, 1)
+
+	received := make([]string, 0, 2)
+	for range 2 {
+		select {
+		case ie := <-allNotes:
+			received = append(received, ie.ID)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for events on the first filter")
+		}
+	}
+	require.ElementsMatch(t, []string{noteA.ID, noteB.ID}, received)
+
+	fromA, err := ss.Add(ctx, Filter{Authors: []string{pubA}})
+	require.NoError(t, err)
+	require.Len(t, <-reqs, 2, "second filter should be sent in the same REQ")
+
+	select {
+	case ie := <-fromA:
+		require.Equal(t, noteA.ID, ie.ID)
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for events on the second filter")
+	}
+
+	// the first consumer shouldn't see the events again after the REQ is replaced
+	select {
+	case ie := <-allNotes:
+		t.Fatalf("got duplicate event %s", ie.ID)
+	case ie := <-fromA:
+		t.Fatalf("got unexpected event %s", ie.ID)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSharedSubSlowConsumer(t *testing.T) {
+	priv, _ := makeKeyPair(t)
+	stored := make([]Event, 20)
+	for i := range stored {
+		stored[i] = Event{Kind: KindTextNote, Content: "hello", CreatedAt: Now() - Timestamp(i)}
+		require.NoError(t, stored[i].Sign(priv))
+	}
+
+	reqs := make(chan Filters, 10)
+	ws := newRelayServer(func(conn *websocket.Conn, subid string, filters Filters) {
+		reqs <- filters
+		sendStored(stored...)(conn, subid, filters)
+	})
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pool := NewSimplePool(ctx, WithDropPolicy(DropPolicyDropNewest))
+	ss, err := pool.SharedSub(ctx, ws.URL)
+	require.NoError(t, err)
+	defer ss.Close()
+
+	// this one is never read from
+	slowCtx, slowCancel := context.WithCancel(ctx)
+	_, err = ss.Add(slowCtx, Filter{Kinds: []int{KindTextNote}})
+	require.NoError(t, err)
+	<-reqs
+
+	fast, err := ss.Add(ctx, Filter{Kinds: []int{KindTextNote}, Limit: 50})
+	require.NoError(t, err)
+	<-reqs
+
+	for range stored {
+		select {
+		case <-fast:
+		case <-ctx.Done():
+			t.Fatal("the slow consumer blocked the other one")
+		}
+	}
+
+	// removing a consumer doesn't replace the REQ
+	slowCancel()
+	select {
+	case filters := <-reqs:
+		t.Fatalf("unexpected REQ with %v", filters)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
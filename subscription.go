@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+
+	"github.com/puzpuzpuz/xsync/v3"
 )
 
 // Subscription represents a subscription to a relay.
@@ -37,6 +39,9 @@ type Subscription struct {
 	// if it returns true that event will not be processed further.
 	checkDuplicate func(id string, relay string) bool
 
+	// if WithRawEnvelopes was given this has a copy of the EVENT message of each event until it is taken
+	rawEnvelopes *xsync.MapOf[*Event, []byte]
+
 	match  func(*Event) bool // this will be either Filters.Match or Filters.MatchIgnoringTimestampConstraints
 	live   atomic.Bool
 	eosed  atomic.Bool
@@ -63,9 +68,39 @@ type WithCheckDuplicate func(id, relay string) bool
 
 func (_ WithCheckDuplicate) IsSubscriptionOption() {}
 
+// WithRawEnvelopes makes the subscription keep a copy of the exact EVENT message each event came in, which
+// can be taken with Subscription.RawEnvelope. Pool methods put it in RelayEvent.RawEnvelope.
+type WithRawEnvelopes struct{}
+
+func (_ WithRawEnvelopes) IsSubscriptionOption() {}
+
+// WithUnsupportedFallback is used by the pool methods: when a relay ends a subscription with a CLOSED
+// message with the "unsupported:" prefix, the same query is attempted again on the next of Relays, with
+// the filters modified by Rewrite (if given).
+type WithUnsupportedFallback struct {
+	Relays  []string
+	Rewrite FilterRewriter
+}
+
+func (_ WithUnsupportedFallback) IsSubscriptionOption() {}
+
+// WithEOSEHandler is used by the pool methods that end at EOSE, like FetchMany: it is called with the URL
+// of each relay that got to the end of its stored events, either with an EOSE or with a CLOSED message,
+// after all the events it sent have been emitted.
+type WithEOSEHandler func(relay string)
+
+func (_ WithEOSEHandler) IsSubscriptionOption() {}
+
+// FilterRewriter takes filters that were rejected by a relay along with the reason given and returns
+// degraded filters that other relays are more likely to support.
+type FilterRewriter func(filters Filters, reason string) Filters
+
 var (
 	_ SubscriptionOption = (WithLabel)("")
 	_ SubscriptionOption = (WithCheckDuplicate)(nil)
+	_ SubscriptionOption = WithRawEnvelopes{}
+	_ SubscriptionOption = WithUnsupportedFallback{}
+	_ SubscriptionOption = (WithEOSEHandler)(nil)
 )
 
 func (sub *Subscription) start() {
@@ -78,11 +113,27 @@ func (sub *Subscription) start() {
 	sub.mu.Lock()
 	close(sub.Events)
 	sub.mu.Unlock()
+
+	// raw envelopes of events that were emitted but never taken are not needed anymore
+	if sub.rawEnvelopes != nil {
+		sub.rawEnvelopes.Clear()
+	}
 }
 
 // GetID returns the subscription ID.
 func (sub *Subscription) GetID() string { return sub.id }
 
+// RawEnvelope returns the EVENT message, exactly as it was sent by the relay, in which evt (as emitted on
+// sub.Events) came. It only works if the subscription was created WithRawEnvelopes, and only once per event,
+// while the subscription is still open.
+func (sub *Subscription) RawEnvelope(evt *Event) []byte {
+	if sub.rawEnvelopes == nil {
+		return nil
+	}
+	raw, _ := sub.rawEnvelopes.LoadAndDelete(evt)
+	return raw
+}
+
 func (sub *Subscription) dispatchEvent(evt *Event) {
 	added := false
 	if !sub.eosed.Load() {
@@ -90,30 +141,36 @@ func (sub *Subscription) dispatchEvent(evt *Event) {
 		added = true
 	}
 
-	go func() {
+	sub.Relay.spawn(func() {
 		sub.mu.Lock()
 		defer sub.mu.Unlock()
 
+		delivered := false
 		if sub.live.Load() {
 			select {
 			case sub.Events <- evt:
+				delivered = true
 			case <-sub.Context.Done():
 			}
 		}
+		if !delivered && sub.rawEnvelopes != nil {
+			// nobody will ever ask for this one
+			sub.rawEnvelopes.Delete(evt)
+		}
 
 		if added {
 			sub.storedwg.Done()
 		}
-	}()
+	})
 }
 
 func (sub *Subscription) dispatchEose() {
 	if sub.eosed.CompareAndSwap(false, true) {
 		sub.match = sub.Filters.MatchIgnoringTimestampConstraints
-		go func() {
+		sub.Relay.spawn(func() {
 			sub.storedwg.Wait()
 			sub.EndOfStoredEvents <- struct{}{}
-		}()
+		})
 	}
 }
 
@@ -168,7 +225,7 @@ func (sub *Subscription) Fire() error {
 	if sub.countResult == nil {
 		reqb, _ = ReqEnvelope{sub.id, sub.Filters}.MarshalJSON()
 	} else {
-		reqb, _ = CountEnvelope{sub.id, sub.Filters, nil, nil}.MarshalJSON()
+		reqb, _ = CountEnvelope{SubscriptionID: sub.id, Filters: sub.Filters}.MarshalJSON()
 	}
 
 	sub.live.Store(true)
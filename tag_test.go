@@ -36,3 +36,26 @@ func TestTagHelpers(t *testing.T) {
 	assert.ElementsMatch(t, filtered, tags)
 	assert.Len(t, filtered, 3)
 }
+
+func TestTagRelayHint(t *testing.T) {
+	for _, tc := range []struct {
+		tag   Tag
+		relay string
+		ok    bool
+	}{
+		{Tag{"e", "eeeeee", "wss://Relay.Example.com/"}, "wss://relay.example.com", true},
+		{Tag{"e", "eeeeee", "wss://relay.example.com", "root"}, "wss://relay.example.com", true},
+		{Tag{"p", "abcdef", "ws://localhost:7777"}, "ws://localhost:7777", true},
+		{Tag{"a", "30023:abcdef:x", "wss://relay.example.com/path/"}, "wss://relay.example.com/path", true},
+		{Tag{"E", "eeeeee", "wss://relay.example.com"}, "wss://relay.example.com", true},
+		{Tag{"e", "eeeeee"}, "", false},
+		{Tag{"e", "eeeeee", ""}, "", false},
+		{Tag{"p", "abcdef", "https://relay.example.com"}, "", false},
+		{Tag{"a", "30023:abcdef:x", "relay.example.com"}, "", false},
+		{Tag{"r", "wss://relay.example.com", "wss://relay.example.com"}, "", false},
+	} {
+		relay, ok := tc.tag.RelayHint()
+		assert.Equal(t, tc.ok, ok, "%v", tc.tag)
+		assert.Equal(t, tc.relay, relay, "%v", tc.tag)
+	}
+}
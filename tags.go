@@ -58,6 +58,24 @@ func (tag Tag) Relay() string {
 	return ""
 }
 
+// RelayHint returns the normalized relay URL carried in the third position of tags that reference events,
+// addresses or profiles ("e", "p", "a", "q" and the uppercase "E", "P" and "A" from NIP-22). It returns
+// false for other tags and when the hint is missing or isn't a websocket URL.
+func (tag Tag) RelayHint() (string, bool) {
+	if len(tag) < 3 {
+		return "", false
+	}
+	switch tag[0] {
+	case "e", "p", "a", "q", "E", "P", "A":
+	default:
+		return "", false
+	}
+	if !IsValidRelayURL(tag[2]) {
+		return "", false
+	}
+	return NormalizeURL(tag[2]), true
+}
+
 type Tags []Tag
 
 // GetD gets the first "d" tag (for parameterized replaceable events) value or ""
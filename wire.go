@@ -0,0 +1,255 @@
+package nostr
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// ValidateWireFormat checks that message has exactly the NIP-01 shape expected for its label: the number of
+// elements in the array and the type of each of them, down to the fields of events and filters. It is meant
+// for conformance tests of relays and clients; ParseMessage is more lenient and should be used for parsing.
+//
+// Messages going in both directions are accepted (e.g. EVENT with or without a subscription id), signatures
+// aren't checked.
+func ValidateWireFormat(message []byte) error {
+	if !gjson.ValidBytes(message) {
+		return fmt.Errorf("invalid json")
+	}
+	r := gjson.ParseBytes(message)
+	if !r.IsArray() {
+		return fmt.Errorf("message is not an array")
+	}
+	arr := r.Array()
+	if len(arr) == 0 || arr[0].Type != gjson.String {
+		return fmt.Errorf("message doesn't start with a label")
+	}
+
+	label := arr[0].Str
+	args := arr[1:]
+	var err error
+	switch label {
+	case "EVENT":
+		switch len(args) {
+		case 1:
+			err = validateWireEvent(args[0])
+		case 2:
+			if err = validateWireString(args[0], "subscription id"); err == nil {
+				err = validateWireEvent(args[1])
+			}
+		default:
+			err = fmt.Errorf("expected 2 or 3 elements, got %d", len(arr))
+		}
+	case "REQ":
+		err = validateWireFilters(args)
+	case "COUNT":
+		if len(args) == 2 && args[1].Get("count").Exists() {
+			err = validateWireString(args[0], "subscription id")
+			if err == nil {
+				err = validateWireCountResult(args[1])
+			}
+		} else {
+			err = validateWireFilters(args)
+		}
+	case "NOTICE", "EOSE", "CLOSE":
+		if len(args) != 1 {
+			err = fmt.Errorf("expected 2 elements, got %d", len(arr))
+		} else {
+			err = validateWireString(args[0], "second element")
+		}
+	case "CLOSED":
+		if len(args) != 2 {
+			err = fmt.Errorf("expected 3 elements, got %d", len(arr))
+		} else if err = validateWireString(args[0], "subscription id"); err == nil {
+			err = validateWireString(args[1], "message")
+		}
+	case "OK":
+		if len(args) != 3 {
+			err = fmt.Errorf("expected 4 elements, got %d", len(arr))
+		} else if args[0].Type != gjson.String || !IsValid32ByteHex(args[0].Str) {
+			err = fmt.Errorf("event id must be 64 lowercase hex characters")
+		} else if !args[1].IsBool() {
+			err = fmt.Errorf("third element must be a boolean")
+		} else {
+			err = validateWireString(args[2], "message")
+		}
+	case "AUTH":
+		if len(args) != 1 {
+			err = fmt.Errorf("expected 2 elements, got %d", len(arr))
+		} else if args[0].IsObject() {
+			err = validateWireEvent(args[0])
+		} else {
+			err = validateWireString(args[0], "challenge")
+		}
+	default:
+		return fmt.Errorf("%w '%s'", UnknownLabel, label)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", label, err)
+	}
+	return nil
+}
+
+func validateWireString(r gjson.Result, what string) error {
+	if r.Type != gjson.String {
+		return fmt.Errorf("%s must be a string", what)
+	}
+	return nil
+}
+
+func isWireInteger(r gjson.Result) bool {
+	if r.Type != gjson.Number {
+		return false
+	}
+	_, err := strconv.ParseInt(r.Raw, 10, 64)
+	return err == nil
+}
+
+func validateWireEvent(r gjson.Result) error {
+	if !r.IsObject() {
+		return fmt.Errorf("event must be an object")
+	}
+
+	seen := make(map[string]bool, 7)
+	var err error
+	r.ForEach(func(key, value gjson.Result) bool {
+		seen[key.Str] = true
+		switch key.Str {
+		case "id", "pubkey":
+			if value.Type != gjson.String || !IsValid32ByteHex(value.Str) {
+				err = fmt.Errorf("event %s must be 64 lowercase hex characters", key.Str)
+			}
+		case "sig":
+			if value.Type != gjson.String || len(value.Str) != 128 || !isLowerHex(value.Str) {
+				err = fmt.Errorf("event sig must be 128 lowercase hex characters")
+			}
+		case "created_at", "kind":
+			if !isWireInteger(value) || value.Int() < 0 {
+				err = fmt.Errorf("event %s must be a non-negative integer", key.Str)
+			}
+		case "content":
+			err = validateWireString(value, "event content")
+		case "tags":
+			err = validateWireStringArrays(value)
+		default:
+			err = fmt.Errorf("unexpected event field '%s'", key.Str)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, field := range []string{"id", "pubkey", "created_at", "kind", "tags", "content", "sig"} {
+		if !seen[field] {
+			return fmt.Errorf("event is missing '%s'", field)
+		}
+	}
+	return nil
+}
+
+func validateWireStringArrays(r gjson.Result) error {
+	if !r.IsArray() {
+		return fmt.Errorf("event tags must be an array")
+	}
+	for i, tag := range r.Array() {
+		if !tag.IsArray() {
+			return fmt.Errorf("tag %d must be an array", i)
+		}
+		for _, item := range tag.Array() {
+			if item.Type != gjson.String {
+				return fmt.Errorf("tag %d must only have strings", i)
+			}
+		}
+	}
+	return nil
+}
+
+func validateWireFilters(args []gjson.Result) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected a subscription id and at least one filter")
+	}
+	if err := validateWireString(args[0], "subscription id"); err != nil {
+		return err
+	}
+	for i, filter := range args[1:] {
+		if err := validateWireFilter(filter); err != nil {
+			return fmt.Errorf("filter %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateWireFilter(r gjson.Result) error {
+	if !r.IsObject() {
+		return fmt.Errorf("must be an object")
+	}
+
+	var err error
+	r.ForEach(func(key, value gjson.Result) bool {
+		switch {
+		case key.Str == "ids" || key.Str == "authors" || (len(key.Str) > 1 && key.Str[0] == '#'):
+			if !value.IsArray() {
+				err = fmt.Errorf("'%s' must be an array of strings", key.Str)
+				return false
+			}
+			for _, item := range value.Array() {
+				if item.Type != gjson.String {
+					err = fmt.Errorf("'%s' must be an array of strings", key.Str)
+					return false
+				}
+			}
+		case key.Str == "kinds":
+			if !value.IsArray() {
+				err = fmt.Errorf("'kinds' must be an array of integers")
+				return false
+			}
+			for _, item := range value.Array() {
+				if !isWireInteger(item) {
+					err = fmt.Errorf("'kinds' must be an array of integers")
+					return false
+				}
+			}
+		case key.Str == "since" || key.Str == "until" || key.Str == "limit":
+			if !isWireInteger(value) {
+				err = fmt.Errorf("'%s' must be an integer", key.Str)
+			}
+		case key.Str == "search":
+			err = validateWireString(value, "'search'")
+		default:
+			err = fmt.Errorf("unexpected filter field '%s'", key.Str)
+		}
+		return err == nil
+	})
+	return err
+}
+
+func validateWireCountResult(r gjson.Result) error {
+	if !r.IsObject() {
+		return fmt.Errorf("count result must be an object")
+	}
+
+	var err error
+	r.ForEach(func(key, value gjson.Result) bool {
+		switch key.Str {
+		case "count":
+			if !isWireInteger(value) || value.Int() < 0 {
+				err = fmt.Errorf("'count' must be a non-negative integer")
+			}
+		case "approximate":
+			if !value.IsBool() {
+				err = fmt.Errorf("'approximate' must be a boolean")
+			}
+		case "hll":
+			if value.Type != gjson.String || len(value.Str) != 512 || !isLowerHex(value.Str) {
+				err = fmt.Errorf("'hll' must be 512 lowercase hex characters")
+			}
+		default:
+			err = fmt.Errorf("unexpected count result field '%s'", key.Str)
+		}
+		return err == nil
+	})
+	return err
+}
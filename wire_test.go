@@ -0,0 +1,101 @@
+package nostr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWireFormat(t *testing.T) {
+	evt := Event{Kind: KindTextNote, Content: "hello", CreatedAt: 1700000000, Tags: Tags{{"t", "test"}}}
+	require.NoError(t, evt.Sign(GeneratePrivateKey()))
+	e := evt.String()
+	id := evt.ID
+	pk := evt.PubKey
+	sig := evt.Sig
+	hll := strings.Repeat("0a", 256)
+
+	for _, tc := range []struct {
+		message string
+		valid   bool
+	}{
+		// valid frames
+		{`["EVENT",` + e + `]`, true},
+		{`["EVENT","sub1",` + e + `]`, true},
+		{`["REQ","sub1",{"kinds":[1],"authors":["` + pk + `"],"#t":["test"],"since":1,"limit":10}]`, true},
+		{`["REQ","sub1",{},{"ids":["` + id + `"],"search":"x"}]`, true},
+		{`["COUNT","sub1",{"kinds":[1]}]`, true},
+		{`["COUNT","sub1",{"count":12}]`, true},
+		{`["COUNT","sub1",{"count":12,"approximate":true,"hll":"` + hll + `"}]`, true},
+		{`["NOTICE","hello"]`, true},
+		{`["EOSE","sub1"]`, true},
+		{`["CLOSE","sub1"]`, true},
+		{`["CLOSED","sub1","error: no"]`, true},
+		{`["OK","` + id + `",true,""]`, true},
+		{`["OK","` + id + `",false,"blocked: no"]`, true},
+		{`["AUTH","challenge"]`, true},
+		{`["AUTH",` + e + `]`, true},
+
+		// subtly invalid frames
+		{`["EVENT","sub1"]`, false},
+		{`["EVENT",1,` + e + `]`, false},
+		{`["EVENT","sub1",` + e + `,"extra"]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":1.5,"kind":1,"tags":[],"content":"","sig":"` + sig + `"}]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":"1","kind":1,"tags":[],"content":"","sig":"` + sig + `"}]`, false},
+		{`["EVENT",{"id":"` + strings.ToUpper(id) + `","pubkey":"` + pk + `","created_at":1,"kind":1,"tags":[],"content":"","sig":"` + sig + `"}]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":1,"kind":1,"tags":[["t",1]],"content":"","sig":"` + sig + `"}]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":1,"kind":1,"tags":[],"content":"","sig":"` + sig[2:] + `"}]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":1,"kind":1,"tags":[],"sig":"` + sig + `"}]`, false},
+		{`["EVENT",{"id":"` + id + `","pubkey":"` + pk + `","created_at":1,"kind":1,"tags":[],"content":"","sig":"` + sig + `","extra":1}]`, false},
+		{`["REQ","sub1"]`, false},
+		{`["REQ",{"kinds":[1]}]`, false},
+		{`["REQ","sub1",{"kinds":["1"]}]`, false},
+		{`["REQ","sub1",{"authors":"` + pk + `"}]`, false},
+		{`["REQ","sub1",{"limit":-1.5}]`, false},
+		{`["REQ","sub1",{"whatever":1}]`, false},
+		{`["COUNT","sub1",{"count":-1}]`, false},
+		{`["COUNT","sub1",{"count":1,"hll":"abc"}]`, false},
+		{`["NOTICE"]`, false},
+		{`["NOTICE",1]`, false},
+		{`["EOSE","sub1","extra"]`, false},
+		{`["CLOSED","sub1"]`, false},
+		{`["OK","` + id + `","true",""]`, false},
+		{`["OK","` + id + `",true]`, false},
+		{`["OK","abc",true,""]`, false},
+		{`["AUTH",1]`, false},
+		{`["EVENT",` + e, false},
+		{`{"EVENT":1}`, false},
+		{`[]`, false},
+		{`[1,2]`, false},
+	} {
+		err := ValidateWireFormat([]byte(tc.message))
+		if tc.valid {
+			require.NoError(t, err, tc.message)
+		} else {
+			require.Error(t, err, tc.message)
+		}
+	}
+
+	// what we produce ourselves is always valid
+	count := int64(3)
+	since := Timestamp(1)
+	for _, env := range []Envelope{
+		&EventEnvelope{Event: evt},
+		&EventEnvelope{SubscriptionID: &id, Event: evt},
+		&ReqEnvelope{SubscriptionID: "s", Filters: Filters{{Kinds: []int{1}, Tags: TagMap{"t": {"x"}}, Since: &since}}},
+		&CountEnvelope{SubscriptionID: "s", Filters: Filters{{Authors: []string{pk}}}},
+		&CountEnvelope{SubscriptionID: "s", Count: &count},
+		&OKEnvelope{EventID: id, OK: true},
+		&ClosedEnvelope{SubscriptionID: "s", Reason: "x"},
+		&AuthEnvelope{Event: evt},
+	} {
+		j, err := env.MarshalJSON()
+		require.NoError(t, err)
+		require.NoError(t, ValidateWireFormat(j), string(j))
+	}
+
+	err := ValidateWireFormat([]byte(`["PING"]`))
+	require.True(t, errors.Is(err, UnknownLabel))
+}